@@ -0,0 +1,92 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package blockchain
+
+// blockchain/json-rpc.go implements a minimal client for the Monero daemon JSON-RPC interface, as
+// needed for checking daemon health in self-select mode.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// maximum # of bytes of daemon response we will read
+	MAX_RPC_RESPONSE_SIZE = 10 * 1024 * 1024
+)
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("daemon RPC error %d: %s", e.Code, e.Message)
+}
+
+// DoJSONRPC invokes method with the given params on the daemon JSON-RPC endpoint at url (e.g.
+// http://127.0.0.1:18081/json_rpc), and unmarshals the result into result. Errors returned by the
+// daemon are returned as errors.
+func DoJSONRPC(client *http.Client, url string, method string, params interface{}, result interface{}) error {
+	req := &struct {
+		JSONRPC string      `json:"jsonrpc"`
+		ID      string      `json:"id"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params,omitempty"`
+	}{"2.0", "0", method, params}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("daemon returned HTTP status %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, MAX_RPC_RESPONSE_SIZE))
+	if err != nil {
+		return err
+	}
+	r := &struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}{}
+	if err = json.Unmarshal(b, r); err != nil {
+		return err
+	}
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.Result == nil {
+		return errors.New("daemon response had no result")
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+// DaemonInfo queries the daemon at url using get_info, returning the current blockchain height and
+// whether the daemon is fully synced. Block templates should not be requested from a daemon that
+// isn't synced.
+func DaemonInfo(client *http.Client, url string) (height int, synced bool, err error) {
+	info := &struct {
+		Status       string `json:"status"`
+		Height       int    `json:"height"`
+		Synchronized bool   `json:"synchronized"`
+		BusySyncing  bool   `json:"busy_syncing"`
+		Offline      bool   `json:"offline"`
+	}{}
+	if err = DoJSONRPC(client, url, "get_info", nil, info); err != nil {
+		return 0, false, err
+	}
+	if info.Status != "" && info.Status != "OK" {
+		return info.Height, false, errors.New("daemon status: " + info.Status)
+	}
+	synced = info.Synchronized && !info.BusySyncing && !info.Offline
+	return info.Height, synced, nil
+}
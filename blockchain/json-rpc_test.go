@@ -0,0 +1,37 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package blockchain
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDaemonInfo(t *testing.T) {
+	responses := []string{
+		`{"id":"0","jsonrpc":"2.0","result":{"status":"OK","height":2100000,"synchronized":true,"busy_syncing":false}}`,
+		`{"id":"0","jsonrpc":"2.0","result":{"status":"OK","height":1000,"synchronized":false,"busy_syncing":true}}`,
+		`{"id":"0","jsonrpc":"2.0","error":{"code":-9,"message":"Core is busy"}}`,
+	}
+	i := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, responses[i])
+		i++
+	}))
+	defer ts.Close()
+
+	height, synced, err := DaemonInfo(ts.Client(), ts.URL)
+	if err != nil || height != 2100000 || !synced {
+		t.Errorf("expected synced daemon at height 2100000, got %v %v %v", height, synced, err)
+	}
+	height, synced, err = DaemonInfo(ts.Client(), ts.URL)
+	if err != nil || height != 1000 || synced {
+		t.Errorf("expected unsynced daemon at height 1000, got %v %v %v", height, synced, err)
+	}
+	_, _, err = DaemonInfo(ts.Client(), ts.URL)
+	if err == nil {
+		t.Error("expected error from daemon to be returned")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package blockchain
+
+// blockchain/rpc-auth.go implements the HTTP authentication a daemon started with --rpc-login
+// requires of JSON-RPC clients. monerod uses digest authentication (RFC 2617, MD5 with qop=auth),
+// but basic authentication is supported too for daemons behind a proxy that requires it.
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DaemonClient returns an http.Client for use with DoJSONRPC that authenticates to the daemon with
+// login, given in the "username:password" form of monerod's --rpc-login option. If login is empty
+// the client doesn't authenticate.
+func DaemonClient(login string, timeout time.Duration) (*http.Client, error) {
+	c := &http.Client{Timeout: timeout}
+	if login == "" {
+		return c, nil
+	}
+	i := strings.Index(login, ":")
+	if i < 1 {
+		return nil, errors.New("daemon RPC login must be of the form username:password")
+	}
+	c.Transport = &authTransport{username: login[:i], password: login[i+1:], base: http.DefaultTransport}
+	return c, nil
+}
+
+// authTransport answers the daemon's authentication challenge. Each request is first sent without
+// credentials, and resent with them if the daemon responds with a challenge. Daemon RPCs are
+// infrequent enough that the extra round trip isn't worth caching nonces to avoid.
+type authTransport struct {
+	username, password string
+	base               http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.GetBody == nil {
+		return nil, errors.New("request body can't be resent for authentication")
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, MAX_RPC_RESPONSE_SIZE))
+	resp.Body.Close()
+
+	req2 := req.Clone(req.Context())
+	if req.Body != nil {
+		if req2.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "digest":
+		auth, err := digestAuthorization(t.username, t.password, req.Method, req.URL.RequestURI(), params)
+		if err != nil {
+			return nil, err
+		}
+		req2.Header.Set("Authorization", auth)
+	case "basic":
+		req2.SetBasicAuth(t.username, t.password)
+	default:
+		return nil, fmt.Errorf("unsupported daemon authentication challenge: %q", challenge)
+	}
+	return t.base.RoundTrip(req2)
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lowercased scheme and its parameters.
+func parseChallenge(h string) (scheme string, params map[string]string) {
+	h = strings.TrimSpace(h)
+	i := strings.IndexByte(h, ' ')
+	if i < 0 {
+		return strings.ToLower(h), nil
+	}
+	scheme, h = strings.ToLower(h[:i]), h[i+1:]
+	params = map[string]string{}
+	for len(h) > 0 {
+		h = strings.TrimLeft(h, " ,")
+		eq := strings.IndexByte(h, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(h[:eq]))
+		h = h[eq+1:]
+		var val string
+		if strings.HasPrefix(h, "\"") {
+			end := strings.IndexByte(h[1:], '"')
+			if end < 0 {
+				val, h = h[1:], ""
+			} else {
+				val, h = h[1:end+1], h[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(h, ',')
+			if end < 0 {
+				end = len(h)
+			}
+			val, h = strings.TrimSpace(h[:end]), h[end:]
+		}
+		params[key] = val
+	}
+	return scheme, params
+}
+
+// digestAuthorization returns the Authorization header answering a digest challenge with the given
+// parameters.
+func digestAuthorization(username, password, method, uri string, params map[string]string) (string, error) {
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return "", errors.New("unsupported digest algorithm: " + alg)
+	}
+	qop := ""
+	if q, ok := params["qop"]; ok {
+		for _, o := range strings.Split(q, ",") {
+			if strings.TrimSpace(o) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", errors.New("unsupported digest qop: " + q)
+		}
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(b)
+	const nc = "00000001" // each challenge is answered only once
+	response := digestResponse(username, password, method, uri, params["realm"], params["nonce"], qop, nc, cnonce)
+
+	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5, response="%s"`,
+		username, params["realm"], params["nonce"], uri, response)
+	if qop != "" {
+		auth += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		auth += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return auth, nil
+}
+
+// digestResponse computes the response value of an RFC 2617 MD5 digest. qop is either "auth" or
+// empty for the RFC 2069 compatible form, in which case nc and cnonce are unused.
+func digestResponse(username, password, method, uri, realm, nonce, qop, nc, cnonce string) string {
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	if qop == "" {
+		return md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+	return md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package blockchain
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDigestResponse(t *testing.T) {
+	// example from RFC 2617 section 3.5
+	r := digestResponse("Mufasa", "Circle Of Life", "GET", "/dir/index.html", "testrealm@host.com",
+		"dcd98b7102dd2f0e8b11d0f600bfb0c093", "auth", "00000001", "0a4f113b")
+	if r != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("unexpected digest response: %v", r)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Digest qop="auth,auth-int", realm="monero-rpc", nonce="abc==", stale=false`)
+	if scheme != "digest" || params["qop"] != "auth,auth-int" || params["realm"] != "monero-rpc" ||
+		params["nonce"] != "abc==" || params["stale"] != "false" {
+		t.Errorf("unexpected challenge parse: %v %v", scheme, params)
+	}
+}
+
+func TestDaemonClientDigestAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params := parseChallenge(r.Header.Get("Authorization"))
+		want := digestResponse("user", "pass", r.Method, r.URL.RequestURI(), "monero-rpc", "n0nce",
+			params["qop"], params["nc"], params["cnonce"])
+		if params["response"] != want {
+			w.Header().Set("WWW-Authenticate", `Digest qop="auth", algorithm=MD5, realm="monero-rpc", nonce="n0nce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if len(body) == 0 {
+			t.Error("expected request body to be resent with credentials")
+		}
+		io.WriteString(w, `{"id":"0","jsonrpc":"2.0","result":{"status":"OK","height":5,"synchronized":true}}`)
+	}))
+	defer ts.Close()
+
+	c, err := DaemonClient("user:pass", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height, synced, err := DaemonInfo(c, ts.URL); err != nil || height != 5 || !synced {
+		t.Errorf("expected authenticated get_info to succeed, got %v %v %v", height, synced, err)
+	}
+	c, _ = DaemonClient("user:wrong", 10*time.Second)
+	if _, _, err := DaemonInfo(c, ts.URL); err == nil {
+		t.Error("expected wrong password to fail")
+	}
+	if _, err := DaemonClient("nopassword", 10*time.Second); err == nil {
+		t.Error("expected login without password separator to be rejected")
+	}
+}
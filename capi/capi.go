@@ -5,8 +5,11 @@ package main
 import "C"
 
 import (
+	"github.com/cryptonote-social/csminer/crylog"
 	"github.com/cryptonote-social/csminer/minerlib"
 	"github.com/cryptonote-social/csminer/minerlib/chat"
+
+	"encoding/json"
 )
 
 //export PoolLogin
@@ -32,6 +35,18 @@ func PoolLogin(
 	return resp.Code, C.CString(resp.Message)
 }
 
+//export SetDonation
+func SetDonation(percent float64) (code int, message *C.char) {
+	resp := minerlib.SetDonation(percent)
+	return resp.Code, C.CString(resp.Message)
+}
+
+//export UpdateConfig
+func UpdateConfig(config *C.char) (code int, message *C.char) {
+	resp := minerlib.UpdateConfig(C.GoString(config))
+	return resp.Code, C.CString(resp.Message)
+}
+
 //export InitMiner
 func InitMiner(threads int, excludeHrStart, excludeHrEnd int) (code int, message *C.char) {
 	args := &minerlib.InitMinerArgs{
@@ -43,6 +58,21 @@ func InitMiner(threads int, excludeHrStart, excludeHrEnd int) (code int, message
 	return resp.Code, C.CString(resp.Message)
 }
 
+// GetInitDetails returns the hardware acceleration status of the miner as initialized by InitMiner:
+// whether RandomX is using huge pages, the # of mining threads initialized and requested, and the
+// RandomX flags in effect, which are "unknown" with the current rxlib. threads is 0 if the miner
+// hasn't been successfully initialized. The caller must free rxFlags.
+//
+//export GetInitDetails
+func GetInitDetails() (
+	hugePages bool,
+	threads int,
+	configuredThreads int,
+	rxFlags *C.char) {
+	resp := minerlib.GetMiningState()
+	return resp.HugePages, resp.Threads, resp.ConfiguredThreads, C.CString(resp.RXFlags)
+}
+
 //export GetMinerState
 func GetMinerState() (
 	miningActivity int,
@@ -62,6 +92,28 @@ func GetMinerState() (
 		resp.ChatsAvailable
 }
 
+// GetMinerStateJSON returns the full miner state as a JSON object whose fields are those of
+// minerlib.GetMiningStateResponse. Unlike GetMinerState, new fields can be added without breaking
+// callers.
+//
+//export GetMinerStateJSON
+func GetMinerStateJSON() *C.char {
+	b, err := json.Marshal(minerlib.GetMiningState())
+	if err != nil {
+		crylog.Error("Failed to marshal miner state:", err)
+		return C.CString("{}")
+	}
+	return C.CString(string(b))
+}
+
+// GetActiveRXFlags returns the RandomX flags in effect, see rx.ActiveFlags. They're "unknown" until
+// rxlib is updated to report them. The caller must free the returned string.
+//
+//export GetActiveRXFlags
+func GetActiveRXFlags() *C.char {
+	return C.CString(minerlib.GetMiningState().RXFlags)
+}
+
 //export NextChat
 func NextChat() (
 	username *C.char,
@@ -90,11 +142,26 @@ func DecreaseThreads() {
 	minerlib.DecreaseThreads()
 }
 
+//export ResetStats
+func ResetStats() {
+	minerlib.ResetStats()
+}
+
+//export StopMining
+func StopMining() {
+	minerlib.StopMining()
+}
+
 //export OverrideMiningActivityState
 func OverrideMiningActivityState(mine bool) {
 	minerlib.OverrideMiningActivityState(mine)
 }
 
+//export SoftPauseMining
+func SoftPauseMining() {
+	minerlib.SoftPauseMining()
+}
+
 //export RemoveMiningActivityOverride
 func RemoveMiningActivityOverride() {
 	minerlib.RemoveMiningActivityOverride()
@@ -110,4 +177,9 @@ func ReportPowerState(onBattery bool) {
 	minerlib.ReportPowerState(onBattery)
 }
 
+//export ReportBlockingProcess
+func ReportBlockingProcess(active bool) {
+	minerlib.ReportBlockingProcess(active)
+}
+
 func main() {}
@@ -54,6 +54,30 @@ pool_login_response pool_login(const pool_login_args *args) {
   return response;
 }
 
+// set_donation changes the percentage (0-100) of earnings donated to the pool by logging in again
+// with an updated config string. Returns a response with the same semantics as pool_login. The
+// pool only honors the change if the login specified a wallet.
+pool_login_response set_donation(double percent) {
+  struct SetDonation_return r;
+  r = SetDonation(percent);
+  pool_login_response response;
+  response.code = (int)r.r0;
+  response.message = r.r1;
+  return response;
+}
+
+// update_config replaces the advanced options config string by logging in again with otherwise
+// the same login info. Session stats such as share counts are preserved across the change. Returns
+// a response with the same semantics as pool_login.
+pool_login_response update_config(const char* config) {
+  struct UpdateConfig_return r;
+  r = UpdateConfig((char*)config);
+  pool_login_response response;
+  response.code = (int)r.r0;
+  response.message = r.r1;
+  return response;
+}
+
 
 typedef struct init_miner_args {
   // threads specifies the initial # of threads to mine with. Must be >=1
@@ -72,7 +96,8 @@ typedef struct init_miner_response {
   //            slow. You can suggest to the user that a machine restart might help resolve this.
   //
   // code > 2: miner init failed due to bad config, see details in message. For example, an
-  //           invalid number of threads or invalid hour range may have been specified.
+  //           invalid number of threads or invalid hour range may have been specified, or the
+  //           miner may already have been initialized.
   //
   // code < 0: non-recoverable error, message will provide details. program should exit after
   //           showing message.
@@ -90,6 +115,32 @@ init_miner_response init_miner(const init_miner_args *args) {
   return response;
 }
 
+typedef struct get_init_details_response {
+  // true if RandomX is using huge pages. Mining without them may be slow.
+  bool huge_pages;
+
+  // # of mining threads initialized, and the # requested, which may be higher if some threads
+  // failed to initialize. threads is 0 if the miner hasn't been successfully initialized.
+  int threads;
+  int configured_threads;
+
+  // space separated RandomX flags in effect, e.g. "large_pages hard_aes full_mem jit", or
+  // "unknown" (see get_active_rx_flags)
+  const char* rx_flags; // must be freed by the caller
+} get_init_details_response;
+
+// get_init_details returns the hardware acceleration status of the miner as initialized by
+// init_miner.
+get_init_details_response get_init_details() {
+  struct GetInitDetails_return r = GetInitDetails();
+  get_init_details_response response;
+  response.huge_pages = (bool)r.r0;
+  response.threads = (int)r.r1;
+  response.configured_threads = (int)r.r2;
+  response.rx_flags = r.r3;
+  return response;
+}
+
 typedef struct get_miner_state_response {
   // Valid values for mining_activity fall into two cateogories: MINING_PAUSED (all < 0)
   // and MINING_ACTIVE (all > 0)
@@ -113,6 +164,22 @@ typedef struct get_miner_state_response {
   //    MINING_PAUSED_NO_LOGIN = -7
   //     indicates miner is paused because we're in the user-excluded time period
   //
+  //    MINING_PAUSED_TOO_MANY_REJECTS = -8
+  //     indicates miner is paused because shares continued to be rejected even after reconnecting
+  //     to the pool. Overriding the mining state will resume mining.
+  //
+  //    MINING_PAUSED_PROCESS = -9
+  //     indicates miner is paused because a process the user asked to pause mining for is
+  //     running, as reported by report_blocking_process.
+  //
+  //    MINING_PAUSED_TEMPERATURE = -10
+  //     indicates miner is paused because the CPU reached the configured maximum temperature, and
+  //     has yet to cool down to the resume temperature.
+  //
+  //    MINING_PAUSED_TIMED = -11
+  //     indicates miner is paused because the user paused it until a specified time, after which
+  //     it resumes automatically.
+  //
   //	MINING_ACTIVE = 1
   //     indicates miner is actively mining
   //
@@ -178,6 +245,28 @@ get_miner_state_response get_miner_state() {
   return response;
 }
 
+// Returns the full miner state as a JSON object, e.g. {"MiningActivity":1,"Threads":2,...}. This
+// includes every field of get_miner_state_response along with any newer stats, and unlike
+// get_miner_state, fields can be added in future versions without breaking callers, so prefer
+// this when your language has a convenient JSON parser.
+//
+// NOTE: you must free() the returned string
+const char* get_miner_state_json() {
+  return GetMinerStateJSON();
+}
+
+// Returns a space separated list of the RandomX flags in effect, e.g. "large_pages hard_aes
+// full_mem jit", or "unknown" if they could not be determined. Any of hard_aes, full_mem, or jit
+// missing indicates a slow fallback is in use, which is the most common cause of low hashrate.
+//
+// NOTE: the flags can only be determined with an rxlib that exports rx_get_flags, which the
+// current rxlib does not, so for now this always returns "unknown".
+//
+// NOTE: you must free() the returned string
+const char* get_active_rx_flags() {
+  return GetActiveRXFlags();
+}
+
 typedef struct next_chat_response {
   // NOTE: you must free() each const char*
   const char* username; // username of the user who sent the chat (ascii)
@@ -218,12 +307,31 @@ void decrease_threads() {
   DecreaseThreads();
 }
 
+// Reset all session stats (shares, hashes, and hashrate since inception) to zero. Pool stats such
+// as lifetime hashes and amounts paid are unaffected.
+void reset_stats() {
+  ResetStats();
+}
+
+// Stop mining and log out of the pool, closing the pool connection and flushing the event log.
+// Call this before your application exits. Mining can be restarted with pool_login.
+void stop_mining() {
+  StopMining();
+}
+
 // override_mining_state can be used to force mining, or force mining to pause depending on the
 // value of the parameter.
 void override_mining_activity_state(bool mine) {
   OverrideMiningActivityState(mine);
 }
  
+// soft_pause_mining pauses mining like override_mining_activity_state(false), but the recent
+// hashrate is preserved if mining is resumed (with remove_mining_activity_override) within a few
+// minutes. Useful for brief pauses.
+void soft_pause_mining() {
+  SoftPauseMining();
+}
+
 // remove_mining_override will revert any previous overridden mining state and allow the
 // miner to use its usual means of determining when to mine.
 void remove_mining_activity_override() {
@@ -244,3 +352,10 @@ void report_lock_screen_state(bool locked) {
 void report_power_state(bool on_battery_power) {
   ReportPowerState(on_battery_power);
 }
+
+// report_blocking_process is used to tell the miner when any process the user asked to pause
+// mining for (e.g. a game or video editor) is running (true), or when none are (false).
+// On startup and before this method is ever invoked the miner will assume none are running.
+void report_blocking_process(bool active) {
+  ReportBlockingProcess(active);
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package csminer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/cryptonote-social/csminer/crylog"
+	"github.com/cryptonote-social/csminer/minerlib"
+)
+
+const (
+	// control API endpoint returning the JSON encoded minerlib.GetMiningStateResponse
+	CONTROL_STATE_PATH = "/state"
+
+	ATTACH_TIMEOUT = 10 * time.Second
+)
+
+// controlHandler returns the handler serving the control API, which also exports the mining state
+// as Prometheus metrics. The API is read-only so that exposing it can't allow anyone to change how
+// the miner operates.
+func controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(CONTROL_STATE_PATH, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(minerlib.GetMiningState()); err != nil {
+			crylog.Warn("Failed to write miner state:", err)
+		}
+	})
+	mux.HandleFunc(CONTROL_METRICS_PATH, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+		writeMetrics(w, minerlib.GetMiningState())
+	})
+	return mux
+}
+
+// startControlServer serves the control API at addr, binding to localhost if addr has no host.
+func startControlServer(addr string) error {
+	l, err := listenLocal(addr)
+	if err != nil {
+		return err
+	}
+	crylog.Info("Serving control API at http://" + l.Addr().String() + CONTROL_STATE_PATH)
+	go func() {
+		if err := http.Serve(l, controlHandler()); err != nil {
+			crylog.Error("control server failed:", err)
+		}
+	}()
+	return nil
+}
+
+// fetchState retrieves the mining state from the control API at url.
+func fetchState(c *http.Client, url string) (*minerlib.GetMiningStateResponse, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("control API returned status: " + resp.Status)
+	}
+	s := &minerlib.GetMiningStateResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// attach presents the stats of the miner whose control API is at addr, returning the process exit
+// code once the user detaches.
+func attach(addr string) int {
+	c := &http.Client{Timeout: ATTACH_TIMEOUT}
+	url := "http://" + addr + CONTROL_STATE_PATH
+	s, err := fetchState(c, url)
+	if err != nil {
+		crylog.Error("Failed to attach to miner at", addr+":", err)
+		return EXIT_FAILURE
+	}
+	crylog.Info("Attached to miner at", addr, "(read-only)")
+	printState(s, false)
+	printAttachedKeyboardCommands()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "h", "s", "p":
+			s, err := fetchState(c, url)
+			if err != nil {
+				crylog.Error("Failed to get miner state:", err)
+				continue
+			}
+			printState(s, false)
+		case "q", "quit", "exit":
+			crylog.Info("detaching due to keyboard command")
+			return EXIT_OK
+		default:
+			printAttachedKeyboardCommands()
+		}
+	}
+	return EXIT_OK
+}
+
+func printAttachedKeyboardCommands() {
+	crylog.Info("")
+	crylog.Info("Keyboard commands:")
+	crylog.Info("   s: print miner stats")
+	crylog.Info("   q: detach, leaving the miner running")
+	crylog.Info("")
+}
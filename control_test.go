@@ -0,0 +1,32 @@
+package csminer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlState(t *testing.T) {
+	srv := httptest.NewServer(controlHandler())
+	defer srv.Close()
+
+	s, err := fetchState(srv.Client(), srv.URL+CONTROL_STATE_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BatteryPercent != -1 {
+		t.Errorf("expected unknown battery level, got %v", s.BatteryPercent)
+	}
+
+	resp, err := srv.Client().Post(srv.URL+CONTROL_STATE_PATH, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected control API to be read-only, got status %v", resp.Status)
+	}
+	if _, err = fetchState(srv.Client(), srv.URL+"/nonexistent"); err == nil {
+		t.Error("expected error for missing endpoint")
+	}
+}
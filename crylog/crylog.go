@@ -7,6 +7,7 @@ package crylog
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strconv"
@@ -17,41 +18,106 @@ import (
 
 var (
 	mu  sync.Mutex
-	buf []byte   = make([]byte, 0)
-	fd  *os.File = os.Stderr
+	buf []byte    = make([]byte, 0)
+	fd  io.Writer = os.Stderr
+
+	showFileAndLine = true
+
+	// included in each log line if non-empty, see SetLabel
+	label string
 
 	EXIT_ON_LOG_FATAL = flag.Bool(
 		"exit-on-log-fatal", false, "whether to exit if a fatal error is logged")
 )
 
 func Info(v ...interface{}) {
-	doLog("INFO", v)
+	doLog("INFO", 0, v)
 }
 
 func Warn(v ...interface{}) {
-	doLog("WARN", v)
+	doLog("WARN", 0, v)
 }
 
 func Error(v ...interface{}) {
-	doLog("ERROR", v)
+	doLog("ERROR", 0, v)
 }
 
 func Fatal(v ...interface{}) {
-	doLog("FATAL", v)
+	doLog("FATAL", 0, v)
 	if *EXIT_ON_LOG_FATAL {
 		os.Exit(1)
 	}
 }
 
+// InfoDepth acts as Info but uses depth to determine which call frame's file and line to log. A
+// depth of 0 is equivalent to calling Info, a depth of 1 reports the caller of the function that
+// called InfoDepth, and so on. Useful for logging wrapper functions.
+func InfoDepth(depth int, v ...interface{}) {
+	doLog("INFO", depth, v)
+}
+
+// WarnDepth acts as Warn but uses depth to determine the call frame to log (see InfoDepth).
+func WarnDepth(depth int, v ...interface{}) {
+	doLog("WARN", depth, v)
+}
+
+// ErrorDepth acts as Error but uses depth to determine the call frame to log (see InfoDepth).
+func ErrorDepth(depth int, v ...interface{}) {
+	doLog("ERROR", depth, v)
+}
+
+// FatalDepth acts as Fatal but uses depth to determine the call frame to log (see InfoDepth).
+func FatalDepth(depth int, v ...interface{}) {
+	doLog("FATAL", depth, v)
+	if *EXIT_ON_LOG_FATAL {
+		os.Exit(1)
+	}
+}
+
+// SetShowFileAndLine determines whether the file name and line number of the logging call site are
+// included in each log line. Defaults to true.
+func SetShowFileAndLine(show bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	showFileAndLine = show
+}
+
+// SetLabel sets a label identifying this process, e.g. the name of the machine, that is included
+// in brackets after the timestamp of each log line. This distinguishes the output of several
+// processes when their logs are aggregated. An empty label disables it.
+func SetLabel(l string) {
+	mu.Lock()
+	defer mu.Unlock()
+	label = l
+}
+
+// SetOutput directs all further log output to the file at filePath, appending to it if it already
+// exists. On error the current output is left unchanged.
 func SetOutput(filePath string) error {
 	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0664)
 	if err != nil {
 		return err
 	}
+	mu.Lock()
+	defer mu.Unlock()
 	fd = f
 	return nil
 }
 
+// Writer returns the writer log output is currently directed to.
+func Writer() io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	return fd
+}
+
+// SetWriter directs all further log output to w, which receives one complete log line per Write.
+func SetWriter(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	fd = w
+}
+
 // formatFileAndLine is a helper func that returns a formatted string containing the filename and
 // line number of where the logging call was invoked from.
 func formatFileAndLine(buf *[]byte, depth int) {
@@ -70,14 +136,23 @@ func formatFileAndLine(buf *[]byte, depth int) {
 	*buf = append(*buf, ')')
 }
 
-func doLog(prefix string, v []interface{}) {
+// depth is the number of additional call frames to skip beyond the caller of the exported logging
+// function when determining file and line.
+func doLog(prefix string, depth int, v []interface{}) {
 	now := time.Now()
 	mu.Lock()
 	defer mu.Unlock()
 	buf = buf[:0]
 	formatHeader(&buf, now)
+	if label != "" {
+		buf = append(buf, '[')
+		buf = append(buf, label...)
+		buf = append(buf, "] "...)
+	}
 	buf = append(buf, prefix...)
-	formatFileAndLine(&buf, 3)
+	if showFileAndLine {
+		formatFileAndLine(&buf, 3+depth)
+	}
 	buf = append(buf, ": "...)
 	buf = append(buf, fmt.Sprintln(v...)...)
 	_, err := fd.Write(buf)
@@ -1,6 +1,8 @@
 package crylog
 
 import (
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -19,6 +21,21 @@ func TestErrorLog(t *testing.T) {
 	Error("this is an error logging test")
 }
 
+func logWrapper(msg string) {
+	InfoDepth(1, msg)
+}
+
+func TestInfoDepthLog(t *testing.T) {
+	// file/line should be that of this test function rather than logWrapper
+	logWrapper("this is an info depth logging test")
+}
+
+func TestNoFileAndLineLog(t *testing.T) {
+	SetShowFileAndLine(false)
+	defer SetShowFileAndLine(true)
+	Info("this is an info logging test without file and line")
+}
+
 func TestFatalLog(t *testing.T) {
 	exit := false
 	EXIT_ON_LOG_FATAL = &exit
@@ -27,3 +44,41 @@ func TestFatalLog(t *testing.T) {
 	// exit = true
 	// Fatal("this is a fatal logging test")
 }
+
+func TestSetOutput(t *testing.T) {
+	orig := Writer()
+	defer SetWriter(orig)
+	path := t.TempDir() + "/csminer.log"
+	if err := SetOutput(path); err != nil {
+		t.Fatal("SetOutput failed:", err)
+	}
+	Info("this is a log file test")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("couldn't read log file:", err)
+	}
+	if !strings.Contains(string(b), "this is a log file test") {
+		t.Errorf("expected log line in file, got: %q", b)
+	}
+	if err := SetOutput(t.TempDir() + "/missing/csminer.log"); err == nil {
+		t.Error("expected error opening log file in missing directory")
+	}
+	if Writer() == orig {
+		t.Error("expected failed SetOutput to leave output unchanged")
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	orig := Writer()
+	defer SetWriter(orig)
+	var b strings.Builder
+	SetWriter(&b)
+	SetLabel("living-room-pc")
+	Info("labeled")
+	SetLabel("")
+	Info("unlabeled")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], " [living-room-pc] INFO") || strings.Contains(lines[1], "[") {
+		t.Errorf("unexpected labeled output: %q", lines)
+	}
+}
@@ -3,20 +3,41 @@
 package csminer
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/cryptonote-social/csminer/crylog"
+	"github.com/cryptonote-social/csminer/minerlib"
+	"github.com/cryptonote-social/csminer/rx"
+	"github.com/cryptonote-social/csminer/stratum/client"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const (
 	APPLICATION_NAME = "cryptonote.social Monero miner"
 	VERSION_STRING   = "0.3.3"
 	STATS_WEBPAGE    = "https://cryptonote.social/xmr"
-	DONATE_USERNAME  = "donate-getmonero-org"
+	DONATE_USERNAME  = minerlib.DONATE_USERNAME
 
 	INVALID_EXCLUDE_FORMAT_MESSAGE = "invalid format for exclude specified. Specify XX-YY, e.g. 11-16 for 11:00am to 4:00pm."
+
+	// Process exit codes returned by MultiMain.
+	EXIT_OK            = 0 // clean quit, e.g. via keyboard command
+	EXIT_FAILURE       = 1 // unexpected failure
+	EXIT_BAD_CONFIG    = 2 // invalid flags or configuration
+	EXIT_LOGIN_REFUSED = 3 // the pool refused the login
+	EXIT_INIT_FAILED   = 4 // RandomX or other miner initialization failed
 )
 
 var (
@@ -24,14 +45,57 @@ var (
 	t       = flag.Int("threads", 1, "number of threads")
 	uname   = flag.String("user", DONATE_USERNAME, "your pool username from https://cryptonote.social/xmr")
 	rigid   = flag.String("rigid", "csminer", "your rig id")
+	label   = flag.String("label", "", "name for this miner instance included in logs, stats, and webhook events")
 	tls     = flag.Bool("tls", false, "whether to use TLS when connecting to the pool")
 	exclude = flag.String("exclude", "", "pause mining during these hours, e.g. -exclude=11-16 will pause mining between 11am and 4pm")
 	config  = flag.String("config", "", "advanced pool configuration options, e.g. start_diff=1000;donate=1.0")
 	wallet  = flag.String("wallet", "", "your wallet id. only specify this when establishing a new username, or specifying a 'secure' config change such as a change in donation amount")
 	dev     = flag.Bool("dev", false, "whether to connect to dev server")
+
+	hashrateSmoothing    = flag.Float64("hashrate-smoothing", 0.3, "weight (0-1] of each new pool hashrate sample when estimating time to next reward, 1 to disable smoothing")
+	poolStatsInterval    = flag.Duration("pool-stats-interval", minerlib.DEFAULT_POOL_STATS_REFRESH_INTERVAL, "minimum time between pool stats refreshes triggered by accepted shares, negative to disable them")
+	poolReadTimeout      = flag.Duration("pool-read-timeout", client.DEFAULT_READ_TIMEOUT, "reconnect if nothing is received from the pool for this long")
+	datasetCheck         = flag.Duration("dataset-check", 0, "verify the RandomX dataset hasn't been corrupted this often, e.g. 1h, reseeding if it has. 0 to disable")
+	tui                  = flag.Bool("tui", false, "show a live-updating dashboard instead of periodic stats printouts")
+	notify               = flag.Bool("notify", false, "show desktop notifications when mining starts or stops, shares are accepted, or the connection drops")
+	controlAddr          = flag.String("control", "", "serve the read-only control API at this address, e.g. :8080. Binds to localhost if no host is given")
+	attachAddr           = flag.String("attach", "", "attach to the control API of an already running miner at this address instead of mining")
+	pprofAddr            = flag.String("pprof", "", "serve Go profiling endpoints at this address, e.g. :6060. Binds to localhost if no host is given")
+	version              = flag.Bool("version", false, "print version information and exit")
+	webhookURL           = flag.String("webhook", "", "URL to POST a JSON event to for every accepted share and found block")
+	duration             = flag.Duration("duration", 0, "stop mining and exit after this long, e.g. 4h, 0 to mine indefinitely")
+	minDiff              = flag.Int64("min-diff", 0, "only submit shares meeting at least this difficulty, 0 to submit all shares meeting the job difficulty")
+	intensity            = flag.Int("intensity", 100, "approximate percentage (1-100) of full utilization each thread mines at")
+	maxRejected          = flag.Int("max-rejected-before-reconnect", 20, "force a pool reconnect after this many consecutive rejected shares, 0 to disable")
+	selfTest             = flag.Bool("self-test", false, "verify RandomX produces correct hashes at startup")
+	minHashrateWindow    = flag.Duration("min-hashrate-window", 5*time.Second, "minimum mining time before the current hashrate is considered accurate")
+	hashStallTimeout     = flag.Duration("hash-stall-timeout", 2*time.Minute, "restart mining threads if no hashes are computed for this long while mining, 0 to disable")
+	refreshInterval      = flag.Duration("worker-refresh-interval", 30*time.Second, "how often mining threads tally their hashes, at most half of -hash-stall-timeout")
+	noJobTimeout         = flag.Duration("no-job-timeout", 10*time.Minute, "reconnect to the pool if no new job is received for this long, 0 to disable")
+	priority             = flag.String("priority", "normal", "scheduling priority of the miner, either low or normal")
+	bindAddr             = flag.String("bind-addr", "", "local IP address to connect to the pool from, selecting the network interface to use")
+	doh                  = flag.Bool("doh", false, "resolve the pool hostname only via DNS over HTTPS instead of falling back to it when DNS fails")
+	dohURL               = flag.String("doh-url", client.DEFAULT_DOH_URL, "DNS over HTTPS endpoint supporting the JSON API")
+	proxy                = flag.String("proxy", "", "http, https, or socks5 proxy URL for pool connections and pool stats requests, e.g. socks5://127.0.0.1:9050")
+	logFile              = flag.String("log-file", "", "append log output to this file instead of writing it to stderr")
+	eventLog             = flag.String("event-log", "", "append a JSON record of every share result and mining state change to this file")
+	submitConn           = flag.Bool("submit-connection", false, "submit shares over a second pool connection so submissions don't contend with reading jobs")
+	multiclient          = flag.Bool("multiclient", false, "submit shares in the multiclient form used by profit-maximizing services, naming the user, rig and difficulty they're for")
+	warmStandby          = flag.Bool("warm-standby", false, "maintain a second pool connection to switch to immediately if the first one drops")
+	pauseGrace           = flag.Duration("pause-grace", 0, "keep mining for this long after the screen becomes active, e.g. 30s")
+	maxTemp              = flag.Float64("max-temp", 0, "pause mining when the CPU reaches this temperature in degrees C, 0 to disable")
+	resumeTemp           = flag.Float64("resume-temp", 0, "with -max-temp, resume mining once the CPU cools to this temperature in degrees C, default 10 below -max-temp")
+	thermalStabilize     = flag.Duration("thermal-stabilize", time.Minute, "with -max-temp, how long to wait between adding threads back after cooling down")
+	pauseProcesses       = flag.String("pause-processes", "", "comma separated names of processes to pause mining for while they're running, e.g. obs,steam")
+	quietShares          = flag.Bool("quiet-shares", false, "don't log each share found")
+	dumpFirstJob         = flag.Bool("dump-job", false, "log into the pool, print the first job received, and exit")
+	optimize             = flag.Bool("optimize", false, "benchmark each thread count at startup and mine with the one giving the best hashrate")
+	submitOnlyWhenMining = flag.Bool("submit-only-when-mining", false, "abandon shares found before mining was paused or the job changed instead of submitting them")
 )
 
-func MultiMain(s MachineStater, agent string) {
+// MultiMain runs the miner with the configuration specified by the command line flags, returning the
+// process exit code, which is one of the EXIT_* codes.
+func MultiMain(s MachineStater, agent string) int {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "==== %s %s ====\n", APPLICATION_NAME, VERSION_STRING)
 		fmt.Fprint(flag.CommandLine.Output(),
@@ -40,6 +104,25 @@ func MultiMain(s MachineStater, agent string) {
     	your pool username from https://cryptonote.social/xmr (default "donate-getmonero-org")
   -saver=<bool>
     	mine only when screen is locked (default true)
+  -pause-grace <duration>
+        with -saver, keep mining for this long after the screen becomes active, and don't pause
+        at all if it's idle again by then, e.g. -pause-grace=30s. Avoids stopping and restarting
+        mining over brief interactions such as clicking a notification. (default 0)
+  -max-temp <float>
+        pause mining whenever the CPU temperature reaches this many degrees C, e.g.
+        -max-temp=85. Once the CPU cools to -resume-temp, mining resumes with one thread and
+        threads are added back one at a time every -thermal-stabilize while the temperature
+        stays below the max, settling at the most threads the machine can sustain. Linux only.
+        0 disables. (default 0)
+  -resume-temp <float>
+        temperature in degrees C at which mining resumes after reaching -max-temp. (default 10
+        below -max-temp)
+  -thermal-stabilize <duration>
+        how long the temperature is given to settle after each thread is added back. (default 1m)
+  -pause-processes <string>
+        pause mining while any of these comma separated processes are running, e.g.
+        -pause-processes=obs,steam,blender. Names are matched ignoring case and any .exe
+        extension. Running processes are checked every 10 seconds.
   -exclude <string>
         pause mining during the specified hours. Format is XX-YY where XX and YY are hours of
         the day designated in 24 hour time. For example, -exclude=11-16 will pause mining betwen
@@ -47,8 +130,17 @@ func MultiMain(s MachineStater, agent string) {
         machine usage or high electricity rates.
   -threads <int>
     	number of threads (default 1)
+  -optimize=<bool>
+        before mining, benchmark the hashrate of each thread count from 1 up to the number of
+        CPUs and mine with the best, preferring fewer threads when more add under 2% hashrate.
+        Takes a few seconds per CPU and overrides -threads. (default false)
   -rigid <string>
-    	your rig id (default "csminer")
+    	your rig id. The tokens {hostname}, {pid}, {os} and {arch} are replaced with those of this
+    	machine, e.g. -rigid=csminer-{hostname} (default "csminer")
+  -label <string>
+        a name for this miner instance, e.g. -label=living-room-pc, included in every log line,
+        event log record, webhook event and the control API state, for telling instances apart
+        when aggregating their logs and stats. Supports the same tokens as -rigid.
   -tls <bool>
        whether to use TLS when connecting to the pool (default false)
   -config <string>
@@ -60,11 +152,177 @@ func MultiMain(s MachineStater, agent string) {
         your wallet id. You only need to specify this when establishing a new username, or if
         specifying a 'secure' config parameter change such as a new pool donation amount or email
         address. New usernames will be established upon submitting at least one valid share.
+  -submit-only-when-mining=<bool>
+        abandon rather than submit any share if mining was paused or the job changed after the
+        share was found, avoiding stale share rejections (default false)
+  -quiet-shares=<bool>
+        don't log a line for every share found, which can flood the log on fast machines mining
+        at a low difficulty. Share counts remain available in the stats. (default false)
+  -warm-standby=<bool>
+        maintain a second, idle connection to the pool that is switched to immediately should
+        the first connection drop, reducing mining downtime on flaky networks (default false)
+  -submit-connection=<bool>
+        submit shares over a second, dedicated connection to the pool so that submissions
+        don't contend with receiving jobs, which can reduce share latency on fast machines.
+        Falls back to the main connection if the second can't be established. (default false)
+  -multiclient=<bool>
+        submit shares in the multiclient form used by profit-maximizing services, which names the
+        user & rig each share is for (the -user and -rigid login) and the difficulty of its job,
+        rather than crediting it to the connection's login. Chats can't be sent this way, so
+        shares found while a chat is waiting to be sent are submitted normally. (default false)
+  -max-rejected-before-reconnect <int>
+        force a reconnect to the pool after this many consecutive shares are rejected, pausing
+        mining if rejects persist after several reconnects. 0 disables. (default 20)
+  -log-file <string>
+        append log output to this file instead of writing it to stderr. With -tui, log output
+        is shown in the dashboard as well. If the file can't be opened, logging falls back to
+        stderr.
+  -event-log <string>
+        path of a file to which a JSON record (one per line) is appended for every share found
+        and every change in mining state, for later analysis of a mining session
+  -proxy <string>
+        URL of an http, https, or socks5 proxy through which to connect to the pool and fetch
+        pool stats, e.g. socks5://127.0.0.1:9050. The proxy resolves the pool's hostname, so
+        -doh has no effect. If unspecified, pool connections are direct, and the HTTP_PROXY &
+        HTTPS_PROXY environment variables are honored for pool stats only.
+  -bind-addr <string>
+        local IP address from which to connect to the pool and fetch pool stats, which selects
+        the network interface used on machines with several, e.g. a VPN and a LAN.
+  -doh
+        resolve the pool hostname only via DNS over HTTPS, for networks where plain DNS is
+        blocked or tampered with. Without it, DNS over HTTPS is used only when regular DNS
+        resolution of the pool hostname fails.
+  -doh-url <string>
+        DNS over HTTPS endpoint, which must support the JSON API (application/dns-json).
+        (default "https://1.1.1.1/dns-query")
+  -priority <string>
+        scheduling priority of the mining threads, either "low" or "normal". Use low to keep
+        the machine responsive while mining alongside interactive work. (default "normal")
+  -no-job-timeout <duration>
+        reconnect to the pool if it sends no new job for this long despite the connection
+        appearing alive, e.g. -no-job-timeout=15m. 0 disables. (default 10m)
+  -min-hashrate-window <duration>
+        minimum time spent mining before the current hashrate is considered accurate. Before
+        then a provisional hashrate is reported. (default 5s)
+  -self-test=<bool>
+        verify at startup that RandomX computes the correct hash for a known test vector,
+        catching incompatible or miscompiled RandomX libraries. Slows startup. Requires a RandomX
+        library that can hash arbitrary input; the miner exits with code 2 otherwise.
+        (default false)
+  -intensity <int>
+        approximate percentage of full utilization each thread mines at, e.g. -intensity=60
+        to reduce heat and fan noise without removing a whole thread. (default 100)
+  -min-diff <int>
+        only submit shares meeting at least this difficulty, reducing submit overhead on very
+        fast machines mining against a low difficulty. Hashes are still counted, but since the
+        pool credits each share at the job difficulty, also consider raising it with the
+        start_diff config option. 0 disables. (default 0)
+  -version
+        print version and build information, then exit. The RandomX library version shows as
+        unknown until rxlib is updated to report it.
+  -dump-job
+        log into the pool, print every field parsed from the first job received (blob, target,
+        seed hash, height, difficulty, reward, self-select fields, chat token, etc.), then exit
+        without mining. Useful for diagnosing pool compatibility problems.
+  -hashrate-smoothing <float>
+        weight given to each new pool hashrate sample in the moving average used to estimate the
+        time to next reward. Lower values give a steadier estimate; 1 disables smoothing.
+        (default 0.3)
+  -pool-stats-interval <duration>
+        minimum time between the pool stats refreshes that accepted shares can trigger, however
+        fast shares are found. A negative value disables them, so pool stats are only updated at
+        login and along with chats and share results. (default 1m)
+  -pool-read-timeout <duration>
+        reconnect to the pool if nothing is received from it for this long, detecting connections
+        that died without being closed. Idle connections are kept alive with periodic requests,
+        so this can be short. Must be at least 10s. (default 2m)
+  -dataset-check <duration>
+        how often to check that the RandomX dataset hasn't been corrupted, e.g. by a bit flip on
+        a machine without ECC memory, rebuilding it if it has. Corruption otherwise causes a
+        stream of rejected shares until the next seed change. 0 disables. e.g. 1h. Like
+        -self-test, requires a RandomX library that can hash arbitrary input. (default 0)
+  -hash-stall-timeout <duration>
+        restart the mining threads if they compute no hashes for this long while mining is
+        active, recovering from threads that stopped unexpectedly. 0 disables. (default 2m)
+  -worker-refresh-interval <duration>
+        how often the mining threads report the hashes they've computed, without stopping. A
+        longer interval updates the reported hashrate less often. Must be at most half of
+        -hash-stall-timeout. (default 30s)
+  -notify=<bool>
+        show desktop notifications when mining starts or stops, shares are accepted, or the
+        pool connection drops. (default false)
+  -webhook <url>
+        POST a small JSON event to this URL for every accepted share and found block, e.g. for
+        home automation or custom alerts. Includes the event, job id, difficulty, timestamp, and
+        session share totals. Events are dropped if the webhook can't keep up. Off by default.
+  -tui=<bool>
+        show a live-updating dashboard of hashrate, shares, pool earnings, chats and log output
+        in place of the periodic stats printouts. Requires an ANSI-capable terminal. (default false)
+  -control <address>
+        serve a read-only control API at this address, e.g. -control=:8080, allowing the miner to
+        be checked on with -attach. Binds to localhost unless a host is specified. Prometheus
+        metrics, including histograms of share difficulty and submit latency, are served at
+        /metrics. Off by default.
+  -attach <address>
+        instead of mining, attach to the control API of a miner already running with -control
+        at this address, e.g. -attach=localhost:8080, to view its stats.
+  -pprof <address>
+        serve Go profiling endpoints (net/http/pprof) at this address for diagnosing the miner,
+        e.g. -pprof=:6060. Binds to localhost unless a host is specified. Off by default.
+  -duration <duration>
+        stop mining and exit cleanly once the miner has run for this long, e.g. -duration=4h.
+        Time spent paused, e.g. during -exclude hours, counts toward the duration. 0 mines
+        indefinitely. (default 0)
+
+Exit codes:
+  0  quit via keyboard command, signal, or -duration elapsing
+  1  unexpected failure
+  2  invalid flags or configuration
+  3  pool refused the login
+  4  RandomX or other miner initialization failed
 `)
 		fmt.Fprintf(flag.CommandLine.Output(), "\nMonitor your miner progress at: %s\n", STATS_WEBPAGE)
 		fmt.Fprint(flag.CommandLine.Output(), "Send feedback to: cryptonote.social@gmail.com\n")
 	}
 	flag.Parse()
+	if *logFile != "" {
+		if err := crylog.SetOutput(*logFile); err != nil {
+			crylog.Warn("Could not open log file, logging to stderr instead:", err)
+			*logFile = ""
+		}
+	}
+	*label = expandTemplate(*label)
+	crylog.SetLabel(*label)
+	if *version {
+		printVersion()
+		return EXIT_OK
+	}
+	if *attachAddr != "" {
+		return attach(*attachAddr)
+	}
+	if *duration < 0 {
+		crylog.Error("-duration can't be negative:", *duration)
+		return EXIT_BAD_CONFIG
+	}
+	handleShutdownSignals()
+	if *duration > 0 {
+		crylog.Info("Will stop mining after:", *duration)
+		time.AfterFunc(*duration, func() {
+			shutdown("mining duration of " + duration.String() + " elapsed")
+		})
+	}
+	if *controlAddr != "" {
+		if err := startControlServer(*controlAddr); err != nil {
+			crylog.Error("failed to start control server:", err)
+			return EXIT_BAD_CONFIG
+		}
+	}
+	if *pprofAddr != "" {
+		if err := startPprof(*pprofAddr); err != nil {
+			crylog.Error("failed to start pprof server:", err)
+			return EXIT_BAD_CONFIG
+		}
+	}
 
 	var hr1, hr2 int
 	hr1 = -1
@@ -72,24 +330,40 @@ func MultiMain(s MachineStater, agent string) {
 	if len(*exclude) > 0 {
 		hrs := strings.Split(*exclude, "-")
 		if len(hrs) != 2 {
-			crylog.Fatal(INVALID_EXCLUDE_FORMAT_MESSAGE)
-			return
+			crylog.Error(INVALID_EXCLUDE_FORMAT_MESSAGE)
+			return EXIT_BAD_CONFIG
 		}
 		hr1, err = strconv.Atoi(hrs[0])
 		if err != nil {
-			crylog.Fatal(INVALID_EXCLUDE_FORMAT_MESSAGE, err)
-			return
+			crylog.Error(INVALID_EXCLUDE_FORMAT_MESSAGE, err)
+			return EXIT_BAD_CONFIG
 		}
 		hr2, err = strconv.Atoi(hrs[1])
 		if err != nil {
-			crylog.Fatal(INVALID_EXCLUDE_FORMAT_MESSAGE, err)
-			return
+			crylog.Error(INVALID_EXCLUDE_FORMAT_MESSAGE, err)
+			return EXIT_BAD_CONFIG
 		}
 		if hr1 > 24 || hr1 < 0 || hr2 > 24 || hr2 < 0 {
-			crylog.Fatal("INVALID_EXCLUDE_FORMAT_MESSAGE", ": XX and YY must each be between 0 and 24")
-			return
+			crylog.Error("INVALID_EXCLUDE_FORMAT_MESSAGE", ": XX and YY must each be between 0 and 24")
+			return EXIT_BAD_CONFIG
 		}
 	}
+	if *priority != "low" && *priority != "normal" {
+		crylog.Error("invalid priority specified, must be low or normal:", *priority)
+		return EXIT_BAD_CONFIG
+	}
+	if *hashrateSmoothing <= 0.0 || *hashrateSmoothing > 1.0 {
+		crylog.Error("invalid hashrate-smoothing specified, must be greater than 0 and at most 1:", *hashrateSmoothing)
+		return EXIT_BAD_CONFIG
+	}
+	if *intensity < 1 || *intensity > 100 {
+		crylog.Error("invalid intensity specified, must be between 1 and 100:", *intensity)
+		return EXIT_BAD_CONFIG
+	}
+	if *minDiff < 0 {
+		crylog.Error("invalid min-diff specified, must not be negative:", *minDiff)
+		return EXIT_BAD_CONFIG
+	}
 	fmt.Printf("==== %s v%s ====\n", APPLICATION_NAME, VERSION_STRING)
 	if *uname == DONATE_USERNAME {
 		fmt.Printf("\nNo username specified, mining on behalf of donate.getmonero.org.\n")
@@ -108,7 +382,10 @@ func MultiMain(s MachineStater, agent string) {
 	fmt.Printf("\nSend feedback to: cryptonote.social@gmail.com\n")
 
 	fmt.Println("\n==== Status/Debug output follows ====")
+	rigID := expandTemplate(*rigid)
+	agent = expandTemplate(agent)
 	crylog.Info("Miner username:", *uname)
+	crylog.Info("Rig id:", rigID)
 	crylog.Info("Threads:", *t)
 
 	if hr1 == -1 || hr2 == -1 {
@@ -120,7 +397,7 @@ func MultiMain(s MachineStater, agent string) {
 		MachineStater:  s,
 		Threads:        *t,
 		Username:       *uname,
-		RigID:          *rigid,
+		RigID:          rigID,
 		Wallet:         *wallet,
 		Agent:          agent,
 		Saver:          *saver,
@@ -129,8 +406,189 @@ func MultiMain(s MachineStater, agent string) {
 		UseTLS:         *tls,
 		AdvancedConfig: *config,
 		Dev:            *dev,
+
+		SubmitOnlyWhenMining: *submitOnlyWhenMining,
+		QuietShares:          *quietShares,
+		OptimizeThreads:      *optimize,
+		PauseGracePeriod:     *pauseGrace,
+		PauseProcesses:       splitList(*pauseProcesses),
+		WarmStandby:          *warmStandby,
+		SubmitConnection:     *submitConn,
+		Multiclient:          *multiclient,
+
+		MaxRejectedBeforeReconnect: *maxRejected,
+		LogFile:                    *logFile,
+		EventLogPath:               *eventLog,
+		Proxy:                      *proxy,
+		BindAddr:                   *bindAddr,
+		Label:                      *label,
+		DoHURL:                     *dohURL,
+		ForceDoH:                   *doh,
+		LowPriority:                *priority == "low",
+		NoJobTimeout:               *noJobTimeout,
+		HashStallTimeout:           *hashStallTimeout,
+		WorkerRefreshInterval:      *refreshInterval,
+		Notify:                     *notify,
+		Webhook:                    *webhookURL,
+		TUI:                        *tui,
+		MinRecentHashrateWindow:    *minHashrateWindow,
+		SelfTest:                   *selfTest,
+		Intensity:                  *intensity,
+		MinShareDifficulty:         *minDiff,
+		PoolHashrateSmoothing:      *hashrateSmoothing,
+		PoolStatsRefreshInterval:   *poolStatsInterval,
+		PoolReadTimeout:            *poolReadTimeout,
+		DatasetCheckInterval:       *datasetCheck,
+		MaxTemperature:             *maxTemp,
+		ResumeTemperature:          *resumeTemp,
+		ThermalStabilizePeriod:     *thermalStabilize,
+	}
+	if *dumpFirstJob {
+		return dumpJob(&config)
 	}
 	if err = Mine(&config); err != nil {
-		crylog.Fatal("Miner failed:", err)
+		crylog.Error("Miner failed:", err)
+	}
+	return exitCode(err)
+}
+
+// exitCode maps an error returned by Mine to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.Is(err, ErrBadConfig):
+		return EXIT_BAD_CONFIG
+	case errors.Is(err, ErrLoginRefused):
+		return EXIT_LOGIN_REFUSED
+	case errors.Is(err, ErrInitFailed):
+		return EXIT_INIT_FAILED
+	}
+	return EXIT_FAILURE
+}
+
+// handleShutdownSignals arranges for the miner to shut down cleanly, closing the pool connection and
+// flushing the event log, when interrupted or terminated. A second signal exits immediately.
+func handleShutdownSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		shutdown("received signal: " + sig.String())
+	}()
+}
+
+var shutdownMutex sync.Mutex
+
+// shutdown stops mining and exits the process, logging reason as the cause. If shutdown is already
+// underway, waits for it to complete instead.
+func shutdown(reason string) {
+	shutdownMutex.Lock() // never released since we exit
+	crylog.Info("Shutting down,", reason)
+	minerlib.StopMining()
+	crylog.Info("Shutdown complete")
+	if dash != nil {
+		fmt.Println()
+	}
+	os.Exit(EXIT_OK)
+}
+
+// expandTemplate replaces the {hostname}, {pid}, {os} and {arch} tokens in s with the corresponding
+// values for this machine, allowing a single rig id or agent to identify each machine of a fleet.
+func expandTemplate(s string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		crylog.Warn("Failed to get hostname:", err)
+		hostname = "unknown"
+	}
+	return strings.NewReplacer(
+		"{hostname}", hostname,
+		"{pid}", strconv.Itoa(os.Getpid()),
+		"{os}", runtime.GOOS,
+		"{arch}", runtime.GOARCH,
+	).Replace(s)
+}
+
+// splitList splits a comma separated list, dropping surrounding whitespace and empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// dumpJob prints the first job the pool sends upon login with the given config, returning the
+// process exit code.
+func dumpJob(c *MinerConfig) int {
+	job, code, err := minerlib.FetchJob(&minerlib.PoolLoginArgs{
+		Username: c.Username,
+		RigID:    c.RigID,
+		Wallet:   c.Wallet,
+		Agent:    c.Agent,
+		Config:   c.AdvancedConfig,
+		UseTLS:   c.UseTLS,
+		Dev:      c.Dev,
+	})
+	if err != nil {
+		if code != 0 {
+			crylog.Error("Pool refused login:", err)
+			return EXIT_LOGIN_REFUSED
+		}
+		crylog.Error("Failed to get job from pool:", err)
+		return EXIT_FAILURE
 	}
+	b, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		crylog.Error("Failed to marshal job:", err)
+		return EXIT_FAILURE
+	}
+	fmt.Println(string(b))
+	return EXIT_OK
+}
+
+func printVersion() {
+	fmt.Printf("%s %s\n", APPLICATION_NAME, VERSION_STRING)
+	fmt.Printf("Go version: %s\n", runtime.Version())
+	fmt.Printf("OS/Arch   : %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("RandomX   : %s\n", rx.LibVersion())
+}
+
+// listenLocal listens for TCP connections at addr, binding to localhost if addr has no host.
+func listenLocal(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+// startPprof serves the Go profiling endpoints at addr, binding to localhost if addr has no host.
+func startPprof(addr string) error {
+	l, err := listenLocal(addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	crylog.Info("Serving pprof at http://" + l.Addr().String() + "/debug/pprof/")
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			crylog.Error("pprof server failed:", err)
+		}
+	}()
+	return nil
 }
@@ -0,0 +1,22 @@
+package csminer
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("hostname unavailable:", err)
+	}
+	if s := expandTemplate("csminer"); s != "csminer" {
+		t.Errorf("expected string without tokens to be unchanged, got %q", s)
+	}
+	want := "rig-" + hostname + "-" + strconv.Itoa(os.Getpid()) + "-" + runtime.GOOS + "/" + runtime.GOARCH + "-{unknown}"
+	if s := expandTemplate("rig-{hostname}-{pid}-{os}/{arch}-{unknown}"); s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+}
@@ -5,5 +5,6 @@ go 1.17
 require (
 	github.com/brunoqc/go-windows-session-notifications v0.0.0-20170424175830-fec440a22328
 	github.com/godbus/dbus/v5 v5.1.0
-	golang.org/x/sys v0.0.0-20220422013727-9388b58f7150
+	golang.org/x/net v0.11.0
+	golang.org/x/sys v0.9.0
 )
@@ -5,19 +5,120 @@ package main
 // main() for the Linux version of csminer w/ Gnome screen monitoring support
 
 import (
+	"errors"
 	"fmt"
 	"github.com/cryptonote-social/csminer"
 	"github.com/cryptonote-social/csminer/crylog"
 	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	IOPRIO_WHO_PROCESS = 1
+	IOPRIO_CLASS_IDLE  = 3
+	IOPRIO_CLASS_SHIFT = 13
 )
 
 func main() {
-	csminer.MultiMain(GnomeMachineStater{}, "csminer "+csminer.VERSION_STRING+" (linux)")
+	os.Exit(csminer.MultiMain(GnomeMachineStater{}, "csminer "+csminer.VERSION_STRING+" (linux)"))
 }
 
 type GnomeMachineStater struct {
 }
 
+// Notify shows a desktop notification via the freedesktop notifications service on the session
+// bus.
+func (s GnomeMachineStater) Notify(title, message string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+		"csminer", uint32(0), "", title, message, []string{}, map[string]dbus.Variant{}, int32(-1))
+	return call.Err
+}
+
+// LowerPriority sets the nice value of the process to the lowest priority and its I/O scheduling
+// class to idle. Both are per-thread on Linux, so each existing thread is updated. Threads created
+// afterward inherit the priority of the thread that creates them.
+func (s GnomeMachineStater) LowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err = unix.Setpriority(unix.PRIO_PROCESS, tid, 19); err != nil {
+			return err
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, IOPRIO_WHO_PROCESS, uintptr(tid), IOPRIO_CLASS_IDLE<<IOPRIO_CLASS_SHIFT)
+		if errno != 0 {
+			crylog.Warn("Failed to set idle I/O priority:", errno)
+		}
+	}
+	return nil
+}
+
+// RunningProcesses returns the names of the running processes, read from /proc. Both the command
+// name, which is truncated to 15 characters, and the first command line argument, which some
+// processes rewrite, are included for each process.
+func (s GnomeMachineStater) RunningProcesses() ([]string, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		// errors here are expected for processes that exit while we're listing them
+		if comm, err := os.ReadFile("/proc/" + e.Name() + "/comm"); err == nil {
+			names = append(names, strings.TrimSpace(string(comm)))
+		}
+		if cmdline, err := os.ReadFile("/proc/" + e.Name() + "/cmdline"); err == nil {
+			if arg0 := strings.SplitN(string(cmdline), "\x00", 2)[0]; arg0 != "" {
+				names = append(names, arg0)
+			}
+		}
+	}
+	return names, nil
+}
+
+// CPUTemperature returns the highest temperature reported by the kernel's thermal zones.
+func (s GnomeMachineStater) CPUTemperature() (float64, error) {
+	zones, err := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	if err != nil {
+		return 0, err
+	}
+	max, found := 0.0, false
+	for _, z := range zones {
+		b, err := os.ReadFile(z)
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			continue
+		}
+		if t := float64(milli) / 1000.0; !found || t > max {
+			max, found = t, true
+		}
+	}
+	if !found {
+		return 0, errors.New("no thermal zones found")
+	}
+	return max, nil
+}
+
 func (s GnomeMachineStater) GetMachineStateChannel(saver bool) (chan csminer.MachineState, error) {
 	ret := make(chan csminer.MachineState)
 	if !saver {
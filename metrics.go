@@ -0,0 +1,89 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package csminer
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cryptonote-social/csminer/minerlib"
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+)
+
+const (
+	// control API endpoint returning the mining state in the Prometheus text exposition format
+	CONTROL_METRICS_PATH = "/metrics"
+
+	METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// writeMetrics renders s in the Prometheus text exposition format. If s has a label, it's attached
+// to every series so that metrics from several instances can be told apart.
+func writeMetrics(w io.Writer, s *minerlib.GetMiningStateResponse) {
+	labels := ""
+	if s.Label != "" {
+		labels = `label="` + escapeLabelValue(s.Label) + `"`
+	}
+	mining := 0.0
+	if s.MiningActivity > 0 {
+		mining = 1.0
+	}
+	recent := s.RecentHashrate
+	if recent < 0.0 {
+		recent = 0.0 // still calculating
+	}
+	writeMetric(w, "csminer_hashrate", "gauge", "Recent hashrate in hashes per second.", labels, recent)
+	writeMetric(w, "csminer_effective_hashrate", "gauge", "Hashrate credited by the pool since startup, from accepted share difficulty.", labels, s.EffectiveHashrate)
+	writeMetric(w, "csminer_mining", "gauge", "1 if mining is active, 0 if paused.", labels, mining)
+	writeMetric(w, "csminer_threads_active", "gauge", "Number of worker threads currently hashing.", labels, float64(s.ActiveThreads))
+	writeMetric(w, "csminer_shares_accepted_total", "counter", "Shares accepted by the pool.", labels, float64(s.SharesAccepted))
+	writeMetric(w, "csminer_shares_rejected_total", "counter", "Shares rejected by the pool.", labels, float64(s.SharesRejected))
+	writeMetric(w, "csminer_hashes_total", "counter", "Hashes computed.", labels, float64(s.ClientSideHashes))
+	if s.ShareDifficulty != nil {
+		writeHistogram(w, "csminer_share_difficulty", "Difficulty of shares submitted to the pool.", labels, s.ShareDifficulty)
+	}
+	if s.SubmitLatency != nil {
+		writeHistogram(w, "csminer_submit_latency_seconds", "Round trip time of share submissions.", labels, s.SubmitLatency)
+	}
+}
+
+func writeMetric(w io.Writer, name, kind, help, labels string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	fmt.Fprintf(w, "%s%s %s\n", name, braced(labels), formatMetricValue(v))
+}
+
+func writeHistogram(w io.Writer, name, help, labels string, h *stats.Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative int64
+	for i, c := range h.Counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = formatMetricValue(h.Bounds[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braced(labels), formatMetricValue(h.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braced(labels), h.Count)
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
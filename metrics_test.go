@@ -0,0 +1,43 @@
+package csminer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cryptonote-social/csminer/minerlib"
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	s := &minerlib.GetMiningStateResponse{Label: `rig "1"`, MiningActivity: 1}
+	s.RecentHashrate = 1500.5
+	s.SharesAccepted = 3
+	s.SubmitLatency = &stats.Histogram{Bounds: []float64{0.1, 1}, Counts: []int64{2, 0, 1}, Sum: 2.25, Count: 3}
+	var b strings.Builder
+	writeMetrics(&b, s)
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE csminer_hashrate gauge\ncsminer_hashrate{label=\"rig \\\"1\\\"\"} 1500.5\n",
+		"csminer_mining{label=\"rig \\\"1\\\"\"} 1\n",
+		"csminer_shares_accepted_total{label=\"rig \\\"1\\\"\"} 3\n",
+		"# TYPE csminer_submit_latency_seconds histogram\n",
+		"csminer_submit_latency_seconds_bucket{label=\"rig \\\"1\\\"\",le=\"0.1\"} 2\n",
+		"csminer_submit_latency_seconds_bucket{label=\"rig \\\"1\\\"\",le=\"1\"} 2\n",
+		"csminer_submit_latency_seconds_bucket{label=\"rig \\\"1\\\"\",le=\"+Inf\"} 3\n",
+		"csminer_submit_latency_seconds_sum{label=\"rig \\\"1\\\"\"} 2.25\n",
+		"csminer_submit_latency_seconds_count{label=\"rig \\\"1\\\"\"} 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "csminer_share_difficulty") {
+		t.Error("expected missing histogram to be omitted")
+	}
+
+	b.Reset()
+	writeMetrics(&b, &minerlib.GetMiningStateResponse{})
+	if !strings.Contains(b.String(), "\ncsminer_hashrate 0\n") {
+		t.Errorf("expected unlabeled series, got:\n%s", b.String())
+	}
+}
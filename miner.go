@@ -6,7 +6,9 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -14,11 +16,34 @@ import (
 	"github.com/cryptonote-social/csminer/crylog"
 	"github.com/cryptonote-social/csminer/minerlib"
 	"github.com/cryptonote-social/csminer/minerlib/chat"
+	"github.com/cryptonote-social/csminer/minerlib/stats"
 	"github.com/cryptonote-social/csminer/stratum/client"
 )
 
 var (
 	chatsSent map[int64]struct{}
+
+	// Errors returned by Mine, wrapped with further detail, that warrant distinct handling. See
+	// exitCode.
+	ErrBadConfig    = errors.New("bad configuration")
+	ErrLoginRefused = errors.New("pool refused login")
+	ErrInitFailed   = errors.New("InitMiner failed")
+)
+
+const (
+	// how often to remind users that they're mining on behalf of the donation username
+	DONATION_REMINDER_INTERVAL = 30 * time.Minute
+
+	// how often mining state is checked for events worth a desktop notification, and the minimum
+	// interval between share notifications
+	NOTIFY_POLL_INTERVAL  = 5 * time.Second
+	SHARE_NOTIFY_INTERVAL = time.Minute
+
+	// how often running processes are checked against MinerConfig.PauseProcesses
+	PROCESS_POLL_INTERVAL = 10 * time.Second
+
+	// how often the CPU temperature is checked when MinerConfig.MaxTemperature is specified
+	TEMPERATURE_POLL_INTERVAL = 10 * time.Second
 )
 
 const (
@@ -37,6 +62,43 @@ type MachineStater interface {
 	GetMachineStateChannel(saver bool) (chan MachineState, error)
 }
 
+// BatteryLevelStater can optionally be implemented by a MachineStater on platforms where the
+// battery charge level can be determined.
+type BatteryLevelStater interface {
+	// Returns a channel that produces the battery charge percentage (0-100) whenever it changes,
+	// or -1 if the charge level becomes unknown.
+	GetBatteryPercentChannel() (chan int, error)
+}
+
+// PriorityLowerer can optionally be implemented by a MachineStater on platforms where the
+// scheduling priority of the miner can be lowered.
+type PriorityLowerer interface {
+	// Lowers the CPU (and if possible, I/O) scheduling priority of the process so that mining
+	// interferes less with interactive use of the machine.
+	LowerPriority() error
+}
+
+// ProcessLister can optionally be implemented by a MachineStater on platforms where the running
+// processes can be enumerated.
+type ProcessLister interface {
+	// Returns the executable names of all currently running processes.
+	RunningProcesses() ([]string, error)
+}
+
+// TemperatureStater can optionally be implemented by a MachineStater on platforms where the CPU
+// temperature can be read.
+type TemperatureStater interface {
+	// Returns the current CPU temperature in degrees C.
+	CPUTemperature() (float64, error)
+}
+
+// Notifier can optionally be implemented by a MachineStater on platforms that support desktop
+// notifications.
+type Notifier interface {
+	// Shows a desktop notification with the given title and message.
+	Notify(title, message string) error
+}
+
 type MinerConfig struct {
 	MachineStater                MachineStater
 	Threads                      int
@@ -48,23 +110,115 @@ type MinerConfig struct {
 	UseTLS                       bool
 	AdvancedConfig               string
 	Dev                          bool
+	SubmitOnlyWhenMining         bool
+	QuietShares                  bool
+	OptimizeThreads              bool
+	PauseGracePeriod             time.Duration
+	WarmStandby                  bool
+	SubmitConnection             bool
+	Multiclient                  bool
+	MaxRejectedBeforeReconnect   int
+	LogFile                      string
+	EventLogPath                 string
+	Proxy                        string
+	BindAddr                     string
+	Label                        string
+	DoHURL                       string
+	ForceDoH                     bool
+	LowPriority                  bool
+	NoJobTimeout                 time.Duration
+	HashStallTimeout             time.Duration
+	WorkerRefreshInterval        time.Duration
+	MinRecentHashrateWindow      time.Duration
+	SelfTest                     bool
+	Intensity                    int
+	MinShareDifficulty           int64
+	PoolHashrateSmoothing        float64
+	PoolStatsRefreshInterval     time.Duration
+	PoolReadTimeout              time.Duration
+	DatasetCheckInterval         time.Duration
+	Notify                       bool
+	TUI                          bool
+	Webhook                      string   // URL to post share events to, or empty for none
+	PauseProcesses               []string // names of processes to pause mining for while running
+
+	// CPU temperature policy, see minerlib.InitMinerArgs.MaxTemperature
+	MaxTemperature, ResumeTemperature float64
+	ThermalStabilizePeriod            time.Duration
 }
 
 func Mine(c *MinerConfig) error {
 	chatsSent = map[int64]struct{}{}
-	imResp := minerlib.InitMiner(&minerlib.InitMinerArgs{
+	if c.LowPriority {
+		// Lower priority before InitMiner so that the hashing threads it creates inherit it.
+		if pl, ok := c.MachineStater.(PriorityLowerer); ok {
+			if err := pl.LowerPriority(); err != nil {
+				crylog.Error("Failed to lower process priority:", err)
+			} else {
+				crylog.Info("Mining at low priority")
+			}
+		} else {
+			crylog.Warn("Lowering process priority is not supported on this platform")
+		}
+	}
+	var hook *webhook
+	if c.Webhook != "" {
+		var err error
+		if hook, err = newWebhook(c.Webhook); err != nil {
+			crylog.Error("Bad configuration:", err)
+			return fmt.Errorf("%w: %s", ErrBadConfig, err)
+		}
+		go hook.run()
+	}
+	initArgs := &minerlib.InitMinerArgs{
 		Threads:          c.Threads,
 		ExcludeHourStart: c.ExcludeHrStart,
 		ExcludeHourEnd:   c.ExcludeHrEnd,
-	})
+
+		SubmitOnlyWhenMining: c.SubmitOnlyWhenMining,
+		QuietShares:          c.QuietShares,
+		PauseGracePeriod:     c.PauseGracePeriod,
+		WarmStandby:          c.WarmStandby,
+
+		SeparateSubmitConnection: c.SubmitConnection,
+		Multiclient:              c.Multiclient,
+
+		MaxRejectedBeforeReconnect: c.MaxRejectedBeforeReconnect,
+		EventLogPath:               c.EventLogPath,
+		Proxy:                      c.Proxy,
+		BindAddr:                   c.BindAddr,
+		Label:                      c.Label,
+		DoHURL:                     c.DoHURL,
+		ForceDoH:                   c.ForceDoH,
+		NoJobTimeout:               c.NoJobTimeout,
+		HashStallTimeout:           c.HashStallTimeout,
+		WorkerRefreshInterval:      c.WorkerRefreshInterval,
+		MinRecentHashrateWindow:    c.MinRecentHashrateWindow,
+		ProvisionalHashrate:        true,
+		SelfTest:                   c.SelfTest,
+		Intensity:                  c.Intensity,
+		MinShareDifficulty:         c.MinShareDifficulty,
+		PoolHashrateSmoothing:      c.PoolHashrateSmoothing,
+		PoolStatsRefreshInterval:   c.PoolStatsRefreshInterval,
+		PoolReadTimeout:            c.PoolReadTimeout,
+		DatasetCheckInterval:       c.DatasetCheckInterval,
+		MaxTemperature:             c.MaxTemperature,
+		ResumeTemperature:          c.ResumeTemperature,
+		ThermalStabilizePeriod:     c.ThermalStabilizePeriod,
+	}
+	if hook != nil {
+		initArgs.ShareAcceptedCallback = hook.shareAccepted
+		initArgs.BlockFoundCallback = hook.blockFound
+	}
+	imResp := minerlib.InitMiner(initArgs)
 
 	if imResp.Code < 0 {
 		crylog.Error("Initialization error:", imResp.Message)
-		return errors.New("InitMiner failed: " + imResp.Message)
+		return fmt.Errorf("%w: %s", ErrInitFailed, imResp.Message)
 	}
 	if imResp.Code > 2 {
 		crylog.Error("Bad configuration:", imResp.Message)
-		return errors.New("InitMiner failed: " + imResp.Message)
+		return fmt.Errorf("%w: %s", ErrBadConfig, imResp.Message)
 	}
 	if imResp.Code == 2 {
 		crylog.Warn("")
@@ -72,6 +226,14 @@ func Mine(c *MinerConfig) error {
 		crylog.Warn("         Rebooting your machine might fix this.")
 		crylog.Warn("")
 	}
+	if c.OptimizeThreads {
+		crylog.Info("Benchmarking thread counts, this may take a minute")
+		if _, n, err := minerlib.OptimizeThreads(minerlib.DEFAULT_BENCHMARK_DURATION); err != nil {
+			crylog.Error("Thread optimization failed:", err)
+		} else {
+			crylog.Info("Mining with", n, "threads")
+		}
+	}
 
 	sleepSec := 3 * time.Second // time to sleep if connection attempt fails
 	for {
@@ -110,7 +272,7 @@ func Mine(c *MinerConfig) error {
 			break
 		}
 		crylog.Error("Pool refused login:", plResp.Message)
-		return errors.New("pool refused login")
+		return fmt.Errorf("%w: %s", ErrLoginRefused, plResp.Message)
 	}
 
 	// We assume the screen is active when the miner is started. This may
@@ -118,30 +280,84 @@ func Mine(c *MinerConfig) error {
 	if !c.Saver {
 		minerlib.ReportIdleScreenState(true)
 	}
-	ch, err := c.MachineStater.GetMachineStateChannel(c.Saver)
-	if err != nil {
-		minerlib.ReportIdleScreenState(true)
-		crylog.Error("failed to get machine state monitor, screen & battery state will be ignored")
-	} else {
-		go monitorMachineState(ch)
+	startMachineStateMonitor(c.MachineStater, c.Saver)
+	if bs, ok := c.MachineStater.(BatteryLevelStater); ok {
+		bch, err := bs.GetBatteryPercentChannel()
+		if err != nil {
+			crylog.Error("failed to get battery level monitor, battery level will not be reported")
+		} else {
+			go monitorBatteryPercent(bch)
+		}
 	}
 
-	go printStatsPeriodically()
+	if c.Notify {
+		if n, ok := c.MachineStater.(Notifier); ok {
+			go sendNotifications(n)
+		} else {
+			crylog.Warn("Desktop notifications are not supported on this platform")
+		}
+	}
+	if c.MaxTemperature > 0.0 {
+		if ts, ok := c.MachineStater.(TemperatureStater); ok {
+			go monitorTemperature(ts)
+		} else {
+			crylog.Warn("Reading the CPU temperature is not supported on this platform, -max-temp ignored")
+		}
+	}
+	if len(c.PauseProcesses) > 0 {
+		if pl, ok := c.MachineStater.(ProcessLister); ok {
+			go watchProcesses(pl, c.PauseProcesses)
+		} else {
+			crylog.Warn("Pausing for running processes is not supported on this platform")
+		}
+	}
 
-	printKeyboardCommands()
+	if c.TUI {
+		dash = newDashboard(os.Stdout)
+		if c.LogFile != "" {
+			crylog.SetWriter(io.MultiWriter(crylog.Writer(), dash))
+		} else {
+			crylog.SetWriter(dash)
+		}
+		go dash.run()
+	} else {
+		go printStatsPeriodically()
+		printKeyboardCommands()
+	}
 	scanner := bufio.NewScanner(os.Stdin)
-	var manualMinerActivate bool
+	var manualMinerActivate, softPaused bool
 	for scanner.Scan() {
 		b := scanner.Text()
+		if dash != nil {
+			dash.resetPrompt()
+		}
 		switch b {
 		case "i":
-			crylog.Info("Increasing thread count.")
-			minerlib.IncreaseThreads()
+			if minerlib.IncreaseThreads() {
+				crylog.Info("Increasing thread count.")
+			}
 		case "d":
-			crylog.Info("Decreasing thread count.")
-			minerlib.DecreaseThreads()
+			if minerlib.DecreaseThreads() {
+				crylog.Info("Decreasing thread count.")
+			}
 		case "h", "s", "p":
+			if dash != nil {
+				dash.refresh()
+				continue
+			}
 			printStats(false)
+		case "r":
+			crylog.Info("Resetting session stats.")
+			minerlib.ResetStats()
+		case "z":
+			if !softPaused {
+				softPaused = true
+				manualMinerActivate = false
+				minerlib.SoftPauseMining()
+			} else {
+				softPaused = false
+				minerlib.RemoveMiningActivityOverride()
+			}
 		case "q", "quit", "exit":
 			crylog.Info("quitting due to keyboard command")
 			return nil
@@ -151,12 +367,43 @@ func Mine(c *MinerConfig) error {
 		if len(b) == 0 {
 			if !manualMinerActivate {
 				manualMinerActivate = true
+				softPaused = false
 				minerlib.OverrideMiningActivityState(true)
 			} else {
 				manualMinerActivate = false
 				minerlib.RemoveMiningActivityOverride()
 			}
 		}
+		if strings.HasPrefix(b, "p ") {
+			until, err := parsePauseTime(strings.TrimSpace(b[2:]), time.Now())
+			if err != nil {
+				crylog.Error("Invalid pause time:", err)
+				continue
+			}
+			manualMinerActivate = false
+			softPaused = false
+			minerlib.PauseUntil(until)
+			continue
+		}
+		if strings.HasPrefix(b, "donate ") {
+			pct, err := strconv.ParseFloat(strings.TrimSpace(b[7:]), 64)
+			if err != nil {
+				crylog.Error("Invalid donation percentage:", b[7:])
+				continue
+			}
+			plResp := minerlib.SetDonation(pct)
+			if plResp.Code != 1 {
+				crylog.Error("Failed to change donation percentage:", plResp.Message)
+			}
+			continue
+		}
+		if strings.HasPrefix(b, "config ") {
+			plResp := minerlib.UpdateConfig(strings.TrimSpace(b[7:]))
+			if plResp.Code != 1 {
+				crylog.Error("Failed to update config:", plResp.Message)
+			}
+			continue
+		}
 		if strings.HasPrefix(b, "c ") {
 			chatMsg := b[2:]
 			id := chat.SendChat(chatMsg)
@@ -175,53 +422,166 @@ func Mine(c *MinerConfig) error {
 }
 
 func printStats(ifActive bool) {
-	s := minerlib.GetMiningState()
+	printState(minerlib.GetMiningState(), ifActive)
+}
+
+func printState(s *minerlib.GetMiningStateResponse, ifActive bool) {
 	msg := getActivityMessage(s.MiningActivity)
 	if ifActive && s.MiningActivity < 0 {
 		return
 	}
 	crylog.Info("")
 	crylog.Info("===========================================================")
+	if s.Label != "" {
+		crylog.Info("Label                        :", s.Label)
+	}
 	if s.RecentHashrate < 0 {
-		crylog.Info("Current Hashrate             : --calculating--")
+		crylog.Info("Current Hashrate             : --calculating--", calculatingProgress(&s.Snapshot))
+	} else if s.RecentHashrateProvisional {
+		crylog.Info("Current Hashrate             :", strconv.FormatFloat(s.RecentHashrate, 'f', 2, 64), "(provisional)")
 	} else {
 		crylog.Info("Current Hashrate             :", strconv.FormatFloat(s.RecentHashrate, 'f', 2, 64))
 	}
 	crylog.Info("Hashrate since inception     :", strconv.FormatFloat(s.Hashrate, 'f', 2, 64))
-	crylog.Info("Threads                      :", s.Threads)
+	if s.SharesAccepted > 0 {
+		crylog.Info("Effective (pool) hashrate    :", strconv.FormatFloat(s.EffectiveHashrate, 'f', 2, 64))
+	}
+	if s.ConfiguredThreads > s.Threads {
+		crylog.Info("Threads                      :", s.Threads, "(of", s.ConfiguredThreads, "configured)")
+	} else {
+		crylog.Info("Threads                      :", s.Threads)
+	}
+	if s.Intensity < 100 {
+		crylog.Info("Intensity                    :", strconv.Itoa(s.Intensity)+"%")
+	}
+	crylog.Info("RandomX flags                :", s.RXFlags) // "unknown" until rxlib reports them
+	if s.CurrentDifficulty > 0 {
+		crylog.Info("Current job difficulty       :", prettyInt(s.CurrentDifficulty))
+	}
+	if !s.PausedUntil.IsZero() {
+		crylog.Info("Paused until                 :", s.PausedUntil.Format("Mon Jan 2 15:04"))
+	}
+	if s.HugePagesRestartRecommended {
+		crylog.Info("Huge pages now available; restart the miner to use them")
+	}
+	if s.BatteryPercent >= 0 {
+		crylog.Info("Battery level                :", strconv.Itoa(s.BatteryPercent)+"%")
+	}
 	crylog.Info("Shares    [accepted:rejected]:", s.SharesAccepted, ":", s.SharesRejected)
+	if s.SharesPerMinute > 0.0 {
+		crylog.Info("Accepted shares per minute   :", strconv.FormatFloat(s.SharesPerMinute, 'f', 2, 64))
+	}
+	if s.SharesRejected > 0 {
+		crylog.Info("Rejected [lowdiff:stale:dup:other]:", s.RejectedLowDifficulty, ":", s.RejectedStale, ":",
+			s.RejectedDuplicate, ":", s.RejectedUnknown)
+	}
+	if s.SharesAbandoned > 0 {
+		crylog.Info("Shares abandoned             :", s.SharesAbandoned)
+	}
+	if s.SharesRequeued > 0 {
+		crylog.Info("Shares requeued [abandoned]  :", s.SharesRequeued, "[", s.SharesRequeueAbandoned, "]")
+	}
+	if s.SharesDuplicate > 0 {
+		crylog.Info("Duplicate shares skipped     :", s.SharesDuplicate)
+	}
+	if s.DatasetCheckFailures > 0 {
+		crylog.Info("Dataset check failures       :", s.DatasetCheckFailures)
+	}
+	if s.BlocksFound > 0 {
+		crylog.Info("Blocks found                 :", s.BlocksFound)
+	}
+	if s.SessionBestHash > 0 {
+		crylog.Info("Session best hash            :", prettyInt(s.SessionBestHash))
+	}
 	crylog.Info("Hashes          [client:pool]:", s.ClientSideHashes, ":", s.PoolSideHashes)
+	if s.TLS.Version != "" {
+		crylog.Info("Pool connection TLS          :", s.TLS.Version, s.TLS.CipherSuite)
+	}
+	if lc := s.LastConnect; lc.Login > 0 {
+		crylog.Info("Last connect [dns:tcp:tls:login]:", lc.DNS.Round(time.Millisecond), ":", lc.Connect.Round(time.Millisecond),
+			":", lc.TLSHandshake.Round(time.Millisecond), ":", lc.Login.Round(time.Millisecond))
+	}
 	crylog.Info("===========================================================")
 	if s.SecondsOld >= 0.0 {
 		crylog.Info("Pool username              :", s.PoolUsername)
 		crylog.Info("Lifetime hashes            :", prettyInt(s.LifetimeHashes))
+		if s.LifetimeBestHash > 0 {
+			crylog.Info("Lifetime best hash         :", prettyInt(s.LifetimeBestHash))
+		}
 		crylog.Info("Paid                       :", strconv.FormatFloat(s.Paid, 'f', 12, 64), "$XMR")
 		if s.Owed > 0.0 {
 			crylog.Info("Owed                       :", strconv.FormatFloat(s.Owed, 'f', 12, 64), "$XMR")
 		}
+		crylog.Info("Pool donation              :", strconv.FormatFloat(s.Donate*100.0, 'f', -1, 64)+"%")
 		crylog.Info("Time to next reward (est.) :", s.TimeToReward)
 		crylog.Info("  Accumulated (est.)       :", strconv.FormatFloat(s.Accumulated, 'f', 12, 64), "$XMR")
 		crylog.Info("===========================================================")
 	}
 	crylog.Info("Mining", msg)
+	if s.IsDonating {
+		crylog.Info("Donating: no -user specified, so mining on behalf of donate.getmonero.org")
+	}
 	crylog.Info("===========================================================")
 	crylog.Info("")
 }
 
+func printDonationReminder() {
+	crylog.Warn(":::::::::::::::::::::::::::::::::::::::::::::::::::::::::")
+	crylog.Warn("REMINDER: no -user was specified, so you are mining on behalf of")
+	crylog.Warn("   donate.getmonero.org. Thank you! To mine for yourself instead,")
+	crylog.Warn("   restart with -user=<your username>.")
+	crylog.Warn(":::::::::::::::::::::::::::::::::::::::::::::::::::::::::")
+}
+
 func printKeyboardCommands() {
 	crylog.Info("")
 	crylog.Info("Keyboard commands:")
 	crylog.Info("   s: print miner stats")
 	crylog.Info("   i: increase number of threads by 1")
 	crylog.Info("   d: decrease number of threads by 1")
+	crylog.Info("   r: reset session stats")
+	crylog.Info("   z: briefly pause mining, preserving the current hashrate (z again to resume)")
+	crylog.Info("   p <duration or time>: pause mining for a while, e.g. p 2h, or until a time, e.g. p 8am")
 	crylog.Info("   c <message>: send a message to the chatroom")
+	crylog.Info("   donate <percent>: change the percentage of earnings donated to the pool")
+	crylog.Info("   config <string>: log in again with a new advanced config, keeping session stats")
 	crylog.Info("   q: quit")
 	crylog.Info("   <enter>: override a paused miner")
 	crylog.Info("")
 }
 
+// parsePauseTime returns when a pause specified by the argument of the p keyboard command should
+// end: either a duration from now such as "90m" or "2h", or a time of day such as "8am", "8:30pm"
+// or "20:30", in which case its next occurrence after now is returned.
+func parsePauseTime(arg string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(arg); err == nil {
+		if d < 0 {
+			return time.Time{}, errors.New("pause duration must not be negative: " + arg)
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{"15:04", "3pm", "3PM", "3:04pm", "3:04PM"} {
+		t, err := time.ParseInLocation(layout, arg, now.Location())
+		if err != nil {
+			continue
+		}
+		until := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !until.After(now) {
+			until = until.AddDate(0, 0, 1)
+		}
+		return until, nil
+	}
+	return time.Time{}, errors.New("expected a duration like 2h or a time of day like 8am or 20:30, got: " + arg)
+}
+
+// prettyInt formats i with thousands separators. It formats the int64 directly rather than via
+// int, which would truncate large hash counts on 32-bit platforms.
 func prettyInt(i int64) string {
-	s := strconv.Itoa(int(i))
+	s := strconv.FormatInt(i, 10)
+	sign := ""
+	if i < 0 {
+		sign, s = "-", s[1:]
+	}
 	out := []byte{}
 	count := 0
 	for i := len(s) - 1; i >= 0; i-- {
@@ -235,7 +595,7 @@ func prettyInt(i int64) string {
 	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
 		out[i], out[j] = out[j], out[i]
 	}
-	return string(out)
+	return sign + string(out)
 }
 
 func printStatsPeriodically() {
@@ -248,8 +608,15 @@ func printStatsPeriodically() {
 		}
 	}
 	printStats(false)
+	lastDonationReminder := time.Now()
 	for {
 		<-time.After(3 * time.Second)
+		if time.Since(lastDonationReminder) > DONATION_REMINDER_INTERVAL {
+			lastDonationReminder = time.Now()
+			if minerlib.GetMiningState().IsDonating {
+				printDonationReminder()
+			}
+		}
 		//printStats(true) // print full stats only if actively mining
 		for c := chat.NextChatReceived(); c != nil; c = chat.NextChatReceived() {
 			_, ok := chatsSent[c.ID]
@@ -263,10 +630,31 @@ func printStatsPeriodically() {
 }
 
 func printChat(unm string, ts int64, msg string) {
+	if dash != nil {
+		dash.addChat(unm, ts, msg)
+		return
+	}
 	date := time.Unix(ts, 0).Format(time.RFC1123)
 	fmt.Printf("\n[ %s ] (%s):\n%s\n\n", unm, date, msg)
 }
 
+// startMachineStateMonitor starts monitoring the screen & power state reported by the stater,
+// returning false if the stater fails to provide a state channel. In that case the screen is
+// treated as idle so that mining doesn't wait forever on a state change that will never come.
+func startMachineStateMonitor(ms MachineStater, saver bool) bool {
+	ch, err := ms.GetMachineStateChannel(saver)
+	if err == nil && ch == nil {
+		err = errors.New("nil state channel")
+	}
+	if err != nil {
+		minerlib.ReportIdleScreenState(true)
+		crylog.Error("failed to get machine state monitor, screen & battery state will be ignored:", err)
+		return false
+	}
+	go monitorMachineState(ch)
+	return true
+}
+
 func monitorMachineState(ch chan MachineState) {
 	for state := range ch {
 		switch state {
@@ -282,6 +670,113 @@ func monitorMachineState(ch chan MachineState) {
 	}
 }
 
+func monitorBatteryPercent(ch chan int) {
+	for pct := range ch {
+		minerlib.ReportBatteryPercent(pct)
+	}
+}
+
+// monitorTemperature reports the CPU temperature to minerlib every TEMPERATURE_POLL_INTERVAL.
+func monitorTemperature(ts TemperatureStater) {
+	for {
+		t, err := ts.CPUTemperature()
+		if err != nil {
+			crylog.Warn("Failed to read CPU temperature:", err)
+		} else {
+			minerlib.ReportTemperature(t)
+		}
+		time.Sleep(TEMPERATURE_POLL_INTERVAL)
+	}
+}
+
+// watchProcesses reports to minerlib whenever any of the watched processes starts or stops
+// running, polling every PROCESS_POLL_INTERVAL.
+func watchProcesses(pl ProcessLister, watch []string) {
+	blocking := ""
+	for {
+		running, err := pl.RunningProcesses()
+		if err != nil {
+			crylog.Warn("Failed to list running processes:", err)
+		} else if name := findBlockingProcess(running, watch); name != blocking {
+			if name != "" {
+				crylog.Info("Pausing mining while process is running:", name)
+			}
+			blocking = name
+			minerlib.ReportBlockingProcess(name != "")
+		}
+		time.Sleep(PROCESS_POLL_INTERVAL)
+	}
+}
+
+// findBlockingProcess returns the first watched process name matching any of the running
+// processes, or empty string if none do. Names match ignoring case, directory, and any .exe
+// extension, so "obs" matches "OBS.exe" and "/usr/bin/obs".
+func findBlockingProcess(running, watch []string) string {
+	names := make(map[string]struct{}, len(running))
+	for _, r := range running {
+		names[processName(r)] = struct{}{}
+	}
+	for _, w := range watch {
+		if _, ok := names[processName(w)]; ok {
+			return w
+		}
+	}
+	return ""
+}
+
+func processName(name string) string {
+	name = strings.ToLower(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// sendNotifications shows desktop notifications whenever mining starts or stops, the pool
+// connection drops, or shares are accepted. Share notifications are batched so that at most one is
+// shown every SHARE_NOTIFY_INTERVAL.
+func sendNotifications(n Notifier) {
+	notify := func(msg string) {
+		if err := n.Notify("csminer", msg); err != nil {
+			crylog.Warn("Failed to show notification:", err)
+		}
+	}
+	ch, _ := minerlib.StreamStats(NOTIFY_POLL_INTERVAL)
+	lastActivity := 0
+	var lastAccepted, notifiedAccepted, lastBlocks int64
+	var lastShareNotify time.Time
+	for s := range ch {
+		if lastActivity != 0 && s.MiningActivity != lastActivity {
+			if s.MiningActivity == minerlib.MINING_PAUSED_NO_CONNECTION {
+				notify("Connection to the pool was lost.")
+			} else if (s.MiningActivity > 0) != (lastActivity > 0) {
+				if s.MiningActivity > 0 {
+					notify("Mining started.")
+				} else {
+					notify("Mining " + strings.ToLower(strings.SplitN(getActivityMessage(s.MiningActivity), ".", 2)[0]) + ".")
+				}
+			}
+		}
+		lastActivity = s.MiningActivity
+		if s.SharesAccepted < lastAccepted {
+			// stats were reset
+			notifiedAccepted = s.SharesAccepted
+		}
+		lastAccepted = s.SharesAccepted
+		if s.BlocksFound > lastBlocks {
+			notify("Block found! Your miner found a share meeting the network difficulty.")
+		}
+		lastBlocks = s.BlocksFound
+		if s.SharesAccepted > notifiedAccepted && time.Since(lastShareNotify) >= SHARE_NOTIFY_INTERVAL {
+			found := s.SharesAccepted - notifiedAccepted
+			if found == 1 {
+				notify("Share accepted by the pool.")
+			} else {
+				notify(strconv.FormatInt(found, 10) + " shares accepted by the pool.")
+			}
+			notifiedAccepted = s.SharesAccepted
+			lastShareNotify = time.Now()
+		}
+	}
+}
+
 func getActivityMessage(activityState int) string {
 	switch activityState {
 	case minerlib.MINING_PAUSED_NO_CONNECTION:
@@ -294,6 +789,14 @@ func getActivityMessage(activityState int) string {
 		return "PAUSED: keyboard override. <enter> to undo override."
 	case minerlib.MINING_PAUSED_TIME_EXCLUDED:
 		return "PAUSED: within time of day exclusion. <enter> to override."
+	case minerlib.MINING_PAUSED_TOO_MANY_REJECTS:
+		return "PAUSED: too many rejected shares. <enter> to override."
+	case minerlib.MINING_PAUSED_PROCESS:
+		return "PAUSED: blocking process running. <enter> to override."
+	case minerlib.MINING_PAUSED_TEMPERATURE:
+		return "PAUSED: CPU too hot. <enter> to override."
+	case minerlib.MINING_PAUSED_TIMED:
+		return "PAUSED: until a set time. <enter> to override."
 	case minerlib.MINING_ACTIVE:
 		return "ACTIVE"
 	case minerlib.MINING_ACTIVE_USER_OVERRIDE:
@@ -308,3 +811,9 @@ func getActivityMessage(activityState int) string {
 		return "PAUSED: unknown reason"
 	}
 }
+
+// calculatingProgress returns a string describing how much of the minimum window of mining needed
+// for an accurate recent hashrate has elapsed, e.g. "(4s/5s)".
+func calculatingProgress(s *stats.Snapshot) string {
+	return fmt.Sprintf("(%ds/%ds)", int(s.RecentWindowSeconds), int(s.MinRecentWindowSeconds))
+}
@@ -0,0 +1,111 @@
+package csminer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+type fakeStater struct {
+	ch  chan MachineState
+	err error
+}
+
+func (s fakeStater) GetMachineStateChannel(saver bool) (chan MachineState, error) {
+	return s.ch, s.err
+}
+
+func TestStartMachineStateMonitor(t *testing.T) {
+	if startMachineStateMonitor(fakeStater{err: errors.New("no dbus")}, true) {
+		t.Error("expected monitor not to start when the stater fails")
+	}
+	if startMachineStateMonitor(fakeStater{}, true) {
+		t.Error("expected monitor not to start on a nil channel")
+	}
+	ch := make(chan MachineState)
+	if !startMachineStateMonitor(fakeStater{ch: ch}, true) {
+		t.Error("expected monitor to start")
+	}
+	ch <- SCREEN_ACTIVE // blocks unless the monitor is reading the channel
+	close(ch)
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, EXIT_OK},
+		{errors.New("didn't expect keyboard scanning to terminate"), EXIT_FAILURE},
+		{fmt.Errorf("%w: bad threads", ErrBadConfig), EXIT_BAD_CONFIG},
+		{fmt.Errorf("%w: invalid wallet", ErrLoginRefused), EXIT_LOGIN_REFUSED},
+		{fmt.Errorf("%w: no randomx", ErrInitFailed), EXIT_INIT_FAILED},
+	}
+	for _, c := range cases {
+		if code := exitCode(c.err); code != c.code {
+			t.Errorf("expected exit code %v for %v, got %v", c.code, c.err, code)
+		}
+	}
+}
+
+func TestPrettyInt(t *testing.T) {
+	tests := []struct {
+		i    int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{-1234567, "-1,234,567"},
+		// exceeds 32 bits, so must not be truncated on 32-bit platforms
+		{12345678901234, "12,345,678,901,234"},
+		{math.MaxInt64, "9,223,372,036,854,775,807"},
+	}
+	for _, tt := range tests {
+		if got := prettyInt(tt.i); got != tt.want {
+			t.Errorf("prettyInt(%d) = %q, want %q", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestFindBlockingProcess(t *testing.T) {
+	running := []string{"systemd", "/usr/bin/OBS", `C:\Games\Steam.exe`, "bash"}
+	if got := findBlockingProcess(running, []string{"blender", "obs"}); got != "obs" {
+		t.Errorf("expected obs to match /usr/bin/OBS, got %q", got)
+	}
+	if got := findBlockingProcess(running, []string{"steam.exe"}); got != "steam.exe" {
+		t.Errorf("expected steam.exe to match a windows path, got %q", got)
+	}
+	if got := findBlockingProcess(running, []string{"ob", "blender"}); got != "" {
+		t.Errorf("expected no match, got %q", got)
+	}
+}
+
+func TestParsePauseTime(t *testing.T) {
+	now := time.Date(2020, 6, 1, 14, 30, 0, 0, time.Local)
+	cases := []struct {
+		arg  string
+		want time.Time
+	}{
+		{"2h", now.Add(2 * time.Hour)},
+		{"90m", now.Add(90 * time.Minute)},
+		{"0s", now},
+		{"8am", time.Date(2020, 6, 2, 8, 0, 0, 0, time.Local)},
+		{"8:15PM", time.Date(2020, 6, 1, 20, 15, 0, 0, time.Local)},
+		{"20:30", time.Date(2020, 6, 1, 20, 30, 0, 0, time.Local)},
+		{"14:30", time.Date(2020, 6, 2, 14, 30, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		got, err := parsePauseTime(c.arg, now)
+		if err != nil || !got.Equal(c.want) {
+			t.Errorf("parsePauseTime(%q) = %v, %v; expected %v", c.arg, got, err, c.want)
+		}
+	}
+	for _, arg := range []string{"", "-1h", "soon", "25:00"} {
+		if _, err := parsePauseTime(arg, now); err == nil {
+			t.Errorf("expected error parsing %q", arg)
+		}
+	}
+}
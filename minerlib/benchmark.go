@@ -0,0 +1,153 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package minerlib
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cryptonote-social/csminer/crylog"
+	"github.com/cryptonote-social/csminer/rx"
+)
+
+const (
+	// default time spent benchmarking each thread count
+	DEFAULT_BENCHMARK_DURATION = 5 * time.Second
+
+	// fraction by which an extra thread must improve hashrate to be worth recommending, since
+	// threads beyond the physical cores often add little or even lower hashrate while making the
+	// machine less responsive
+	MIN_THREAD_GAIN = 0.02
+
+	// length of the offline benchmark job blob, typical of real Monero jobs
+	BENCHMARK_BLOB_LENGTH = 76
+)
+
+// BenchmarkResult is the hashrate measured while mining with a given number of threads.
+type BenchmarkResult struct {
+	Threads  int
+	Hashrate float64
+}
+
+// OptimizeThreads benchmarks hashrate at each thread count from 1 up to one per CPU, mining an
+// offline job for duration d at each, then switches to and returns the recommended thread count
+// along with the measurements. Must be called after InitMiner and before PoolLogin, and since the
+// dataset is seeded for the offline job, can take a while.
+func OptimizeThreads(d time.Duration) (results []BenchmarkResult, recommended int, err error) {
+	doneChanMutex.Lock() // keeps PoolLogin from starting the mining loop until we're done
+	defer doneChanMutex.Unlock()
+	configMutex.Lock()
+	if threads == 0 {
+		configMutex.Unlock()
+		return nil, 0, errors.New("InitMiner must be called before benchmarking")
+	}
+	if miningLoopDoneChan != nil {
+		configMutex.Unlock()
+		return nil, 0, errors.New("can't benchmark while mining")
+	}
+	configMutex.Unlock()
+
+	crylog.Info("Initializing RandomX for benchmark")
+	if !rx.SeedRX(make([]byte, 32), runtime.GOMAXPROCS(0)) {
+		return nil, 0, errors.New("failed to seed RandomX for benchmark")
+	}
+	lastSeed = nil // make sure the mining loop reseeds for the first real job
+
+	for n := 1; n <= maxThreads; n++ {
+		if !setThreads(n) {
+			crylog.Warn("Could not initialize", n, "threads, benchmarking stopped")
+			break
+		}
+		hr := benchmarkThreads(n, d)
+		crylog.Info("Threads:", n, "Hashrate:", fmt.Sprintf("%.2f", hr))
+		results = append(results, BenchmarkResult{Threads: n, Hashrate: hr})
+	}
+	if len(results) == 0 {
+		return nil, 0, errors.New("benchmark failed")
+	}
+
+	recommended = recommendThreads(results)
+	crylog.Info("")
+	crylog.Info("Threads    Hashrate")
+	for _, r := range results {
+		mark := ""
+		if r.Threads == recommended {
+			mark = "  <-- recommended"
+		}
+		crylog.Info(fmt.Sprintf("%7d %11.2f%s", r.Threads, r.Hashrate, mark))
+	}
+	crylog.Info("")
+	setThreads(recommended)
+	return results, recommended, nil
+}
+
+// setThreads adds or removes rxlib threads until there are n of them, returning false if that
+// couldn't be done. Worker threads must be stopped.
+func setThreads(n int) bool {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	for threads < n && configuredThreads < maxThreads {
+		prev := threads
+		addThread()
+		if threads == prev {
+			return false
+		}
+	}
+	for threads > n {
+		prev, prevConfigured := threads, configuredThreads
+		removeThread()
+		if threads == prev && configuredThreads == prevConfigured {
+			return false
+		}
+	}
+	return threads == n
+}
+
+// benchmarkThreads returns the hashrate achieved hashing the offline job with n threads for
+// duration d.
+func benchmarkThreads(n int, d time.Duration) float64 {
+	var stop uint32
+	var hashes int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(thread int) {
+			defer wg.Done()
+			blob := make([]byte, BENCHMARK_BLOB_LENGTH)
+			hash := make([]byte, 32)
+			nonce := make([]byte, 4)
+			for {
+				// no hash can meet the maximum difficulty, so this hashes until stopped
+				res := rx.HashUntil(blob, math.MaxUint64, thread, hash, nonce, &stop)
+				if res <= 0 {
+					atomic.AddInt64(&hashes, -res)
+					return
+				}
+				atomic.AddInt64(&hashes, res)
+			}
+		}(i)
+	}
+	time.Sleep(d)
+	atomic.StoreUint32(&stop, 1)
+	wg.Wait()
+	return float64(atomic.LoadInt64(&hashes)) / time.Since(start).Seconds()
+}
+
+// recommendThreads returns the thread count with the highest hashrate, except that more threads are
+// only recommended if they beat the best hashrate of fewer threads by more than MIN_THREAD_GAIN.
+func recommendThreads(results []BenchmarkResult) int {
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.Hashrate > best.Hashrate*(1.0+MIN_THREAD_GAIN) {
+			best = r
+		}
+	}
+	return best.Threads
+}
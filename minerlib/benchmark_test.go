@@ -0,0 +1,30 @@
+package minerlib
+
+import (
+	"testing"
+)
+
+func TestRecommendThreads(t *testing.T) {
+	tests := []struct {
+		hashrates []float64 // for 1, 2, ... threads
+		want      int
+	}{
+		{[]float64{100}, 1},
+		{[]float64{100, 195, 290, 380}, 4},
+		// past the physical cores, extra threads add little
+		{[]float64{100, 195, 290, 380, 384, 387}, 4},
+		// or even reduce hashrate
+		{[]float64{100, 195, 290, 380, 350, 330}, 4},
+		// a later gain beyond the noise is still worth it
+		{[]float64{100, 195, 196, 300}, 4},
+	}
+	for _, tt := range tests {
+		var results []BenchmarkResult
+		for i, hr := range tt.hashrates {
+			results = append(results, BenchmarkResult{Threads: i + 1, Hashrate: hr})
+		}
+		if got := recommendThreads(results); got != tt.want {
+			t.Errorf("recommendThreads(%v) = %v, want %v", tt.hashrates, got, tt.want)
+		}
+	}
+}
@@ -6,18 +6,24 @@ import (
 
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"math"
+	"sort"
 	"sync"
 )
 
 var (
 	mutex sync.Mutex
 
-	chatQueue       []string
-	chatToSendIndex int
+	chatQueue         []string
+	chatDelivered     []bool // chatDelivered[i] is true once chatQueue[i] is confirmed sent
+	chatToSendIndex   int    // index of the first chat not yet confirmed sent
+	chatInFlightIndex int    // index of the next chat not yet handed out by GetChatsToSend
+	chatRetry         []int  // indexes of handed out chats whose submission failed, in queue order
 
 	receivedQueue     []*client.ChatResult
 	chatReceivedIndex int
+	received          map[chatKey]struct{} // chats in receivedQueue, so none are queued twice
 
 	nextToken int64
 
@@ -27,8 +33,19 @@ var (
 const (
 	HASHES_PER_CHAT     = 5000
 	MAX_CHATS_PER_SHARE = 5
+
+	// Max # of bytes of serialized chats to attach to a single share submission, leaving the rest
+	// of the server's request size limit for the remaining submit parameters.
+	MAX_CHAT_BYTES_PER_SHARE = client.MAX_REQUEST_SIZE - 1000
 )
 
+// chatKey identifies a received chat across pool sessions.
+type chatKey struct {
+	username  string
+	id        int64
+	timestamp int64
+}
+
 func init() {
 	err := binary.Read(rand.Reader, binary.LittleEndian, &randID)
 	if err != nil {
@@ -43,45 +60,141 @@ func SendChat(chat string) int64 {
 	mutex.Lock()
 	defer mutex.Unlock()
 	chatQueue = append(chatQueue, chat)
+	chatDelivered = append(chatDelivered, false)
 	return int64(len(chatQueue)-1) ^ randID
 }
 
 // GetChatsToSend returns the next queud chat messages to deliver with a valid mining share.  It
 // requires at least HASHES_PER_CHAT hashes to be computed per chat returned, and returns up to
-// MAX_CHATS_PER_SHARE. Returns nil if there are no chats queued to send.
+// MAX_CHATS_PER_SHARE totalling at most MAX_CHAT_BYTES_PER_SHARE when serialized. Returns nil if
+// there are no chats queued to send. The caller must report the outcome of the submission with
+// ChatSent or ChatsNotSent.
 func GetChatsToSend(diff int64) []client.ChatToSend {
 	mutex.Lock()
 	defer mutex.Unlock()
-	if chatToSendIndex == len(chatQueue) {
+	if chatInFlightIndex == len(chatQueue) && len(chatRetry) == 0 {
 		return nil
 	}
 	r := []client.ChatToSend{}
-	for diff >= HASHES_PER_CHAT && chatToSendIndex < len(chatQueue) && len(r) < MAX_CHATS_PER_SHARE {
-		r = append(r, client.ChatToSend{
-			ID:      int64(chatToSendIndex) ^ randID,
-			Message: chatQueue[chatToSendIndex],
-		})
-		chatToSendIndex++
+	size := 0
+	// add appends chat i to r, returning false if the share has no room left for it
+	add := func(i int) bool {
+		if diff < HASHES_PER_CHAT || len(r) >= MAX_CHATS_PER_SHARE {
+			return false
+		}
+		c := client.ChatToSend{
+			ID:      int64(i) ^ randID,
+			Message: chatQueue[i],
+		}
+		cs := chatSize(&c)
+		if len(r) > 0 && size+cs > MAX_CHAT_BYTES_PER_SHARE {
+			return false // leave it for the next share
+		}
+		size += cs
+		r = append(r, c)
 		diff -= HASHES_PER_CHAT
+		return true
+	}
+	// chats whose earlier submission failed go first
+	for len(chatRetry) > 0 {
+		if !chatDelivered[chatRetry[0]] && !add(chatRetry[0]) {
+			break
+		}
+		chatRetry = chatRetry[1:]
+	}
+	for len(chatRetry) == 0 && chatInFlightIndex < len(chatQueue) {
+		if !chatDelivered[chatInFlightIndex] && !add(chatInFlightIndex) {
+			break
+		}
+		chatInFlightIndex++
+	}
+	if len(r) == 0 {
+		return nil
 	}
-	// TODO: verify the total bytes we will be sending is within the server's request size limit
 	return r
 }
 
+// chatSize returns the number of bytes the chat occupies in a serialized submit request.
+func chatSize(c *client.ChatToSend) int {
+	b, err := json.Marshal(c)
+	if err != nil {
+		crylog.Error("Failed to marshal chat:", err)
+		return 0
+	}
+	return len(b) + 1 // +1 for the separating comma
+}
+
+// HasChatsToSend returns true if any queued chat has yet to be confirmed sent.
 func HasChatsToSend() bool {
 	mutex.Lock()
 	defer mutex.Unlock()
 	return chatToSendIndex < len(chatQueue)
 }
 
+// PendingChats returns the queued chat messages that have yet to be confirmed sent, in the order
+// they were queued.
+func PendingChats() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	r := []string{}
+	for i := chatToSendIndex; i < len(chatQueue); i++ {
+		if !chatDelivered[i] {
+			r = append(r, chatQueue[i])
+		}
+	}
+	return r
+}
+
+// NumPendingChats returns the number of queued chat messages yet to be confirmed sent.
+func NumPendingChats() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	n := 0
+	for i := chatToSendIndex; i < len(chatQueue); i++ {
+		if !chatDelivered[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// ChatSent should be called for each chat returned by GetChatsToSend once the share it was
+// attached to has been accepted by the pool.
 func ChatSent(id int64) {
 	mutex.Lock()
 	defer mutex.Unlock()
-	if id == int64(chatToSendIndex)^randID {
+	i := int(id ^ randID)
+	if i < 0 || i >= len(chatQueue) {
+		crylog.Warn("ChatSent called with unknown chat id:", id)
+		return
+	}
+	chatDelivered[i] = true
+	for chatToSendIndex < len(chatQueue) && chatDelivered[chatToSendIndex] {
 		chatToSendIndex++
 	}
 }
 
+// ChatsNotSent should be called with the chats returned by GetChatsToSend if the share they were
+// attached to failed to be submitted or was rejected, so that they are attached to a later share.
+// Chats handed out for other shares still being submitted are unaffected.
+func ChatsNotSent(chats []client.ChatToSend) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i := range chats {
+		idx := int(chats[i].ID ^ randID)
+		if idx < 0 || idx >= chatInFlightIndex || chatDelivered[idx] {
+			continue
+		}
+		j := sort.SearchInts(chatRetry, idx)
+		if j < len(chatRetry) && chatRetry[j] == idx {
+			continue // already queued for retry
+		}
+		chatRetry = append(chatRetry, 0)
+		copy(chatRetry[j+1:], chatRetry[j:])
+		chatRetry[j] = idx
+	}
+}
+
 // ChatsReceived should be called by whenever the server returns a GetChatsResult. tokenSent should
 // be set to the value of NextToken that was used in the request to the server that produced the
 // GetChatsResult response.
@@ -96,12 +209,32 @@ func ChatsReceived(cr *client.GetChatsResult, tokenSent int64) {
 		crylog.Warn("chats updated since this fetch, discarding:", cr.Chats)
 		return
 	}
+	if received == nil {
+		received = map[chatKey]struct{}{}
+	}
 	for i := range cr.Chats {
-		receivedQueue = append(receivedQueue, &cr.Chats[i])
+		c := &cr.Chats[i]
+		k := chatKey{c.Username, c.ID, c.Timestamp}
+		if _, ok := received[k]; ok {
+			continue // already received in an earlier session
+		}
+		received[k] = struct{}{}
+		receivedQueue = append(receivedQueue, c)
 	}
 	nextToken = cr.NextToken
 }
 
+// ResetToken should be called upon a fresh pool login, since the chat cursor of the new session
+// may differ from that of the previous one. Chats are then fetched from the start as they are
+// when the miner starts up, and any the server sends again are not delivered twice. There's no
+// need to call it on reconnects within the same login, nor is the queue of chats to send affected,
+// so chats yet to be sent survive both reconnects and logins.
+func ResetToken() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	nextToken = 0
+}
+
 func HasChats() bool {
 	mutex.Lock()
 	defer mutex.Unlock()
@@ -0,0 +1,131 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cryptonote-social/csminer/stratum/client"
+)
+
+func resetQueue() {
+	chatQueue = nil
+	chatDelivered = nil
+	chatToSendIndex = 0
+	chatInFlightIndex = 0
+	chatRetry = nil
+}
+
+func TestGetChatsToSend(t *testing.T) {
+	resetQueue()
+	id1 := SendChat("one")
+	id2 := SendChat("two")
+	id3 := SendChat("three")
+
+	chats := GetChatsToSend(HASHES_PER_CHAT * 2)
+	if len(chats) != 2 || chats[0].ID != id1 || chats[1].ID != id2 {
+		t.Fatalf("expected first two chats, got: %v", chats)
+	}
+	// the first share failed, so its chats should be handed out again
+	ChatsNotSent(chats)
+	chats = GetChatsToSend(HASHES_PER_CHAT * 5)
+	if len(chats) != 3 {
+		t.Fatalf("expected all three chats after failed send, got: %v", chats)
+	}
+	ChatSent(id2)
+	ChatSent(id3)
+	if !HasChatsToSend() {
+		t.Error("expected first chat to be unconfirmed")
+	}
+	ChatSent(id1)
+	if HasChatsToSend() {
+		t.Error("expected all chats to be confirmed")
+	}
+	if c := GetChatsToSend(HASHES_PER_CHAT * 5); c != nil {
+		t.Errorf("expected no chats to send, got: %v", c)
+	}
+}
+
+func TestChatsNotSentInFlight(t *testing.T) {
+	resetQueue()
+	id1 := SendChat("one")
+	id2 := SendChat("two")
+	id3 := SendChat("three")
+
+	first := GetChatsToSend(HASHES_PER_CHAT)
+	second := GetChatsToSend(HASHES_PER_CHAT)
+	if len(first) != 1 || first[0].ID != id1 || len(second) != 1 || second[0].ID != id2 {
+		t.Fatalf("expected one chat per share, got: %v, %v", first, second)
+	}
+	// only the failed share's chat is handed out again, not the one still in flight with the other
+	ChatsNotSent(first)
+	ChatsNotSent(first)
+	chats := GetChatsToSend(HASHES_PER_CHAT * 5)
+	if len(chats) != 2 || chats[0].ID != id1 || chats[1].ID != id3 {
+		t.Fatalf("expected chats one and three after failed send, got: %v", chats)
+	}
+	if c := GetChatsToSend(HASHES_PER_CHAT * 5); c != nil {
+		t.Errorf("expected no chats to send, got: %v", c)
+	}
+}
+
+func TestGetChatsToSendSizeLimit(t *testing.T) {
+	resetQueue()
+	big := strings.Repeat("x", MAX_CHAT_BYTES_PER_SHARE/2)
+	SendChat(big)
+	SendChat(big)
+	chats := GetChatsToSend(HASHES_PER_CHAT * 5)
+	if len(chats) != 1 {
+		t.Fatalf("expected size limit to allow only one chat, got %d", len(chats))
+	}
+	chats = GetChatsToSend(HASHES_PER_CHAT * 5)
+	if len(chats) != 1 {
+		t.Fatalf("expected remaining chat to be sent with next share, got %d", len(chats))
+	}
+}
+
+func TestPendingChats(t *testing.T) {
+	resetQueue()
+	SendChat("one")
+	id2 := SendChat("two")
+	SendChat("three")
+	GetChatsToSend(HASHES_PER_CHAT * 5)
+	ChatSent(id2)
+	p := PendingChats()
+	if len(p) != 2 || p[0] != "one" || p[1] != "three" || NumPendingChats() != 2 {
+		t.Errorf("expected chats one and three to be pending, got: %v", p)
+	}
+}
+
+func TestResetToken(t *testing.T) {
+	resetQueue()
+	defer func() {
+		receivedQueue = nil
+		chatReceivedIndex = 0
+		received = nil
+		nextToken = 0
+	}()
+	id := SendChat("pending")
+
+	old := []client.ChatResult{{Username: "a", Message: "hi", ID: 1, Timestamp: 100}}
+	ChatsReceived(&client.GetChatsResult{Chats: old, NextToken: 7}, 0)
+	if NextToken() != 7 || NextChatReceived() == nil {
+		t.Fatalf("expected first chat to be received")
+	}
+
+	// a fresh login restarts the chat cursor, and the server resends the chat already received
+	ResetToken()
+	if NextToken() != 0 {
+		t.Errorf("expected token to be reset, got %v", NextToken())
+	}
+	resent := []client.ChatResult{old[0], {Username: "b", Message: "new", ID: 1, Timestamp: 200}}
+	ChatsReceived(&client.GetChatsResult{Chats: resent, NextToken: 3}, 0)
+	if c := NextChatReceived(); c == nil || c.Message != "new" {
+		t.Errorf("expected only the new chat after reset, got %+v", c)
+	}
+	if HasChats() {
+		t.Error("expected already received chat not to be delivered again")
+	}
+	if chats := GetChatsToSend(HASHES_PER_CHAT); len(chats) != 1 || chats[0].ID != id {
+		t.Errorf("expected pending chat to survive reset, got %v", chats)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package minerlib
+
+// minerlib/config.go implements client side validation of the advanced pool config string.
+
+import (
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// hasConfigOption returns true if the advanced config string specifies a value for the option.
+func hasConfigOption(config, key string) bool {
+	for _, opt := range strings.Split(config, ";") {
+		kv := strings.SplitN(opt, "=", 2)
+		if strings.TrimSpace(kv[0]) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// setConfigOption returns the advanced config string with the given option set to value, replacing
+// any existing value for it.
+func setConfigOption(config, key, value string) string {
+	opts := []string{}
+	for _, opt := range strings.Split(config, ";") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if strings.TrimSpace(kv[0]) == key {
+			continue
+		}
+		opts = append(opts, opt)
+	}
+	opts = append(opts, key+"="+value)
+	return strings.Join(opts, ";")
+}
+
+// validateAdvancedConfig checks the advanced config string (e.g. "start_diff=1000;donate=1.0")
+// for malformed values of the config keys known to the pool. Returns an error describing the first
+// problem found, or otherwise a (possibly empty) list of warnings about options that will be
+// passed to the pool unchecked.
+func validateAdvancedConfig(config string) (warnings []string, err error) {
+	for _, opt := range strings.Split(config, ";") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("config option '" + opt + "' should be of the form key=value")
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "start_diff":
+			d, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || d <= 0 {
+				return nil, errors.New("config option start_diff must be a positive integer, got: " + value)
+			}
+		case "donate":
+			d, err := strconv.ParseFloat(value, 64)
+			if err != nil || d < 0.0 || d > 100.0 {
+				return nil, errors.New("config option donate must be a percentage between 0 and 100, got: " + value)
+			}
+		case "email":
+			addr, err := mail.ParseAddress(value)
+			if err != nil || addr.Address != value {
+				return nil, errors.New("config option email is not a valid email address: " + value)
+			}
+		default:
+			warnings = append(warnings, "unrecognized config option '"+key+"' will be passed to the pool unchecked")
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package minerlib
+
+import (
+	"github.com/cryptonote-social/csminer/stratum/client"
+
+	"testing"
+	"time"
+)
+
+func TestSetConfigOption(t *testing.T) {
+	tests := []struct {
+		config, key, value, expected string
+	}{
+		{"", "donate", "2", "donate=2"},
+		{"start_diff=1000", "donate", "2", "start_diff=1000;donate=2"},
+		{"donate=1;start_diff=1000", "donate", "2.5", "start_diff=1000;donate=2.5"},
+		{" donate = 1 ;", "donate", "0", "donate=0"},
+	}
+	for _, test := range tests {
+		c := setConfigOption(test.config, test.key, test.value)
+		if c != test.expected {
+			t.Errorf("expected %q for setConfigOption(%q, %q, %q), got %q", test.expected, test.config, test.key, test.value, c)
+		}
+	}
+}
+
+func TestValidateAdvancedConfig(t *testing.T) {
+	good := []struct {
+		config   string
+		warnings int
+	}{
+		{"", 0},
+		{"start_diff=1000", 0},
+		{"start_diff=1000;donate=1.0", 0},
+		{" donate = 100 ; email=user@example.com;", 0},
+		{"donate=0;some_new_option=foo", 1},
+	}
+	for _, test := range good {
+		warnings, err := validateAdvancedConfig(test.config)
+		if err != nil {
+			t.Errorf("expected config %q to be valid, got error: %v", test.config, err)
+		}
+		if len(warnings) != test.warnings {
+			t.Errorf("expected %v warnings for config %q, got %v", test.warnings, test.config, warnings)
+		}
+	}
+
+	bad := []string{
+		"start_diff",
+		"start_diff=abc",
+		"start_diff=-5",
+		"donate=101",
+		"donate=1.0%",
+		"email=notanemail",
+		"email=Some User <user@example.com>",
+		"start_diff=1000;donate=-1",
+	}
+	for _, config := range bad {
+		if _, err := validateAdvancedConfig(config); err == nil {
+			t.Errorf("expected config %q to be invalid", config)
+		}
+	}
+}
+
+func TestReconnectConfig(t *testing.T) {
+	defer func() { lastDifficulty = 0 }()
+	lastDifficulty = 0
+	if c := reconnectConfig("donate=1"); c != "donate=1" {
+		t.Errorf("expected config to be unchanged without a known difficulty, got %q", c)
+	}
+	lastDifficulty = 25000
+	if c := reconnectConfig("start_diff=1000;donate=1"); c != "donate=1;start_diff=25000" {
+		t.Errorf("expected last difficulty as start_diff, got %q", c)
+	}
+}
+
+func TestApplyLoginHints(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	resp := &PoolLoginResponse{}
+	applyLoginHints(client.LoginHints{StartDiff: 50000, ServerTime: now.Add(-time.Hour).Unix()}, "donate=1", resp)
+	if resp.RecommendedStartDiff != 50000 || resp.ClockSkew != time.Hour {
+		t.Errorf("expected start diff and clock skew from hints, got %+v", resp)
+	}
+	resp = &PoolLoginResponse{}
+	applyLoginHints(client.LoginHints{}, "", resp)
+	if resp.RecommendedStartDiff != 0 || resp.ClockSkew != 0 {
+		t.Errorf("expected no hints, got %+v", resp)
+	}
+}
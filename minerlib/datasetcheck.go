@@ -0,0 +1,93 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package minerlib
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/cryptonote-social/csminer/crylog"
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+	"github.com/cryptonote-social/csminer/rx"
+)
+
+const (
+	// # of inputs hashed by each dataset integrity check. Each hash reads only a small sample of
+	// the dataset, so several are computed to cover more of it while keeping the check quick.
+	DATASET_CHECK_HASHES = 8
+)
+
+var (
+	// how often the dataset integrity is checked, see InitMinerArgs.DatasetCheckInterval
+	datasetCheckInterval time.Duration
+
+	// hashes of the check inputs computed right after the most recent seeding, or nil if there are
+	// none to check against. Only accessed by the mining loop.
+	datasetCheckReference [][]byte
+	lastDatasetCheck      time.Time
+
+	// overridden by tests, since the stub rxlib can't hash
+	datasetHashFunc   = rx.HashOnce
+	hashOnceSupported = rx.HashOnceSupported
+)
+
+// datasetCheckHashes returns the hashes of the check inputs under the current seed. Only call
+// while the workers are stopped.
+func datasetCheckHashes() ([][]byte, error) {
+	r := make([][]byte, DATASET_CHECK_HASHES)
+	for i := range r {
+		h, err := datasetHashFunc([]byte("csminer dataset check "+strconv.Itoa(i)), 0 /* thread */)
+		if err != nil {
+			return nil, err
+		}
+		r[i] = h
+	}
+	return r, nil
+}
+
+// recordDatasetCheck computes the reference hashes future integrity checks compare against. Call
+// after each seeding, while the workers are still stopped.
+func recordDatasetCheck(now time.Time) {
+	datasetCheckReference = nil
+	if datasetCheckInterval <= 0 {
+		return
+	}
+	ref, err := datasetCheckHashes()
+	if err != nil {
+		crylog.Warn("Disabling RandomX dataset integrity checks:", err)
+		return
+	}
+	datasetCheckReference = ref
+	lastDatasetCheck = now
+}
+
+// datasetCheckDue returns true if checkDataset would perform an integrity check.
+func datasetCheckDue(now time.Time) bool {
+	return datasetCheckReference != nil && now.Sub(lastDatasetCheck) >= datasetCheckInterval
+}
+
+// checkDataset verifies the RandomX dataset hasn't changed since it was seeded, e.g. due to a bit
+// flip in non-ECC memory, if a check is due. Returns false if it's corrupted, in which case the
+// caller should reseed. Only call while the workers are stopped.
+func checkDataset(now time.Time) bool {
+	if !datasetCheckDue(now) {
+		return true
+	}
+	lastDatasetCheck = now
+	hashes, err := datasetCheckHashes()
+	if err != nil {
+		crylog.Warn("RandomX dataset integrity check failed to compute hashes:", err)
+		return true
+	}
+	for i := range hashes {
+		if !bytes.Equal(hashes[i], datasetCheckReference[i]) {
+			stats.DatasetCheckFailed()
+			crylog.Error("RandomX dataset integrity check failed, the dataset appears to be corrupted. Memory may be faulty.")
+			return false
+		}
+	}
+	crylog.Info("RandomX dataset integrity re-verified")
+	return true
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package minerlib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+	"github.com/cryptonote-social/csminer/rx"
+)
+
+func TestCheckDataset(t *testing.T) {
+	corrupt := false
+	datasetHashFunc = func(input []byte, thread int) ([]byte, error) {
+		h := append([]byte(nil), input...)
+		if corrupt {
+			h[0] ^= 1
+		}
+		return h, nil
+	}
+	defer func() {
+		datasetHashFunc = rx.HashOnce
+		datasetCheckInterval = 0
+		datasetCheckReference = nil
+		stats.ResetAll()
+	}()
+	stats.ResetAll()
+	datasetCheckInterval = time.Hour
+	now := time.Now()
+	recordDatasetCheck(now)
+	if len(datasetCheckReference) != DATASET_CHECK_HASHES {
+		t.Fatalf("expected %d reference hashes, got %d", DATASET_CHECK_HASHES, len(datasetCheckReference))
+	}
+
+	corrupt = true
+	if !checkDataset(now.Add(time.Minute)) {
+		t.Error("expected no check before the interval elapsed")
+	}
+	if checkDataset(now.Add(time.Hour)) {
+		t.Error("expected corrupted dataset to fail the check")
+	}
+	if s, _, _ := stats.GetSnapshot(false); s.DatasetCheckFailures != 1 {
+		t.Errorf("expected 1 dataset check failure, got %d", s.DatasetCheckFailures)
+	}
+
+	// reseeding records new reference hashes
+	corrupt = false
+	recordDatasetCheck(now.Add(time.Hour))
+	if !checkDataset(now.Add(2 * time.Hour)) {
+		t.Error("expected intact dataset to pass the check")
+	}
+
+	// checks are disabled when hashing isn't supported
+	datasetHashFunc = func([]byte, int) ([]byte, error) { return nil, rx.ErrHashOnceUnsupported }
+	recordDatasetCheck(now)
+	if datasetCheckReference != nil || !checkDataset(now.Add(time.Hour)) {
+		t.Error("expected checks to be disabled")
+	}
+}
+
+func TestInitMinerRequiresHashOnce(t *testing.T) {
+	hashOnceSupported = func() bool { return false }
+	defer func() {
+		hashOnceSupported = rx.HashOnceSupported
+		datasetCheckInterval = 0
+		initialized = false
+	}()
+	for _, args := range []*InitMinerArgs{
+		{Threads: 1, DatasetCheckInterval: time.Hour},
+		{Threads: 1, SelfTest: true},
+	} {
+		if r := InitMiner(args); r.Code != 3 {
+			t.Errorf("expected bad config without hashing support, got %+v", r)
+		}
+	}
+}
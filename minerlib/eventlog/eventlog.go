@@ -0,0 +1,182 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+// Package eventlog appends a structured JSON record, one per line, for each share result and
+// mining activity state change, allowing a mining session to be analyzed after the fact.
+package eventlog
+
+import (
+	"github.com/cryptonote-social/csminer/crylog"
+
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	SHARE_EVENT          = "share"
+	ACTIVITY_STATE_EVENT = "activity_state"
+	BLOCK_FOUND_EVENT    = "block_found"
+
+	// Share results
+	SHARE_ACCEPTED  = "accepted"
+	SHARE_REJECTED  = "rejected"
+	SHARE_ABANDONED = "abandoned"
+	SHARE_DUPLICATE = "duplicate"
+	SHARE_FAILED    = "failed" // share could not be submitted due to connection failure
+
+	FLUSH_INTERVAL = 5 * time.Second
+)
+
+var (
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer // nil if event logging is disabled
+	done   chan struct{}
+
+	label string // see SetLabel
+)
+
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+
+	// Label of the miner instance that logged the event, if any. See SetLabel.
+	Label string `json:"label,omitempty"`
+
+	// Share event fields
+	JobID      string  `json:"job_id,omitempty"`
+	Difficulty int64   `json:"difficulty,omitempty"`
+	Result     string  `json:"result,omitempty"`
+	Hashrate   float64 `json:"hashrate,omitempty"` // recent hashrate at the time of the event, if known
+
+	// Activity state event fields
+	ActivityState int    `json:"activity_state,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+// Open enables event logging, appending events to the file at the given path. Any previously
+// opened event log is closed first.
+func Open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
+	if err != nil {
+		return err
+	}
+	Close()
+	mutex.Lock()
+	defer mutex.Unlock()
+	file = f
+	writer = bufio.NewWriter(f)
+	done = make(chan struct{})
+	go flushPeriodically(done)
+	return nil
+}
+
+// Close flushes any buffered events and disables event logging.
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if writer == nil {
+		return
+	}
+	close(done)
+	flush()
+	file.Close()
+	writer = nil
+	file = nil
+}
+
+// Flush writes any buffered events to the log file.
+func Flush() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	flush()
+}
+
+// mutex should be locked before calling
+func flush() {
+	if writer == nil {
+		return
+	}
+	if err := writer.Flush(); err != nil {
+		crylog.Error("Failed to flush event log:", err)
+	}
+}
+
+func flushPeriodically(done <-chan struct{}) {
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			Flush()
+		}
+	}
+}
+
+// SetLabel sets the label identifying this miner instance, which is included in every event
+// logged. An empty label omits it.
+func SetLabel(l string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	label = l
+}
+
+// Log appends the event to the log if event logging is enabled. If e.Time is zero it is set to the
+// current time.
+func Log(e *Event) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if writer == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Label == "" {
+		e.Label = label
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		crylog.Error("Failed to marshal event:", err)
+		return
+	}
+	data = append(data, '\n')
+	if _, err = writer.Write(data); err != nil {
+		crylog.Error("Failed to write event log:", err)
+	}
+}
+
+// LogShare records the result of a share found for the given job.
+func LogShare(jobID string, difficulty int64, result string, hashrate float64) {
+	Log(&Event{
+		Type:       SHARE_EVENT,
+		JobID:      jobID,
+		Difficulty: difficulty,
+		Result:     result,
+		Hashrate:   hashrate,
+	})
+}
+
+// LogBlockFound records that a hash of the given difficulty meeting the network difficulty was found
+// for the given job.
+func LogBlockFound(jobID string, difficulty int64) {
+	Log(&Event{
+		Type:       BLOCK_FOUND_EVENT,
+		JobID:      jobID,
+		Difficulty: difficulty,
+	})
+}
+
+// LogActivityState records a change in the mining activity state.
+func LogActivityState(state int, message string) {
+	Log(&Event{
+		Type:          ACTIVITY_STATE_EVENT,
+		ActivityState: state,
+		Message:       message,
+	})
+}
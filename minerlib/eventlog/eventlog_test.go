@@ -0,0 +1,53 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	LogShare("ignored", 1, SHARE_ACCEPTED, 0.0) // should be a no-op since the log isn't open
+	if err := Open(path); err != nil {
+		t.Fatalf("failed to open event log: %v", err)
+	}
+	LogShare("job1", 1000, SHARE_ACCEPTED, 123.5)
+	LogShare("job2", 2000, SHARE_REJECTED, 0.0)
+	SetLabel("living-room-pc")
+	LogActivityState(1, "ACTIVE")
+	SetLabel("")
+	Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open event log for reading: %v", err)
+	}
+	defer f.Close()
+	events := []Event{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("failed to unmarshal event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %v", len(events))
+	}
+	if events[0].Type != SHARE_EVENT || events[0].JobID != "job1" || events[0].Difficulty != 1000 || events[0].Result != SHARE_ACCEPTED {
+		t.Errorf("unexpected first event: %+v", events[0])
+	}
+	if events[1].Result != SHARE_REJECTED {
+		t.Errorf("expected rejected share, got %+v", events[1])
+	}
+	if events[0].Label != "" {
+		t.Errorf("expected no label before SetLabel, got %q", events[0].Label)
+	}
+	if events[2].Type != ACTIVITY_STATE_EVENT || events[2].ActivityState != 1 || events[2].Label != "living-room-pc" {
+		t.Errorf("unexpected activity state event: %+v", events[2])
+	}
+}
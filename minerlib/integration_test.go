@@ -0,0 +1,200 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package minerlib
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cryptonote-social/csminer/blockchain"
+	"github.com/cryptonote-social/csminer/minerlib/chat"
+	"github.com/cryptonote-social/csminer/rx"
+	"github.com/cryptonote-social/csminer/stratum/client"
+	"github.com/cryptonote-social/csminer/stratum/stratumtest"
+)
+
+// How long to wait for the miner to reach each expected state. Generous since seeding RandomX
+// builds the full dataset before the first share can be found.
+const INTEGRATION_TIMEOUT = 2 * time.Minute
+
+// waitFor polls cond until it returns true, failing the test if that takes too long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(INTEGRATION_TIMEOUT)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeNonce is the nonce of the last share found by fakeHashUntil.
+var fakeNonce uint32
+
+// fakeHashUntil stands in for rx.HashUntil, since the stub rxlib can't find shares. It finds a
+// share of difficulty 2^20 every few milliseconds until stopped, each with a distinct nonce.
+func fakeHashUntil(blob []byte, difficulty uint64, thread int, hash []byte, nonce []byte, stopper *uint32) int64 {
+	for hashes := int64(1); ; hashes++ {
+		time.Sleep(time.Millisecond)
+		if atomic.LoadUint32(stopper) != 0 {
+			return -hashes
+		}
+		if hashes%5 == 0 && difficulty <= 1<<20 {
+			for i := range hash {
+				hash[i] = 0
+			}
+			hash[29] = 0x10 // little endian 2^236, i.e. difficulty 2^256 / 2^236
+			binary.LittleEndian.PutUint32(nonce, atomic.AddUint32(&fakeNonce, 1))
+			return hashes
+		}
+	}
+}
+
+// TestPoolIntegration runs the miner against a mock pool over a real socket, covering the login,
+// job, submit, chat and reconnect flows. Shares are found by the mining loop's workers, using
+// fakeHashUntil in place of RandomX, and submitted over its share submission path, just as when
+// mining against the real pool.
+func TestPoolIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("initializes RandomX")
+	}
+	pool, err := stratumtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	testPoolAddr = pool.Addr()
+	hashUntilFunc = fakeHashUntil
+	defer func() {
+		testPoolAddr = ""
+		hashUntilFunc = rx.HashUntil
+		initialized = false
+	}()
+
+	if r := InitMiner(&InitMinerArgs{Threads: 1}); r.Code != 1 && r.Code != 2 {
+		t.Fatalf("InitMiner failed: %+v", r)
+	}
+	if r := PoolLogin(&PoolLoginArgs{Username: "tester", RigID: "rig", Agent: "csminer-test"}); r.Code != 1 {
+		t.Fatalf("PoolLogin failed: %+v", r)
+	}
+	defer StopMining()
+	OverrideMiningActivityState(true)
+	defer RemoveMiningActivityOverride()
+	if l := pool.Logins(); len(l) != 1 || l[0].Login != "tester" || l[0].RigID != "rig" {
+		t.Errorf("unexpected logins: %+v", l)
+	}
+
+	// the login job's target of difficulty 1 makes every hash found a share, which the workers
+	// submit
+	waitFor(t, "login job", func() bool { return GetMiningState().CurrentDifficulty == 1 })
+	waitFor(t, "share for login job", func() bool { return GetMiningState().SharesAccepted > 0 })
+	if s := pool.Submits(); len(s) == 0 || s[0].JobID != "1" || len(s[0].Nonce) != 8 || s[0].ID != stratumtest.SESSION_ID {
+		t.Errorf("unexpected submits: %+v", s)
+	}
+
+	// jobs pushed by the pool replace the current one
+	job := stratumtest.NewJob("2")
+	job.Target = "00e00000"
+	pool.PushJob(job)
+	want := blockchain.TargetToDifficulty(job.Target)
+	waitFor(t, "pushed job", func() bool { return GetMiningState().CurrentDifficulty == want })
+	waitFor(t, "share for pushed job", func() bool {
+		s := pool.Submits()
+		return s[len(s)-1].JobID == "2"
+	})
+
+	// rejections are reported
+	pool.SetSubmitError(&client.RPCError{Code: -1, Message: "Low difficulty share"})
+	waitFor(t, "rejected share", func() bool { return GetMiningState().RejectedLowDifficulty > 0 })
+	pool.SetSubmitError(nil)
+
+	// chats from the pool are delivered
+	pool.AddChat("alice", "hello")
+	GetChats()
+	if c := chat.NextChatReceived(); c == nil || c.Username != "alice" || c.Message != "hello" {
+		t.Errorf("expected chat from pool, got %+v", c)
+	}
+
+	// the miner logs in again after losing the connection
+	pool.DropConnections()
+	waitFor(t, "reconnect", func() bool { return len(pool.Logins()) == 2 })
+}
+
+// chanJobSource is a JobSource delivering the jobs sent over its channel.
+type chanJobSource struct {
+	jobs chan *client.MultiClientJob
+}
+
+func (s chanJobSource) Connect() (<-chan *client.MultiClientJob, error) { return s.jobs, nil }
+func (s chanJobSource) IsAlive() bool                                   { return true }
+func (s chanJobSource) Close() {
+	select {
+	case s.jobs <- nil:
+	default:
+	}
+}
+
+// recordingShareSink is a ShareSink accepting every share, recording the job ID and nonce of each.
+type recordingShareSink struct {
+	mutex   sync.Mutex
+	submits []string
+}
+
+func (s *recordingShareSink) SubmitWork(nonce string, jobid string, chats []client.ChatToSend, chatToken int64, connNonce []byte) (*client.Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.submits = append(s.submits, jobid+":"+nonce)
+	r := json.RawMessage(`{"status":"OK","PoolMargin":0.01}`)
+	return &client.Response{ID: client.SUBMIT_WORK_JSON_ID, Result: &r}, nil
+}
+func (s *recordingShareSink) IsAlive() bool { return true }
+func (s *recordingShareSink) Close()        {}
+
+func (s *recordingShareSink) get() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.submits...)
+}
+
+// TestCustomJobSource mines a job fed by an injected JobSource, checking the shares found are
+// submitted to the injected ShareSink rather than the pool.
+func TestCustomJobSource(t *testing.T) {
+	if testing.Short() {
+		t.Skip("initializes RandomX")
+	}
+	hashUntilFunc = fakeHashUntil
+	defer func() {
+		hashUntilFunc = rx.HashUntil
+		initialized = false
+		configMutex.Lock()
+		jobSource = poolJobSource{}
+		shareSink = &cl
+		configMutex.Unlock()
+	}()
+
+	if r := InitMiner(&InitMinerArgs{Threads: 1}); r.Code != 1 && r.Code != 2 {
+		t.Fatalf("InitMiner failed: %+v", r)
+	}
+	source := chanJobSource{jobs: make(chan *client.MultiClientJob, 1)}
+	sink := &recordingShareSink{}
+	if r := PoolLogin(&PoolLoginArgs{Username: "tester", RigID: "rig", JobSource: source, ShareSink: sink}); r.Code != 1 {
+		t.Fatalf("PoolLogin failed: %+v", r)
+	}
+	defer StopMining()
+	OverrideMiningActivityState(true)
+	defer RemoveMiningActivityOverride()
+
+	// the job's target of difficulty 1 makes every hash found a share
+	source.jobs <- stratumtest.NewJob("custom")
+	waitFor(t, "share submitted to sink", func() bool { return len(sink.get()) > 0 })
+	if s := sink.get()[0]; !strings.HasPrefix(s, "custom:") || len(s) != len("custom:")+8 {
+		t.Errorf("unexpected submit: %q", s)
+	}
+	waitFor(t, "accepted share", func() bool { return GetMiningState().SharesAccepted > 0 })
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package minerlib
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Length of one on/off cycle when mining at reduced intensity. Kept short so that a worker
+	// interrupted by stopWorkers in the middle of an on period finishes promptly.
+	DUTY_CYCLE_PERIOD = 250 * time.Millisecond
+)
+
+// dutyCycle throttles a single worker thread to an approximate percentage of full utilization by
+// alternately hashing and sleeping. A nil *dutyCycle means mining at full intensity.
+type dutyCycle struct {
+	onTime, offTime time.Duration
+	stop            uint32 // atomic int set when the current on period is over
+	timer           *time.Timer
+}
+
+// newDutyCycle returns a duty cycle for the given intensity percentage, or nil if no throttling is
+// required.
+func newDutyCycle(intensity int) *dutyCycle {
+	if intensity <= 0 || intensity >= 100 {
+		return nil
+	}
+	on := DUTY_CYCLE_PERIOD * time.Duration(intensity) / 100
+	return &dutyCycle{onTime: on, offTime: DUTY_CYCLE_PERIOD - on}
+}
+
+// stopper returns the stopper that should be passed to rx.HashUntil, starting a new on period if
+// one isn't already in progress. At full intensity it's own, the worker's own stopper.
+func (d *dutyCycle) stopper(own *uint32) *uint32 {
+	if d == nil {
+		return own
+	}
+	if d.timer == nil {
+		atomic.StoreUint32(&d.stop, 0)
+		d.timer = time.AfterFunc(d.onTime, func() { atomic.StoreUint32(&d.stop, 1) })
+	}
+	return &d.stop
+}
+
+// pause should be called whenever rx.HashUntil returns without finding a share, passing the
+// worker's own stopper. It returns false if the worker was told to stop by stopWorkers, and
+// otherwise sleeps for the off period and returns true to indicate hashing should resume. At full
+// intensity there's no off period, and hashing resumes only if the worker was interrupted.
+func (d *dutyCycle) pause(own *uint32) bool {
+	if d == nil {
+		// clear own before checking the global stopper, since stopWorkers sets them in the
+		// opposite order
+		return atomic.SwapUint32(own, 0) != 0 && atomic.LoadUint32(&stopper) == 0
+	}
+	if atomic.LoadUint32(&stopper) != 0 {
+		return false
+	}
+	d.timer = nil
+	time.Sleep(d.offTime)
+	return atomic.LoadUint32(&stopper) == 0
+}
+
+// release cleans up any pending on period timer.
+func (d *dutyCycle) release() {
+	if d != nil && d.timer != nil {
+		d.timer.Stop()
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package minerlib
+
+import (
+	"testing"
+)
+
+func TestNewDutyCycle(t *testing.T) {
+	if newDutyCycle(100) != nil || newDutyCycle(0) != nil {
+		t.Error("expected no duty cycle at full intensity")
+	}
+	d := newDutyCycle(60)
+	if d == nil {
+		t.Fatal("expected duty cycle for intensity 60")
+	}
+	if d.onTime+d.offTime != DUTY_CYCLE_PERIOD || d.onTime != DUTY_CYCLE_PERIOD*60/100 {
+		t.Errorf("unexpected duty cycle on:%v off:%v", d.onTime, d.offTime)
+	}
+}
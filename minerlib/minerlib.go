@@ -4,6 +4,7 @@ import (
 	"github.com/cryptonote-social/csminer/blockchain"
 	"github.com/cryptonote-social/csminer/crylog"
 	"github.com/cryptonote-social/csminer/minerlib/chat"
+	"github.com/cryptonote-social/csminer/minerlib/eventlog"
 	"github.com/cryptonote-social/csminer/minerlib/stats"
 	"github.com/cryptonote-social/csminer/rx"
 	"github.com/cryptonote-social/csminer/stratum/client"
@@ -13,7 +14,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -44,6 +47,20 @@ const (
 	// policy.
 	MINING_PAUSED_NO_LOGIN = -7
 
+	// Indicates miner is paused because too many consecutive shares were rejected even after
+	// reconnecting to the pool. Overriding the mining state or logging in again will resume mining.
+	MINING_PAUSED_TOO_MANY_REJECTS = -8
+
+	// Indicates miner is paused because a process the user asked to pause mining for is running
+	MINING_PAUSED_PROCESS = -9
+
+	// Indicates miner is paused because the CPU reached its maximum temperature, and has yet to
+	// cool to the resume temperature
+	MINING_PAUSED_TEMPERATURE = -10
+
+	// Indicates miner is paused by the user until a specified time, see PauseUntil
+	MINING_PAUSED_TIMED = -11
+
 	// Indicates miner is actively mining
 	MINING_ACTIVE = 1
 
@@ -62,37 +79,180 @@ const (
 	DECREASE_THREADS_POKE = 7
 	EXIT_LOOP_POKE        = 8
 	UPDATE_STATS_POKE     = 9
+	RESET_STATS_POKE      = 10
+	RESTART_WORKERS_POKE  = 11
+
+	// username mined on behalf of when the user doesn't specify their own, with earnings going to
+	// donate.getmonero.org
+	DONATE_USERNAME = "donate-getmonero-org"
+
+	OVERRIDE_MINE        = 1
+	OVERRIDE_PAUSE       = 2
+	OVERRIDE_SOFT_PAUSE  = 3 // like OVERRIDE_PAUSE but preserves the recent hashrate window
+	OVERRIDE_PAUSE_TIMED = 4 // like OVERRIDE_PAUSE but removed automatically at pausedUntil
+
+	// soft pauses lasting longer than this will not preserve the recent hashrate window
+	SOFT_PAUSE_MAX_DURATION = 10 * time.Minute
+
+	// How often to check whether huge pages have become available when RandomX was initialized
+	// without them.
+	HUGE_PAGES_RECHECK_INTERVAL = 5 * time.Minute
+
+	// Clock skew relative to the pool server beyond which the user is warned.
+	MAX_CLOCK_SKEW = 5 * time.Minute
+
+	// How often the hashing monitor checks that hashes are being computed while mining is active.
+	HASH_MONITOR_INTERVAL = 15 * time.Second
+
+	// Minimum length in bytes of a valid job blob, which must at least extend past the 4 byte nonce
+	// at offset 39.
+	MIN_BLOB_LENGTH = 43
+
+	// Default for how often the mining loop has otherwise undisturbed workers tally their hashes.
+	// Worker threads only report their hash counts when interrupted, so this bounds how stale the
+	// reported hashrate can be, and must be well under hashStallTimeout to keep the hashing monitor
+	// from mistaking a long stretch without shares for a stall.
+	DEFAULT_WORKER_REFRESH_INTERVAL = 30 * time.Second
+
+	// number of times a share is submitted when the pool connection is found dead at submit time,
+	// after which it's dropped
+	MAX_SHARE_SUBMIT_ATTEMPTS = 3
+
+	// how long a requeued share waits for the mining loop to receive a job over a new connection
+	// before it's dropped
+	REQUEUE_TIMEOUT = 100 * time.Second
+
+	// Shortest interval at which StreamStats produces snapshots. Shorter intervals, including
+	// non-positive ones, are raised to it.
+	MIN_STREAM_STATS_INTERVAL = 100 * time.Millisecond
+
+	// Default minimum time between the pool stats refreshes triggered by accepted shares whose
+	// response lacked pool stats, so that a high share rate doesn't flood the stats server.
+	DEFAULT_POOL_STATS_REFRESH_INTERVAL = time.Minute
 
-	OVERRIDE_MINE  = 1
-	OVERRIDE_PAUSE = 2
+	// number of reconnects forced by the reject circuit breaker before it pauses mining instead
+	MAX_REJECT_RECONNECTS = 3
 )
 
 var (
+	// nowFunc returns the current time. Tests can override it to control time-dependent logic.
+	nowFunc = time.Now
+
+	// testPoolAddr, if non-empty, is the host:port of a mock pool that tests connect to instead of
+	// cryptonote.social.
+	testPoolAddr string
+
+	// initMutex serializes calls to InitMiner, and initialized is set once one has succeeded.
+	initMutex   sync.Mutex
+	initialized bool
+
 	// miner config
 	configMutex sync.Mutex
 	// plArgs (pool login args) is nil if nobody is currently logged in, which also implies
 	// dispatch loop isn't active.
 	plArgs                           *PoolLoginArgs
-	threads                          int
+	threads                          int    // # of threads rxlib has successfully initialized
+	configuredThreads                int    // # of threads requested by the user
+	threadShortfallWarned            bool   // true while a shortfall of running threads has been logged
+	intensity                        int    // approximate % of full utilization each thread mines at
+	minShareDiff                     int64  // shares below this difficulty are not submitted
+	hugePages                        bool   // true if RandomX was initialized with huge pages
+	hugePagesRestartRecommended      bool   // true if huge pages became available after init
+	rxFlags                          string // RandomX flags in effect, see rx.ActiveFlags
+	label                            string // see InitMinerArgs.Label
+	poolStatsRefreshInterval         time.Duration
+	lastPoolStatsRefresh             time.Time // when pool stats were last requested
 	lastSeed                         []byte
 	excludeHourStart, excludeHourEnd int
+	submitOnlyWhenMining             bool
+	quietShares                      bool // if true, don't log each share found
+	warmStandby                      bool
+	separateSubmitConn               bool
+	multiclient                      bool // see InitMinerArgs.Multiclient
+	noJobTimeout                     time.Duration
+	hashStallTimeout                 time.Duration
+	lastDifficulty                   int64 // difficulty of the most recent job, used as start_diff when reconnecting
+
+	// upper limit on configuredThreads when adding threads, since more threads than CPUs won't help
+	maxThreads = runtime.NumCPU()
+
+	// how often the mining loop has workers tally their hashes, see WorkerRefreshInterval
+	workerRefreshInterval = DEFAULT_WORKER_REFRESH_INTERVAL
+
+	// called whenever a block is found, see InitMinerArgs.BlockFoundCallback
+	blockFoundCallback func(jobID string, hashDifficulty, networkDifficulty int64)
+
+	// called whenever a share is accepted, see InitMinerArgs.ShareAcceptedCallback
+	shareAcceptedCallback func(jobID string, difficulty int64)
+
+	// reject circuit breaker state
+	maxRejectedBeforeReconnect int
+	consecutiveRejects         int
+	rejectReconnects           int  // reconnects forced since the last accepted share
+	rejectPaused               bool // true if mining is paused due to MINING_PAUSED_TOO_MANY_REJECTS
+
+	// currentJobID is the ID of the job the workers were most recently dispatched to mine, or empty
+	// if there is no such job. currentJobConnNonce is its ConnNonce, and currentJobConn the job
+	// connection (see nextJobConn) it was received over. currentJobChanged is closed and replaced
+	// whenever they're set. Protected by configMutex.
+	currentJobID        string
+	currentJobConnNonce uint32
+	currentJobConn      uint64
+	currentJobChanged   = make(chan struct{})
+	jobConnCount        uint64
 
 	doneChanMutex      sync.Mutex
 	miningLoopDoneChan chan bool // non-nil when a mining loop is active
 
 	batteryPower   bool
+	batteryPercent = -1 // battery charge level reported by the machine, or -1 if unknown
 	screenIdle     bool
-	miningOverride int // 0 == no override, OVERRIDE_MINE == always mine, OVERRIDE_PAUSE == don't mine
+	miningOverride int // 0 == no override, OVERRIDE_MINE == always mine, OVERRIDE_PAUSE, OVERRIDE_SOFT_PAUSE or OVERRIDE_PAUSE_TIMED == don't mine
+
+	// when an OVERRIDE_PAUSE_TIMED override ends, and the timer that removes it then
+	pausedUntil time.Time
+	pauseTimer  *time.Timer
+
+	// true while a process the user asked to pause mining for is running, see ReportBlockingProcess
+	blockingProcess bool
+
+	// how long mining continues after the screen becomes active, see InitMinerArgs.PauseGracePeriod
+	pauseGracePeriod time.Duration
+	graceTimer       *time.Timer // non-nil while the screen is active but within the grace period
 
 	// stratum client
 	cl client.Client
 
+	// where jobs come from and shares go, which are the stratum client unless the login specified
+	// otherwise
+	jobSource JobSource = poolJobSource{}
+	shareSink ShareSink = &cl
+
+	// warm standby stratum client, non-nil only while warmStandby is set and its connection is
+	// established. Its connection is handed over to cl whenever cl's connection drops. Protected by
+	// configMutex, see takeStandby.
+	standbyCl *client.Client
+
+	// dedicated share submission stratum client, used only when separateSubmitConn is set. See
+	// submitConnSink.
+	submitCl client.Client
+
 	// used to send messages to main job loop to take various actions
-	pokeChannel chan int
+	pokeMutex   sync.Mutex
+	pokeChannel chan int // protected by pokeMutex, see getPokeChannel
+
+	// nonces found for the job with ID submittedJobID, used to avoid submitting duplicate shares
+	submittedMutex  sync.Mutex
+	submittedJobID  string
+	submittedNonces map[string]struct{}
 
 	// Worker thread synchronization vars
 	wg      sync.WaitGroup // used to wait for stopped worker threads to finish
 	stopper uint32         // atomic int used to signal rxlib worker threads to stop mining
+	workers int32          // atomic count of currently running worker threads
+
+	// overridden by tests, since the stub rxlib can't find shares
+	hashUntilFunc = rx.HashUntil
 )
 
 type PoolLoginArgs struct {
@@ -119,6 +279,16 @@ type PoolLoginArgs struct {
 
 	// Dev: Whether to connect to the dev server or prod
 	Dev bool
+
+	// JobSource: if non-nil, jobs are received from this source instead of by connecting to the
+	// pool, and shares found are submitted to ShareSink, which must also be specified. This allows
+	// the miner to serve as a hashing engine for a relay or proxy.
+	JobSource JobSource
+
+	// ShareSink: if non-nil, shares found are submitted to this sink instead of the pool. Without a
+	// JobSource, jobs still come from the pool, so the sink can intercept, transform, or batch
+	// shares and forward them on to the pool via PoolShareSink.
+	ShareSink ShareSink
 }
 
 type PoolLoginResponse struct {
@@ -134,6 +304,12 @@ type PoolLoginResponse struct {
 	Code      int
 	Message   string
 	MessageID int
+
+	// Structured hints sent by the pool on successful login. RecommendedStartDiff is 0 if the pool
+	// made no recommendation. ClockSkew is how far the local clock is ahead of the pool server's,
+	// or 0 if the pool didn't report its time.
+	RecommendedStartDiff int64
+	ClockSkew            time.Duration
 }
 
 // See MINING_ACTIVITY const values above for all possibilities. Shorter story: negative value ==
@@ -147,14 +323,21 @@ func getMiningActivityState() int {
 	}
 
 	// User-override pause trumps all:
-	if miningOverride == OVERRIDE_PAUSE {
+	if miningOverride == OVERRIDE_PAUSE || miningOverride == OVERRIDE_SOFT_PAUSE {
 		return MINING_PAUSED_USER_OVERRIDE
 	}
+	if miningOverride == OVERRIDE_PAUSE_TIMED {
+		return MINING_PAUSED_TIMED
+	}
 	// If there is no pool connection, we cannot mine.
-	if !cl.IsAlive() {
+	if !jobSource.IsAlive() {
 		return MINING_PAUSED_NO_CONNECTION
 	}
 
+	if rejectPaused {
+		return MINING_PAUSED_TOO_MANY_REJECTS
+	}
+
 	if miningOverride == OVERRIDE_MINE {
 		return MINING_ACTIVE_USER_OVERRIDE
 	}
@@ -170,6 +353,12 @@ func getMiningActivityState() int {
 	if batteryPower {
 		return MINING_PAUSED_BATTERY_POWER
 	}
+	if blockingProcess {
+		return MINING_PAUSED_PROCESS
+	}
+	if thermalPaused {
+		return MINING_PAUSED_TEMPERATURE
+	}
 	if !screenIdle {
 		return MINING_PAUSED_SCREEN_ACTIVITY
 	}
@@ -178,6 +367,9 @@ func getMiningActivityState() int {
 }
 
 func getServerHostPort(useTLS, dev bool) string {
+	if testPoolAddr != "" {
+		return testPoolAddr
+	}
 	switch {
 	case useTLS && !dev:
 		return "cryptonote.social:5556"
@@ -192,40 +384,58 @@ func getServerHostPort(useTLS, dev bool) string {
 }
 
 // Called by the user to log into the pool for the first time, or re-log into the pool with new
-// credentials.
+// credentials. When logging into the pool (rather than a custom JobSource), the new connection is
+// established and its first job checked before any active mining loop is shut down, so that mining
+// continues nearly uninterrupted when switching logins or configs. Should the new login fail, the
+// existing one, if any, remains active.
 func PoolLogin(args *PoolLoginArgs) *PoolLoginResponse {
 	crylog.Info("Pool login called")
 	doneChanMutex.Lock()
 	defer doneChanMutex.Unlock()
-	if miningLoopDoneChan != nil {
-		crylog.Info("Pool login: shutting down previous mining loop")
-		// trigger close of previous mining loop
-		pokeJobDispatcher(EXIT_LOOP_POKE)
-		// wait until previous mining loop completes
-		<-miningLoopDoneChan
-		miningLoopDoneChan = nil
-		crylog.Info("Pool login: Previous loop done")
-	}
 
-	configMutex.Lock()
-	defer configMutex.Unlock()
-	plArgs = nil
 	r := &PoolLoginResponse{}
-	loginName := args.Username
 	if strings.Index(args.Username, ".") != -1 {
 		// Handle this specially since xmrig style login might cause users to specify wallet.username here
 		r.Code = 2
 		r.Message = "The '.' character is not allowed in usernames."
 		return r
 	}
-	if args.Wallet != "" {
-		loginName = args.Wallet + "." + args.Username
+	warnings, err := validateAdvancedConfig(args.Config)
+	if err != nil {
+		r.Code = 2
+		r.Message = "Invalid config: " + err.Error()
+		return r
+	}
+	for _, w := range warnings {
+		crylog.Warn("Config warning:", w)
+	}
+	if args.JobSource != nil {
+		if args.ShareSink == nil {
+			r.Code = 2
+			r.Message = "ShareSink must be specified along with JobSource"
+			return r
+		}
+		stopMiningLoop()
+		configMutex.Lock()
+		defer configMutex.Unlock()
+		plArgs = nil
+		lastDifficulty = 0
+		resetRejectCircuitBreaker()
+		jc, err := args.JobSource.Connect()
+		if err != nil {
+			r.Code = -1
+			r.Message = err.Error()
+			return r
+		}
+		jobSource = args.JobSource
+		shareSink = args.ShareSink
+		return startMiningLoop(args, jc, nil)
 	}
-	agent := args.Agent
-	config := args.Config
-	rigid := args.RigID
+
+	// Stage the new connection while any existing mining loop keeps running.
+	staged := &client.Client{}
 	dest := getServerHostPort(args.UseTLS, args.Dev)
-	err, code, message, jc := cl.Connect(dest, args.UseTLS, agent, loginName, config, rigid)
+	err, code, message, jc := staged.Connect(dest, args.UseTLS, args.Agent, getLoginName(args), args.Config, args.RigID)
 	if err != nil {
 		if code != 0 {
 			//crylog.Error("Pool server did not allow login due to error:")
@@ -253,15 +463,147 @@ func PoolLogin(args *PoolLoginArgs) *PoolLoginResponse {
 		r.MessageID = code
 		r.Message = message
 	}
+	firstJob := <-jc // delivered along with the login response so never blocks
+	if firstJob == nil {
+		staged.Close()
+		r.Code = -1
+		r.Message = "pool connection closed immediately after login"
+		return r
+	}
+	if err := checkJob(firstJob); err != nil {
+		staged.Close()
+		r.Code = -1
+		r.Message = "pool sent a job that can't be mined: " + err.Error()
+		return r
+	}
+
+	// The new connection is good, so hand off to it.
+	stopMiningLoop()
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	lastDifficulty = 0
+	resetRejectCircuitBreaker()
+	jobSource = poolJobSource{}
+	shareSink = PoolShareSink()
+	if args.ShareSink != nil {
+		shareSink = args.ShareSink
+	}
+	cl.TakeOver(staged)
+	chat.ResetToken() // the new session's chat cursor may differ
+	stats.Connected(cl.ConnectTimings(), cl.TLSInfo())
+	resp := startMiningLoop(args, jc, firstJob)
+	resp.MessageID = r.MessageID
+	resp.Message = r.Message
+	applyLoginHints(cl.LoginHints(), args.Config, resp)
+	return resp
+}
+
+// stopMiningLoop shuts down the active mining loop, if any, and waits for it to finish. doneChanMutex
+// must be held.
+func stopMiningLoop() {
+	if miningLoopDoneChan == nil {
+		return
+	}
+	crylog.Info("Shutting down mining loop")
+	// trigger close of the mining loop
+	pokeJobDispatcher(EXIT_LOOP_POKE)
+	// wait until the mining loop completes
+	<-miningLoopDoneChan
+	miningLoopDoneChan = nil
+	crylog.Info("Mining loop done")
+}
+
+// StopMining stops mining and logs out of the pool, closing the pool connection and flushing the
+// event log. Intended for use before exiting, though mining can be restarted by calling PoolLogin
+// again.
+func StopMining() {
+	doneChanMutex.Lock()
+	defer doneChanMutex.Unlock()
+	stopMiningLoop()
+	configMutex.Lock()
+	plArgs = nil
+	source := jobSource
+	configMutex.Unlock()
+	source.Close()
+	if n := chat.NumPendingChats(); n > 0 {
+		crylog.Warn("Stopping with", n, "queued chat(s) unsent")
+	}
+	eventlog.Flush()
+}
+
+// applyLoginHints surfaces the structured hints sent by the pool at login in the login response,
+// logging any the user should act on.
+func applyLoginHints(hints client.LoginHints, config string, resp *PoolLoginResponse) {
+	if hints.StartDiff > 0 {
+		resp.RecommendedStartDiff = hints.StartDiff
+		if !hasConfigOption(config, "start_diff") {
+			crylog.Info("Pool recommends the advanced config option: start_diff=" + strconv.FormatInt(hints.StartDiff, 10))
+		}
+	}
+	if hints.ServerTime > 0 {
+		resp.ClockSkew = nowFunc().Sub(time.Unix(hints.ServerTime, 0))
+		if resp.ClockSkew > MAX_CLOCK_SKEW || resp.ClockSkew < -MAX_CLOCK_SKEW {
+			crylog.Warn("System clock differs from the pool server's by", resp.ClockSkew.Round(time.Second),
+				"-- time of day exclusion may not behave as expected")
+		}
+	}
+}
 
-	// login successful
+// startMiningLoop records the successful login and starts the mining loop on the given job channel,
+// with firstJob, if non-nil, being a job already read from it. configMutex and doneChanMutex must be
+// held.
+func startMiningLoop(args *PoolLoginArgs, jc <-chan *client.MultiClientJob, firstJob *client.MultiClientJob) *PoolLoginResponse {
 	plArgs = args
-	r.Code = 1
+	lastPoolStatsRefresh = nowFunc()
 	go stats.RefreshPoolStats(plArgs.Username)
 	miningLoopDoneChan = make(chan bool, 1)
-	go MiningLoop(jc, miningLoopDoneChan)
+	go miningLoop(jc, firstJob, miningLoopDoneChan)
 	crylog.Info("Successful login:", plArgs.Username)
-	return r
+	return &PoolLoginResponse{Code: 1}
+}
+
+// SetDonation changes the percentage (0-100) of earnings donated to the pool by logging in again
+// with an updated config string. Since this is a 'secure' config change, the pool only honors it
+// if the current login specifies a wallet.
+func SetDonation(percent float64) *PoolLoginResponse {
+	if percent < 0.0 || percent > 100.0 {
+		return &PoolLoginResponse{Code: 2, Message: "donation percentage must be between 0 and 100"}
+	}
+	configMutex.Lock()
+	if plArgs == nil {
+		configMutex.Unlock()
+		return &PoolLoginResponse{Code: 2, Message: "not logged in"}
+	}
+	config := plArgs.Config
+	wallet := plArgs.Wallet
+	configMutex.Unlock()
+	if wallet == "" {
+		crylog.Warn("Changing donation percentage without a wallet specified; the pool will ignore it.")
+	}
+	crylog.Info("Changing donation percentage to:", percent)
+	return UpdateConfig(setConfigOption(config, "donate", strconv.FormatFloat(percent, 'f', -1, 64)))
+}
+
+// UpdateConfig replaces the advanced config string of the current login by logging in again with
+// otherwise the same login args. Unlike a logout followed by a fresh PoolLogin, session stats such
+// as share and hash counts and hashrate since inception carry over; only the recent hashrate window
+// restarts as it would after any reconnect. Returns a response with the same semantics as
+// PoolLogin.
+func UpdateConfig(config string) *PoolLoginResponse {
+	configMutex.Lock()
+	if plArgs == nil {
+		configMutex.Unlock()
+		return &PoolLoginResponse{Code: 2, Message: "not logged in"}
+	}
+	args := *plArgs
+	configMutex.Unlock()
+	if _, err := validateAdvancedConfig(config); err != nil {
+		// check before logging in again so a bad config doesn't drop the current login
+		return &PoolLoginResponse{Code: 2, Message: "Invalid config: " + err.Error()}
+	}
+	args.Config = config
+	crylog.Info("Logging in again with updated config:", config)
+	return PoolLogin(&args)
 }
 
 type InitMinerArgs struct {
@@ -271,6 +613,143 @@ type InitMinerArgs struct {
 	// begin/end hours (24 time) of the time during the day where mining should be paused. Set both
 	// to 0 if there is no excluded range.
 	ExcludeHourStart, ExcludeHourEnd int
+
+	// SubmitOnlyWhenMining: if true, shares found are abandoned rather than submitted if mining has
+	// since been paused or the job they were found for has been replaced.
+	SubmitOnlyWhenMining bool
+
+	// QuietShares: if true, the line normally logged for every share found is omitted, which on
+	// fast machines mining at low difficulty would otherwise dominate the log.
+	QuietShares bool
+
+	// PauseGracePeriod: if positive, mining continues for this long after the screen becomes active
+	// before pausing, and doesn't pause at all if the screen goes idle again in the meantime. This
+	// avoids stopping and restarting mining over brief interactions with the machine.
+	PauseGracePeriod time.Duration
+
+	// WarmStandby: if true, a second idle pool connection is maintained and promoted immediately
+	// should the primary connection drop, avoiding the full reconnect delay.
+	WarmStandby bool
+
+	// SeparateSubmitConnection: if true, shares are submitted over a second pool connection so
+	// that submissions don't contend with reading jobs, falling back to the primary connection
+	// whenever the second can't be established.
+	SeparateSubmitConnection bool
+
+	// Multiclient: if true, shares are submitted in the multiclient form used by profit-maximizing
+	// services, which names the user and rig the share is for along with the difficulty of its job
+	// (the for_user, for_rig & for_difficulty submit fields), rather than crediting it to the login
+	// of the connection. Since multiclient submits can't carry chats, shares found while chats are
+	// waiting to be sent are submitted in the standard form, and new chats are only received by
+	// polling. Share sinks not implementing MulticlientShareSink always receive standard submits.
+	Multiclient bool
+
+	// MaxRejectedBeforeReconnect: if positive, the miner forces a reconnect after this many
+	// consecutive rejected shares, and pauses mining with MINING_PAUSED_TOO_MANY_REJECTS should
+	// rejects persist after MAX_REJECT_RECONNECTS such reconnects.
+	MaxRejectedBeforeReconnect int
+
+	// EventLogPath: if non-empty, a JSON record for every share result and activity state change
+	// is appended to the file at this path.
+	EventLogPath string
+
+	// NoJobTimeout: if positive, the pool connection is closed and reestablished whenever no new job
+	// has been received over it for this long, recovering from half-open connections.
+	NoJobTimeout time.Duration
+
+	// HashStallTimeout: if positive, the worker threads are restarted whenever mining is active
+	// but no hashes have been computed for this long.
+	HashStallTimeout time.Duration
+
+	// WorkerRefreshInterval: how often the worker threads tally their hash counts while mining,
+	// which they do without stopping. Longer intervals update the reported hashrate less often.
+	// Defaults to DEFAULT_WORKER_REFRESH_INTERVAL if 0, and must be at most half of
+	// HashStallTimeout if that is positive.
+	WorkerRefreshInterval time.Duration
+
+	// MinRecentHashrateWindow: minimum duration of mining required before a recent hashrate is
+	// reported. Defaults to stats.DEFAULT_MIN_RECENT_WINDOW if 0.
+	MinRecentHashrateWindow time.Duration
+
+	// ProvisionalHashrate: if true, a low confidence recent hashrate is reported (with
+	// RecentHashrateProvisional set) before MinRecentHashrateWindow has elapsed.
+	ProvisionalHashrate bool
+
+	// SelfTest: if true, verify RandomX computes the correct hash for a known test vector during
+	// init. This requires building an extra RandomX dataset so slows startup. InitMiner fails with
+	// code 3 if rxlib doesn't support rx.HashOnce.
+	SelfTest bool
+
+	// Intensity: if between 1 and 99, each thread alternately hashes and sleeps to mine at roughly
+	// this percentage of full utilization, e.g. to reduce heat and fan noise. 0 or 100 means full
+	// intensity.
+	Intensity int
+
+	// PoolHashrateSmoothing: weight (0.0-1.0] given to each new pool hashrate sample in the moving
+	// average used to estimate time to next reward. 1.0 disables smoothing. Defaults to
+	// stats.DEFAULT_POOL_HASHRATE_SMOOTHING if 0.
+	PoolHashrateSmoothing float64
+
+	// PoolStatsRefreshInterval: minimum time between the pool stats refreshes triggered by accepted
+	// shares, regardless of share rate. Defaults to DEFAULT_POOL_STATS_REFRESH_INTERVAL if 0. A
+	// negative value disables them, leaving pool stats to be updated only at login and along with
+	// chats and share results.
+	PoolStatsRefreshInterval time.Duration
+
+	// MaxTemperature: if positive, mining pauses whenever the CPU temperature reported via
+	// ReportTemperature reaches this many degrees C, then ramps back up gradually once it has cooled
+	// to ResumeTemperature, which defaults to DEFAULT_THERMAL_HYSTERESIS degrees below the max if 0.
+	// ThermalStabilizePeriod is how long the temperature is given to settle after each thread is
+	// added back, and defaults to DEFAULT_THERMAL_STABILIZE_PERIOD if 0. See ReportTemperature.
+	MaxTemperature, ResumeTemperature float64
+	ThermalStabilizePeriod            time.Duration
+
+	// MinShareDifficulty: if positive, shares are only submitted if they meet at least this
+	// difficulty, even if the job's difficulty is lower. Hashes computed are still counted. Note
+	// the pool credits each share with the job difficulty only, so this reduces the work the pool
+	// credits unless it's also asked for a higher difficulty, e.g. via the start_diff config option.
+	MinShareDifficulty int64
+
+	// BlockFoundCallback: if non-nil, called in its own goroutine whenever a share is found that
+	// also meets the network difficulty of its job, i.e. a block was found.
+	BlockFoundCallback func(jobID string, hashDifficulty, networkDifficulty int64)
+
+	// ShareAcceptedCallback: if non-nil, called in its own goroutine whenever the pool accepts a
+	// share, with the difficulty the pool credited it with.
+	ShareAcceptedCallback func(jobID string, difficulty int64)
+
+	// Proxy: if non-empty, the URL of an http, https, or socks5 proxy through which both pool
+	// connections and pool stats requests are made, e.g. socks5://127.0.0.1:9050.
+	Proxy string
+
+	// BindAddr: if non-empty, the local IP address that pool connections and pool stats requests
+	// originate from, selecting the network interface used on multi-homed machines.
+	BindAddr string
+
+	// Label: if non-empty, a name for this miner instance, e.g. "living-room-pc", included in the
+	// mining state and every event log record so that stats from several instances can be told
+	// apart once aggregated.
+	Label string
+
+	// DoHURL: the DNS over HTTPS endpoint used to resolve the pool hostname when regular DNS
+	// resolution fails, or client.DEFAULT_DOH_URL if empty. It must support the JSON API.
+	DoHURL string
+
+	// ForceDoH: if true, the pool hostname is resolved only via DNS over HTTPS.
+	ForceDoH bool
+
+	// PoolReadTimeout: how long the pool connection may go without receiving anything before it's
+	// considered dead and reconnected. Idle connections are kept alive by requesting chats once
+	// they've been quiet for a third of this long. Defaults to client.DEFAULT_READ_TIMEOUT if 0,
+	// and must be at least client.MIN_READ_TIMEOUT.
+	PoolReadTimeout time.Duration
+
+	// DatasetCheckInterval: if positive, how often to verify the integrity of the RandomX dataset
+	// by rehashing a few fixed inputs while the workers are briefly stopped, and comparing against
+	// their hashes right after seeding. A mismatch, e.g. due to a bit flip on a machine without ECC
+	// memory, triggers a reseed and is counted in the stats. Like SelfTest, requires an rxlib
+	// supporting rx.HashOnce.
+	DatasetCheckInterval time.Duration
 }
 
 type InitMinerResponse struct {
@@ -280,19 +759,38 @@ type InitMinerResponse struct {
 	//            slow. You can suggest to the user that a machine restart might help resolve this.
 	//
 	// code > 2: miner init failed due to bad config, see details in message. For example, an
-	//           invalid number of threads or invalid hour range may have been specified.
+	//           invalid number of threads or invalid hour range may have been specified, or the
+	//           miner may already have been initialized.
 	//
 	// code < 0: non-recoverable error, message will provide details. program should exit after
 	//           showing message.
 	Code    int
 	Message string
+
+	// Details of the initialized miner, set only on success (code 1 or 2). HugePages is true if
+	// RandomX is using huge pages, Threads is the # of mining threads initialized, and RXFlags are
+	// the RandomX flags in effect (see rx.ActiveFlags). They're also available afterward from
+	// GetMiningState.
+	HugePages bool
+	Threads   int
+	RXFlags   string
 }
 
 // InitMiner configures the miner and must be called exactly once before any other method
-// is called.
+// is called. Calls after a successful one fail with code 3 and leave the miner untouched, since
+// reinitializing RandomX and the stats could corrupt a running mining loop. Calls that fail due to
+// bad config (code 3) may be retried with corrected args.
 func InitMiner(args *InitMinerArgs) *InitMinerResponse {
-	pokeChannel = make(chan int, 5) // use small amount of buffering for when internet may be bad
+	initMutex.Lock()
+	defer initMutex.Unlock()
 	r := &InitMinerResponse{}
+	if initialized {
+		crylog.Error("InitMiner called more than once")
+		r.Code = 3
+		r.Message = "miner is already initialized"
+		return r
+	}
+	setPokeChannel(make(chan int, 5)) // use small amount of buffering for when internet may be bad
 	hr1 := args.ExcludeHourStart
 	hr2 := args.ExcludeHourEnd
 	if hr1 > 24 || hr1 < 0 || hr2 > 24 || hr2 < 0 {
@@ -300,9 +798,109 @@ func InitMiner(args *InitMinerArgs) *InitMinerResponse {
 		r.Message = "exclude_hour_start and exclude_hour_end must each be between 0 and 24"
 		return r
 	}
+	if args.Intensity < 0 || args.Intensity > 100 {
+		r.Code = 3
+		r.Message = "intensity must be between 0 and 100"
+		return r
+	}
+	if args.MinShareDifficulty < 0 {
+		r.Code = 3
+		r.Message = "minimum share difficulty must not be negative"
+		return r
+	}
+	refreshInterval, err := checkWorkerRefreshInterval(args.WorkerRefreshInterval, args.HashStallTimeout)
+	if err != nil {
+		r.Code = 3
+		r.Message = err.Error()
+		return r
+	}
+	resumeTemp, stabilize, err := checkThermalConfig(args.MaxTemperature, args.ResumeTemperature, args.ThermalStabilizePeriod)
+	if err != nil {
+		r.Code = 3
+		r.Message = err.Error()
+		return r
+	}
+	if err := stats.SetPoolHashrateSmoothing(args.PoolHashrateSmoothing); err != nil {
+		r.Code = 3
+		r.Message = err.Error()
+		return r
+	}
+	proxyURL, err := client.ParseProxy(args.Proxy)
+	if err != nil {
+		r.Code = 3
+		r.Message = "invalid proxy: " + err.Error()
+		return r
+	}
+	client.SetProxy(proxyURL)
+	stats.SetProxy(proxyURL)
+	var bindIP net.IP
+	if args.BindAddr != "" {
+		if bindIP = net.ParseIP(args.BindAddr); bindIP == nil {
+			r.Code = 3
+			r.Message = "invalid bind address, must be an IP address: " + args.BindAddr
+			return r
+		}
+	}
+	client.SetLocalAddr(bindIP)
+	stats.SetLocalAddr(bindIP)
+	if err := client.SetDoH(args.DoHURL, args.ForceDoH); err != nil {
+		r.Code = 3
+		r.Message = err.Error()
+		return r
+	}
+	if err := client.SetReadTimeout(args.PoolReadTimeout); err != nil {
+		r.Code = 3
+		r.Message = err.Error()
+		return r
+	}
+	eventlog.SetLabel(args.Label)
+	if args.EventLogPath != "" {
+		if err := eventlog.Open(args.EventLogPath); err != nil {
+			r.Code = 3
+			r.Message = "could not open event log: " + err.Error()
+			return r
+		}
+	}
 	excludeHourStart = hr1
 	excludeHourEnd = hr2
+	label = args.Label
+	submitOnlyWhenMining = args.SubmitOnlyWhenMining
+	quietShares = args.QuietShares
+	pauseGracePeriod = args.PauseGracePeriod
+	warmStandby = args.WarmStandby
+	separateSubmitConn = args.SeparateSubmitConnection
+	multiclient = args.Multiclient
+	datasetCheckInterval = args.DatasetCheckInterval
+	noJobTimeout = args.NoJobTimeout
+	hashStallTimeout = args.HashStallTimeout
+	workerRefreshInterval = refreshInterval
+	intensity = args.Intensity
+	if intensity == 0 {
+		intensity = 100
+	}
+	maxRejectedBeforeReconnect = args.MaxRejectedBeforeReconnect
+	minShareDiff = args.MinShareDifficulty
+	blockFoundCallback = args.BlockFoundCallback
+	shareAcceptedCallback = args.ShareAcceptedCallback
+	poolStatsRefreshInterval = args.PoolStatsRefreshInterval
+	if poolStatsRefreshInterval == 0 {
+		poolStatsRefreshInterval = DEFAULT_POOL_STATS_REFRESH_INTERVAL
+	}
+	maxTemperature = args.MaxTemperature
+	resumeTemperature = resumeTemp
+	thermalStabilizePeriod = stabilize
 
+	if err := rx.CheckLibrary(); err != nil {
+		crylog.Error(err)
+		r.Code = -5
+		r.Message = err.Error()
+		return r
+	}
+	if (args.SelfTest || args.DatasetCheckInterval > 0) && !hashOnceSupported() {
+		r.Code = 3
+		r.Message = "the RandomX self test and dataset integrity checks require an rxlib that can hash arbitrary input"
+		return r
+	}
 	code := rx.InitRX(args.Threads)
 	if code < 0 {
 		crylog.Error("Failed to initialize RandomX")
@@ -310,18 +908,146 @@ func InitMiner(args *InitMinerArgs) *InitMinerResponse {
 		r.Message = "Failed to initialize RandomX"
 		return r
 	}
+	if args.SelfTest {
+		crylog.Info("Running RandomX self test")
+		if err := rx.SelfTest(); err != nil {
+			crylog.Error("RandomX self test failed:", err)
+			r.Code = -4
+			r.Message = "RandomX self test failed: " + err.Error()
+			return r
+		}
+		crylog.Info("RandomX self test passed")
+	}
 	if code == 2 {
 		r.Code = 2
+		go monitorHugePages()
 	} else {
 		r.Code = 1
 	}
 	stats.Init()
+	stats.SetRecentHashrateConfig(args.MinRecentHashrateWindow, args.ProvisionalHashrate)
 	threads = args.Threads
+	configuredThreads = args.Threads
+	hugePages = code != 2
+	rxFlags = rx.ActiveFlags()
+	crylog.Info("RandomX flags:", rxFlags)
 	crylog.Info("minerlib initialized")
+	initialized = true
+	r.HugePages = hugePages
+	r.Threads = threads
+	r.RXFlags = rxFlags
 	return r
 
 }
 
+// monitorHugePages periodically checks whether enough huge pages have become available since
+// RandomX was initialized without them, in which case it logs that a restart is required to use
+// them and flags this in the mining state. rxlib can't reallocate the dataset in place, so the
+// process must be restarted.
+func monitorHugePages() {
+	for {
+		time.Sleep(HUGE_PAGES_RECHECK_INTERVAL)
+		free, err := rx.HugePagesFree()
+		if err != nil {
+			crylog.Info("Not monitoring huge pages availability:", err)
+			return
+		}
+		if free >= rx.LARGE_PAGES_REQUIRED {
+			crylog.Warn("Huge pages are now available. Restart the miner to use them for a higher hashrate.")
+			configMutex.Lock()
+			hugePagesRestartRecommended = true
+			configMutex.Unlock()
+			return
+		}
+	}
+}
+
+// checkWorkerRefreshInterval returns the worker refresh interval to use given the configured one
+// and the hash stall timeout, or an error if the combination is invalid.
+func checkWorkerRefreshInterval(interval, stallTimeout time.Duration) (time.Duration, error) {
+	if interval < 0 {
+		return 0, errors.New("worker refresh interval must not be negative")
+	}
+	if interval == 0 {
+		interval = DEFAULT_WORKER_REFRESH_INTERVAL
+	}
+	if stallTimeout > 0 && stallTimeout < 2*interval {
+		return 0, fmt.Errorf("hash stall timeout (%v) must be at least twice the worker refresh interval (%v)", stallTimeout, interval)
+	}
+	return interval, nil
+}
+
+// monitorHashing watches for the client side hash count flatlining while mining is supposed to be
+// active, e.g. because worker threads exited early, and pokes the mining loop to restart the
+// workers whenever it does so for longer than hashStallTimeout. Returns once exit is closed.
+func monitorHashing(exit <-chan struct{}) {
+	lastHashes := int64(-1)
+	lastProgress := nowFunc()
+	for {
+		select {
+		case <-exit:
+			return
+		case <-time.After(HASH_MONITOR_INTERVAL):
+		}
+		configMutex.Lock()
+		haveJob := currentJobID != ""
+		configMutex.Unlock()
+		if !haveJob || getMiningActivityState() < 0 {
+			lastHashes = -1
+			continue
+		}
+		s, _, _ := stats.GetSnapshot(true)
+		if s.ClientSideHashes != lastHashes {
+			lastHashes = s.ClientSideHashes
+			lastProgress = nowFunc()
+			continue
+		}
+		if nowFunc().Sub(lastProgress) < hashStallTimeout {
+			continue
+		}
+		crylog.Warn("No hashes computed in", hashStallTimeout, "while mining is active; restarting workers")
+		lastProgress = nowFunc()
+		go pokeJobDispatcher(RESTART_WORKERS_POKE)
+	}
+}
+
+// keepAlive requests chats whenever a pool connection has been idle for a third of the read
+// timeout, so that a healthy connection isn't closed for lack of traffic while a dead one is still
+// noticed within the timeout. Besides the primary connection this covers the submit and warm
+// standby connections, which are subject to the same timeout. Returns once exit is closed.
+func keepAlive(exit <-chan struct{}) {
+	interval := client.ReadTimeout() / 3
+	for {
+		select {
+		case <-exit:
+			return
+		case <-time.After(interval / 2):
+		}
+		if cl.IsAlive() && cl.IdleTime() >= interval {
+			GetChats()
+		}
+		pingIdle(&submitCl, interval)
+		configMutex.Lock()
+		sc := standbyCl
+		configMutex.Unlock()
+		if sc != nil {
+			pingIdle(sc, interval)
+		}
+	}
+}
+
+// pingIdle requests chats over a secondary pool connection if it has been idle for interval. The
+// result is discarded without advancing the chat token, leaving the chats to be delivered by
+// GetChats over the primary connection.
+func pingIdle(c *client.Client, interval time.Duration) {
+	if !c.IsAlive() || c.IdleTime() < interval {
+		return
+	}
+	if _, err := c.GetChats(chat.NextToken(), false); err != nil {
+		crylog.Warn("Keepalive request failed:", err)
+	}
+}
+
 // Returns nil if connection could not be established, in which case caller should make sure mining
 // loop isn't supposed to terminate, and otherwise try again after a brief sleep. On success, returns
 // a new job channel on which to continue listening for jobs.
@@ -334,17 +1060,15 @@ func reconnectClient() <-chan *client.MultiClientJob {
 		err = errors.New("plArgs was nil")
 		return nil
 	}
-	loginName := plArgs.Username
-	if plArgs.Wallet != "" {
-		loginName = plArgs.Wallet + "." + plArgs.Username
-	}
+	loginName := getLoginName(plArgs)
 	crylog.Info("Attempting to reconnect...")
 	dest := getServerHostPort(plArgs.UseTLS, plArgs.Dev)
-	err, code, message, jc := cl.Connect(dest, plArgs.UseTLS, plArgs.Agent, loginName, plArgs.Config, plArgs.RigID)
+	err, code, message, jc := cl.Connect(dest, plArgs.UseTLS, plArgs.Agent, loginName, reconnectConfig(plArgs.Config), plArgs.RigID)
 	if err == nil {
 		if code != 0 {
 			crylog.Warn("Pool server returned login warning:", message)
 		}
+		stats.Connected(cl.ConnectTimings(), cl.TLSInfo())
 		return jc
 	}
 	crylog.Error("Connect to pool server failed:", err)
@@ -354,9 +1078,132 @@ func reconnectClient() <-chan *client.MultiClientJob {
 	return nil
 }
 
+// reconnectConfig returns the advanced config to use when reconnecting, which requests the
+// difficulty of the most recent job as the starting difficulty so that vardiff doesn't have to
+// converge all over again. configMutex must be held.
+func reconnectConfig(config string) string {
+	if lastDifficulty <= 0 {
+		return config
+	}
+	return setConfigOption(config, "start_diff", strconv.FormatInt(lastDifficulty, 10))
+}
+
+func getLoginName(args *PoolLoginArgs) string {
+	if args.Wallet != "" {
+		return args.Wallet + "." + args.Username
+	}
+	return args.Username
+}
+
+// FetchJob logs into the pool over a temporary connection and returns the first job it sends,
+// without affecting any current login. InitMiner need not be called first. If the pool refused the
+// login, the code it responded with is returned along with the error.
+func FetchJob(args *PoolLoginArgs) (job *client.MultiClientJob, code int, err error) {
+	fetchCl := &client.Client{}
+	defer fetchCl.Close()
+	dest := getServerHostPort(args.UseTLS, args.Dev)
+	err, code, message, jc := fetchCl.Connect(dest, args.UseTLS, args.Agent, getLoginName(args), args.Config, args.RigID)
+	if err != nil {
+		if code != 0 {
+			return nil, code, errors.New(message)
+		}
+		return nil, 0, err
+	} else if code != 0 {
+		crylog.Warn("Pool login warning:", message)
+	}
+	job = <-jc // delivered along with the login response so never blocks
+	if job == nil {
+		return nil, 0, errors.New("pool connection closed immediately after login")
+	}
+	return job, 0, nil
+}
+
+// connectStandby attempts to establish the warm standby connection, delivering its job channel to
+// ready on success, or nil on failure after a brief backoff. Gives up without delivering anything if
+// exit is closed first.
+func connectStandby(ready chan<- (<-chan *client.MultiClientJob), exit <-chan struct{}) {
+	configMutex.Lock()
+	if plArgs == nil {
+		configMutex.Unlock()
+		return
+	}
+	args := *plArgs
+	args.Config = reconnectConfig(args.Config)
+	configMutex.Unlock()
+
+	dest := getServerHostPort(args.UseTLS, args.Dev)
+	sc := &client.Client{}
+	err, _, _, jc := sc.Connect(dest, args.UseTLS, args.Agent, getLoginName(&args), args.Config, args.RigID)
+	if err != nil {
+		crylog.Warn("Warm standby connection failed:", err)
+		jc = nil
+		select {
+		case <-time.After(30 * time.Second):
+		case <-exit:
+			return
+		}
+	} else {
+		configMutex.Lock()
+		select {
+		case <-exit:
+			// the mining loop has already closed any standby connection
+			configMutex.Unlock()
+			sc.Close()
+			return
+		default:
+		}
+		standbyCl = sc
+		configMutex.Unlock()
+	}
+	select {
+	case ready <- jc:
+	case <-exit:
+		closeStandby()
+	}
+}
+
+// takeStandby returns the warm standby client, or nil if there is none, leaving none in its place.
+func takeStandby() *client.Client {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	sc := standbyCl
+	standbyCl = nil
+	return sc
+}
+
+// closeStandby closes the warm standby connection, if any.
+func closeStandby() {
+	if sc := takeStandby(); sc != nil {
+		sc.Close()
+	}
+}
+
 // Called by PoolLogin after succesful login.
 func MiningLoop(jobChan <-chan *client.MultiClientJob, done chan<- bool) {
-	defer func() { done <- true }()
+	miningLoop(jobChan, nil, done)
+}
+
+// miningLoop implements MiningLoop, first working on firstJob if it's non-nil.
+func miningLoop(jobChan <-chan *client.MultiClientJob, firstJob *client.MultiClientJob, done chan<- bool) {
+	pokeChannel := getPokeChannel()
+	standbyExit := make(chan struct{})
+	loopExit := make(chan struct{})
+	if hashStallTimeout > 0 {
+		go monitorHashing(loopExit)
+	}
+	go keepAlive(loopExit)
+	if firstJob != nil {
+		jobChan = prependJob(firstJob, jobChan, loopExit)
+	}
+	defer func() {
+		close(loopExit)
+		close(standbyExit)
+		closeStandby()
+		submitCl.Close()
+		done <- true
+	}()
+	// a new job connection, so that requeued shares waiting on a reissue of their job give up
+	defer func() { setCurrentJob(nil, nextJobConn()) }()
 
 	// Set up fresh stats ....
 	stopWorkers()
@@ -365,9 +1212,50 @@ func MiningLoop(jobChan <-chan *client.MultiClientJob, done chan<- bool) {
 	lastActivityState := -999
 	var job *client.MultiClientJob
 	sleepSec := 3 * time.Second // time to sleep if connection attempt fails
+
+	// warm standby connection state
+	standbyReady := make(chan (<-chan *client.MultiClientJob))
+	var standbyChan <-chan *client.MultiClientJob
+	var standbyJob *client.MultiClientJob // most recent job received over the standby connection
+	standbyConnecting := false
+	lastJobTime := nowFunc()
+	var lastConnNonce uint32 // ConnNonce of the previous job received over the current connection
+	connNonceKnown := false
+	jobConn := nextJobConn() // identifies the connection jobs are currently received over
+	badJobID := ""           // ID of the most recently skipped job
+	source := getJobSource()
+	_, fromPool := source.(poolJobSource)
 	for {
-		select {
-		case poke := <-pokeChannel:
+		if noJobTimeout > 0 && nowFunc().Sub(lastJobTime) > noJobTimeout && source.IsAlive() {
+			// The connection appears alive but has gone silent. Closing it will trigger a reconnect
+			// once the job channel closes.
+			crylog.Warn("No new job received in", noJobTimeout, "-- closing connection to reconnect")
+			lastJobTime = nowFunc()
+			source.Close()
+		}
+		if warmStandby && fromPool && standbyChan == nil && !standbyConnecting {
+			standbyConnecting = true
+			go connectStandby(standbyReady, standbyExit)
+		}
+
+		select {
+		case standbyChan = <-standbyReady:
+			standbyConnecting = false
+			if standbyChan != nil {
+				crylog.Info("Warm standby connection established")
+			}
+			continue
+
+		case sj := <-standbyChan:
+			if sj == nil {
+				crylog.Warn("Warm standby connection closed")
+				closeStandby()
+				standbyChan = nil
+			}
+			standbyJob = sj
+			continue
+
+		case poke := <-pokeChannel:
 			if poke == EXIT_LOOP_POKE {
 				crylog.Info("Stopping mining loop")
 				stopWorkers()
@@ -380,11 +1268,28 @@ func MiningLoop(jobChan <-chan *client.MultiClientJob, done chan<- bool) {
 			}
 
 		case job = <-jobChan:
+			job = latestJob(job, jobChan)
+			lastJobTime = nowFunc()
+			if job == nil && standbyJob != nil {
+				crylog.Info("stratum client closed, promoting warm standby connection")
+				cl.TakeOver(takeStandby())
+				stats.Connected(cl.ConnectTimings(), cl.TLSInfo())
+				jobChan = standbyChan
+				job = standbyJob
+				standbyChan = nil
+				standbyJob = nil
+				connNonceKnown = false
+				jobConn = nextJobConn()
+				stopWorkers()
+				stats.ResetRecent()
+				sleepSec = 3 * time.Second
+			}
 			if job == nil {
-				crylog.Info("stratum client closed, reconnecting...")
-				cl.Close()
-				newChan := reconnectClient()
-				if newChan == nil {
+				crylog.Info("job source closed, reconnecting...")
+				source.Close()
+				newChan, err := source.Connect()
+				if err != nil {
+					crylog.Error("Reconnect failed:", err)
 					stopWorkers() // stop hashing if we're unable to reconnect since we can't submit shares
 					crylog.Info("reconnect failed. sleeping for", sleepSec, "seconds before trying again")
 					time.Sleep(sleepSec)
@@ -392,14 +1297,40 @@ func MiningLoop(jobChan <-chan *client.MultiClientJob, done chan<- bool) {
 					continue
 				}
 				// Set up fresh stats for new connection
+				connNonceKnown = false
+				jobConn = nextJobConn()
 				stopWorkers()
 				stats.ResetRecent()
 				sleepSec = 3 * time.Second
 				jobChan = newChan
 				continue
 			}
+			if err := checkJob(job); err != nil {
+				if job.JobID != badJobID {
+					// log only once should the pool keep resending the bad job
+					crylog.Error("Skipping job", job.JobID, "with", err)
+					badJobID = job.JobID
+				}
+				stopWorkers()
+				setCurrentJob(nil, jobConn)
+				job = nil
+				continue
+			}
 
-			infoStr := fmt.Sprint("Current job: ", job.JobID, "  Difficulty: ", blockchain.TargetToDifficulty(job.Target))
+			if connNonceKnown && job.ConnNonce != lastConnNonce {
+				// Shares are always submitted with the ConnNonce of the job they were found for, and
+				// workers are restarted below on the new job, so we just need to forget the nonces
+				// submitted under the previous ConnNonce.
+				crylog.Info("Pool changed connection nonce from", lastConnNonce, "to", job.ConnNonce)
+				resetSubmitted()
+			}
+			lastConnNonce = job.ConnNonce
+			connNonceKnown = true
+			stats.JobReceived(job.NetworkDifficulty, job.Reward)
+			diff := blockchain.TargetToDifficulty(job.Target)
+			setLastDifficulty(diff)
+
+			infoStr := fmt.Sprint("Current job: ", job.JobID, "  Difficulty: ", diff)
 			if getMiningActivityState() < 0 {
 				crylog.Info(infoStr, " Mining: PAUSED")
 			} else {
@@ -409,9 +1340,23 @@ func MiningLoop(jobChan <-chan *client.MultiClientJob, done chan<- bool) {
 				go GetChats()
 			}
 
-		case <-time.After(30 * time.Second):
+		case <-time.After(workerRefreshInterval):
 			go GetChats()
-			break
+			if job == nil {
+				continue
+			}
+			// the workers have been hashing undisturbed for the whole interval, so all should still
+			// be running
+			running := int(atomic.LoadInt32(&workers))
+			warnThreadShortfall(running)
+			// The workers are only restarted if the activity state changed without a poke, such as
+			// upon entering an excluded time range, if some of them have exited, or if a dataset
+			// check is due. Otherwise they tally their hashes without stopping.
+			as := getMiningActivityState()
+			if as == lastActivityState && (as < 0 || running == workerThreads()) && !datasetCheckDue(nowFunc()) {
+				requestTally()
+				continue
+			}
 		}
 
 		stopWorkers()
@@ -422,37 +1367,193 @@ func MiningLoop(jobChan <-chan *client.MultiClientJob, done chan<- bool) {
 			crylog.Error("invalid seed hash:", job.SeedHash)
 			continue
 		}
-		if bytes.Compare(newSeed, lastSeed) != 0 {
+		reseed := bytes.Compare(newSeed, lastSeed) != 0
+		if reseed {
 			crylog.Info("New seed:", job.SeedHash)
+		} else if !checkDataset(nowFunc()) {
+			crylog.Warn("Reseeding to rebuild the RandomX dataset")
+			reseed = true
+		}
+		if reseed {
 			rx.SeedRX(newSeed, runtime.GOMAXPROCS(0))
 			lastSeed = newSeed
 			stats.ResetRecent()
+			recordDatasetCheck(nowFunc())
 		}
 
 		as := getMiningActivityState()
 		if as != lastActivityState {
 			crylog.Info("New activity state:", getActivityMessage(as))
+			eventlog.LogActivityState(as, getActivityMessage(as))
 			if (as < 0 && lastActivityState > 0) || (as > 0 && lastActivityState < 0) {
-				stats.ResetRecent()
+				switch {
+				case as < 0 && isSoftPaused():
+					stats.PauseRecent()
+				case as > 0 && stats.RecentPaused():
+					stats.ResumeRecent(SOFT_PAUSE_MAX_DURATION)
+				default:
+					stats.ResetRecent()
+				}
 			}
 			lastActivityState = as
 		}
 		if as < 0 {
+			setCurrentJob(nil, jobConn)
 			continue
 		}
 
+		setCurrentJob(job, jobConn)
 		atomic.StoreUint32(&stopper, 0)
-		for i := 0; i < threads; i++ {
+		n := workerThreads()
+		for i := 0; i < n; i++ {
 			wg.Add(1)
-			go goMine(*job, i /*thread*/)
+			go goMine(*job, jobConn, i /*thread*/)
 		}
 	}
 }
 
+// checkJob returns an error if the job is malformed or otherwise can't be mined by this miner.
+func checkJob(job *client.MultiClientJob) error {
+	blob, err := hex.DecodeString(job.Blob)
+	if err != nil || len(blob) < MIN_BLOB_LENGTH {
+		return errors.New("invalid blob: " + job.Blob)
+	}
+	if blockchain.TargetToDifficulty(job.Target) <= 0 {
+		return errors.New("invalid target: " + job.Target)
+	}
+	if seed, err := hex.DecodeString(job.SeedHash); err != nil || len(seed) != 32 {
+		return errors.New("invalid seed hash: " + job.SeedHash)
+	}
+	if job.Algo != "" && !rx.SupportsAlgo(job.Algo) {
+		return errors.New("unsupported algo: " + job.Algo)
+	}
+	if err := rx.CheckBlockVersion(job.MajorVersion, job.MinorVersion); err != nil {
+		return fmt.Errorf("unsupported block version: %w", err)
+	}
+	return nil
+}
+
+// prependJob returns a job channel delivering job followed by the jobs from jobChan, and which is
+// closed once jobChan is. Forwarding stops early if exit is closed.
+func prependJob(job *client.MultiClientJob, jobChan <-chan *client.MultiClientJob, exit <-chan struct{}) <-chan *client.MultiClientJob {
+	out := make(chan *client.MultiClientJob, client.JOB_QUEUE_SIZE)
+	go func() {
+		defer close(out)
+		for job != nil {
+			select {
+			case out <- job:
+			case <-exit:
+				return
+			}
+			select {
+			case job = <-jobChan:
+			case <-exit:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// latestJob returns the most recent job already waiting on jobChan, or job if there are none, so
+// that jobs pushed faster than they can be processed are coalesced and the expensive reseed is only
+// performed for the newest seed. Returns nil if the job source was lost.
+func latestJob(job *client.MultiClientJob, jobChan <-chan *client.MultiClientJob) *client.MultiClientJob {
+	skipped := 0
+	for job != nil {
+		select {
+		case next := <-jobChan:
+			if next == nil {
+				return nil
+			}
+			job = next
+			skipped++
+		default:
+			if skipped > 0 {
+				crylog.Info("Skipped", skipped, "superseded job(s)")
+			}
+			return job
+		}
+	}
+	return nil
+}
+
+// setCurrentJob records the job the workers are being dispatched to mine, which was received over
+// the job connection jobConn. A nil job indicates there is none.
+func setCurrentJob(job *client.MultiClientJob, jobConn uint64) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	currentJobID, currentJobConnNonce = "", 0
+	if job != nil {
+		currentJobID, currentJobConnNonce = job.JobID, job.ConnNonce
+	}
+	currentJobConn = jobConn
+	close(currentJobChanged)
+	currentJobChanged = make(chan struct{})
+}
+
+// nextJobConn returns a new job connection number, to be used by the mining loop for the jobs
+// received after each (re)connect. Shares are only valid for the connection whose session issued
+// their job.
+func nextJobConn() uint64 {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	jobConnCount++
+	return jobConnCount
+}
+
+// awaitReissue waits for the mining loop to dispatch a job received over a job connection other
+// than jobConn, returning that connection and whether the job is the one with the given ID and
+// ConnNonce, i.e. whether the new connection reissued it. Returns false if no such job arrives
+// within REQUEUE_TIMEOUT.
+func awaitReissue(jobID string, connNonce uint32, jobConn uint64) (uint64, bool) {
+	deadline := nowFunc().Add(REQUEUE_TIMEOUT)
+	for {
+		configMutex.Lock()
+		conn, id, cn, changed := currentJobConn, currentJobID, currentJobConnNonce, currentJobChanged
+		configMutex.Unlock()
+		if conn != jobConn {
+			return conn, id == jobID && cn == connNonce
+		}
+		remaining := deadline.Sub(nowFunc())
+		if remaining <= 0 {
+			return jobConn, false
+		}
+		select {
+		case <-changed:
+		case <-time.After(remaining):
+		}
+	}
+}
+
+func setLastDifficulty(diff int64) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	lastDifficulty = diff
+}
+
+// shareStillRelevant returns false if a share found for the given job should be abandoned instead
+// of submitted because mining has been paused or the job has been replaced since it was found.
+// Always returns true unless submitOnlyWhenMining is set.
+func shareStillRelevant(jobID string) bool {
+	configMutex.Lock()
+	if !submitOnlyWhenMining {
+		configMutex.Unlock()
+		return true
+	}
+	stale := jobID != currentJobID
+	configMutex.Unlock()
+	if stale {
+		return false
+	}
+	return getMiningActivityState() > 0
+}
+
 // Stop all active worker threads and wait for them to finish before returning. Should
 // only be called by the MiningLoop.
 func stopWorkers() {
 	atomic.StoreUint32(&stopper, 1)
+	interruptWorkers()
 	wg.Wait()
 	stats.RecentStatsNowAccurate()
 }
@@ -462,40 +1563,40 @@ func handlePoke(poke int) {
 	case INCREASE_THREADS_POKE:
 		stopWorkers()
 		configMutex.Lock()
-		t := rx.AddThread()
-		if t < 0 {
-			configMutex.Unlock()
-			crylog.Error("Failed to add another thread")
-			return
-		}
-		threads = t
+		addThread()
 		configMutex.Unlock()
-		crylog.Info("Increased # of threads to:", t)
 		stats.ResetRecent()
 		return
 
 	case DECREASE_THREADS_POKE:
 		stopWorkers()
 		configMutex.Lock()
-		t := rx.RemoveThread()
-		if t < 0 {
-			configMutex.Unlock()
-			crylog.Error("Failed to decrease threads")
-			return
-		}
-		threads = t
+		removeThread()
 		configMutex.Unlock()
-		crylog.Info("Decreased # of threads to:", t)
 		stats.ResetRecent()
 		return
 
 	case STATE_CHANGE_POKE:
 		stopWorkers()
-		stats.ResetRecent()
+		if !isSoftPaused() && !stats.RecentPaused() {
+			// soft pause transitions are instead handled by MiningLoop
+			stats.ResetRecent()
+		}
 		return
 
 	case UPDATE_STATS_POKE:
 		return
+
+	case RESET_STATS_POKE:
+		stopWorkers()
+		stats.ResetAll()
+		crylog.Info("Session stats reset")
+		return
+
+	case RESTART_WORKERS_POKE:
+		// workers will be respawned by the mining loop
+		stopWorkers()
+		return
 	}
 	crylog.Error("Unexpected poke:", poke)
 }
@@ -503,8 +1604,31 @@ func handlePoke(poke int) {
 type GetMiningStateResponse struct {
 	stats.Snapshot
 	MiningActivity int
-	Threads        int
+	Threads        int // # of threads initialized for mining
 	ChatsAvailable bool
+	BatteryPercent int    // battery charge level (0-100), or -1 if unknown
+	RXFlags        string // RandomX flags in effect, see rx.ActiveFlags
+	Intensity      int    // approximate % of full utilization each thread mines at
+	IsDonating     bool   // true if logged in as DONATE_USERNAME
+	Label          string // see InitMinerArgs.Label
+
+	// CurrentDifficulty is the difficulty of the most recent job, which reflects the start_diff
+	// config option as adjusted by the pool's vardiff, or 0 if no job has been received since login.
+	CurrentDifficulty int64
+
+	// ConfiguredThreads is the # of threads requested, which may exceed Threads if some threads
+	// failed to initialize. ActiveThreads is the # of worker threads currently hashing, which is 0
+	// while mining is paused.
+	ConfiguredThreads, ActiveThreads int
+
+	// PausedUntil is when mining resumes if in the MINING_PAUSED_TIMED state, and zero otherwise.
+	PausedUntil time.Time
+
+	// HugePages is true if RandomX was initialized with huge pages. If false,
+	// HugePagesRestartRecommended is true if they weren't available at init but have since become
+	// available; restarting the miner will allow them to be used.
+	HugePages                   bool
+	HugePagesRestartRecommended bool
 }
 
 // poke the job dispatcher to refresh recent stats. result may not be immediate but should happen
@@ -541,9 +1665,53 @@ func GetMiningState() *GetMiningStateResponse {
 		MiningActivity: as,
 		Threads:        threads,
 		ChatsAvailable: chat.HasChats(),
+		BatteryPercent: batteryPercent,
+		RXFlags:        rxFlags,
+		Intensity:      intensity,
+		IsDonating:     plArgs != nil && plArgs.Username == DONATE_USERNAME,
+		Label:          label,
+
+		CurrentDifficulty: lastDifficulty,
+		ConfiguredThreads: configuredThreads,
+		ActiveThreads:     int(atomic.LoadInt32(&workers)),
+
+		PausedUntil: pausedUntil,
+
+		HugePages:                   hugePages,
+		HugePagesRestartRecommended: hugePagesRestartRecommended,
 	}
 }
 
+// StreamStats returns a channel that produces a new mining state snapshot every interval, along
+// with a function that stops the stream and closes the channel. Snapshots are dropped rather than
+// queued if the receiver falls behind. Intervals below MIN_STREAM_STATS_INTERVAL are raised to it.
+func StreamStats(interval time.Duration) (<-chan *GetMiningStateResponse, func()) {
+	if interval < MIN_STREAM_STATS_INTERVAL {
+		interval = MIN_STREAM_STATS_INTERVAL
+	}
+	ch := make(chan *GetMiningStateResponse, 1)
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			select {
+			case ch <- GetMiningState():
+			default:
+				// receiver hasn't consumed the previous snapshot yet
+			}
+		}
+	}()
+	return ch, func() { once.Do(func() { close(done) }) }
+}
+
 func updatePoolStats(isMining bool) {
 	s, _, _ := stats.GetSnapshot(isMining)
 	configMutex.Lock()
@@ -552,49 +1720,157 @@ func updatePoolStats(isMining bool) {
 		return
 	}
 	uname := plArgs.Username
-	if uname != "" && (uname != s.PoolUsername || s.SecondsOld > 5) {
+	if poolStatsRefreshDue(uname, s, nowFunc()) {
+		lastPoolStatsRefresh = nowFunc()
 		go stats.RefreshPoolStats(uname)
 	}
 }
 
-func IncreaseThreads() {
+// poolStatsRefreshDue returns true if pool stats for uname should be refreshed given the current
+// snapshot s. Refreshes are throttled to one per poolStatsRefreshInterval unless the stats are for
+// a different user. configMutex must be held.
+func poolStatsRefreshDue(uname string, s *stats.Snapshot, now time.Time) bool {
+	if uname == "" {
+		return false
+	}
+	if uname != s.PoolUsername {
+		return true
+	}
+	if poolStatsRefreshInterval < 0 || now.Sub(lastPoolStatsRefresh) < poolStatsRefreshInterval {
+		return false
+	}
+	return s.SecondsOld > 5
+}
+
+// ResetStats zeroes out all client side session stats (shares, hashes, hashrate since inception)
+// without affecting pool-side stats.
+func ResetStats() {
 	configMutex.Lock()
 	defer configMutex.Unlock()
 	if plArgs != nil {
-		go pokeJobDispatcher(INCREASE_THREADS_POKE)
+		go pokeJobDispatcher(RESET_STATS_POKE)
 		return
 	}
 	// dispatch loop isn't active so just handle this here
+	stats.ResetAll()
+}
+
+// IncreaseThreads adds a mining thread, returning false if already at the maximum of one thread per
+// CPU.
+func IncreaseThreads() bool {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if configuredThreads >= maxThreads {
+		crylog.Info("Already at the maximum thread count of", maxThreads)
+		return false
+	}
+	if plArgs != nil {
+		go pokeJobDispatcher(INCREASE_THREADS_POKE)
+		return true
+	}
+	// dispatch loop isn't active so just handle this here
+	addThread()
+	return true
+}
+
+// DecreaseThreads removes a mining thread, returning false if already at the minimum of 1 thread.
+func DecreaseThreads() bool {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if configuredThreads <= 1 {
+		crylog.Info("Already at the minimum thread count of 1")
+		return false
+	}
+	if plArgs != nil {
+		go pokeJobDispatcher(DECREASE_THREADS_POKE)
+		return true
+	}
+	// dispatch loop isn't active so just handle this here
+	removeThread()
+	return true
+}
+
+// addThread increases the configured thread count and initializes another rxlib thread, warning if
+// this fails. configMutex must be held and worker threads stopped.
+func addThread() {
+	if configuredThreads >= maxThreads {
+		// may happen if several increases were requested before the mining loop handled them
+		crylog.Info("Already at the maximum thread count of", maxThreads)
+		return
+	}
+	configuredThreads++
 	t := rx.AddThread()
 	if t < 0 {
-		configMutex.Unlock()
 		crylog.Error("Failed to add another thread")
 		return
 	}
 	threads = t
+	crylog.Info("Increased # of threads to:", t)
 }
 
-func DecreaseThreads() {
-	configMutex.Lock()
-	defer configMutex.Unlock()
-	if plArgs != nil {
-		go pokeJobDispatcher(DECREASE_THREADS_POKE)
+// removeThread decreases the configured thread count, removing an rxlib thread only if more are
+// initialized than are now configured. configMutex must be held and worker threads stopped.
+func removeThread() {
+	if configuredThreads <= 1 {
+		// may happen if several decreases were requested before the mining loop handled them
+		crylog.Info("Already at the minimum thread count of 1")
+		return
+	}
+	configuredThreads--
+	if threads <= configuredThreads {
+		crylog.Info("Decreased # of configured threads to:", configuredThreads)
 		return
 	}
-	// dispatch loop isn't active so just handle this here
 	t := rx.RemoveThread()
 	if t < 0 {
-		configMutex.Unlock()
 		crylog.Error("Failed to decrease threads")
 		return
 	}
 	threads = t
+	crylog.Info("Decreased # of threads to:", t)
+}
+
+// warnThreadShortfall logs a warning, once per shortfall, if fewer worker threads than configured
+// are hashing the current job, e.g. because rxlib failed to initialize some of them. running is the
+// number of worker threads actually running. Threads idled by the temperature policy aren't counted
+// as missing. Returns true if there is a shortfall. Only call while the workers are dispatched.
+func warnThreadShortfall(running int) bool {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	want := configuredThreads
+	if thermalThreads > 0 && thermalThreads < want {
+		want = thermalThreads
+	}
+	if currentJobID == "" || running >= want {
+		threadShortfallWarned = false
+		return false
+	}
+	if !threadShortfallWarned {
+		crylog.Warn("Only", running, "of", want, "worker threads are running")
+		threadShortfallWarned = true
+	}
+	return true
 }
 
 // Poke the job dispatcher. Though it should be unlikely, this method may block if the channel is
 // full, so invoke it in a goroutine if you wish to never block.
 func pokeJobDispatcher(pokeMsg int) {
-	pokeChannel <- pokeMsg
+	getPokeChannel() <- pokeMsg
+}
+
+func getPokeChannel() chan int {
+	pokeMutex.Lock()
+	defer pokeMutex.Unlock()
+	return pokeChannel
+}
+
+// setPokeChannel replaces the poke channel, returning the previous one.
+func setPokeChannel(c chan int) chan int {
+	pokeMutex.Lock()
+	defer pokeMutex.Unlock()
+	old := pokeChannel
+	pokeChannel = c
+	return old
 }
 
 /*
@@ -657,8 +1933,55 @@ func GetChats() {
 	}
 }
 
-func goMine(job client.MultiClientJob, thread int) {
+// isBlock returns true if a hash of the given difficulty meets the network difficulty, which is 0
+// if the job didn't specify it.
+func isBlock(hashDiff, networkDiff int64) bool {
+	return networkDiff > 0 && hashDiff >= networkDiff
+}
+
+// blockFound announces a share found for the job that also met the network difficulty.
+func blockFound(jobID string, hashDiff, networkDiff int64) {
+	crylog.Info("***********************************************************")
+	crylog.Info("*                     BLOCK FOUND!                        *")
+	crylog.Info("***********************************************************")
+	crylog.Info("Job:", jobID, "Hash difficulty:", hashDiff, "Network difficulty:", networkDiff)
+	stats.BlockFound()
+	eventlog.LogBlockFound(jobID, hashDiff)
+	if blockFoundCallback != nil {
+		go blockFoundCallback(jobID, hashDiff, networkDiff)
+	}
+}
+
+// rejectReason categorizes a share rejection from the error in the pool's submit response. Pools
+// word these messages differently so we match on the common keywords.
+func rejectReason(respError *client.RPCError) stats.RejectReason {
+	msg := strings.ToLower(respError.Message)
+	switch {
+	case strings.Contains(msg, "low difficulty") || strings.Contains(msg, "low diff") ||
+		strings.Contains(msg, "above target"):
+		return stats.REJECTED_LOW_DIFFICULTY
+	case strings.Contains(msg, "duplicate"):
+		return stats.REJECTED_DUPLICATE
+	case strings.Contains(msg, "stale") || strings.Contains(msg, "expired") ||
+		strings.Contains(msg, "job not found") || strings.Contains(msg, "invalid job"):
+		return stats.REJECTED_STALE
+	}
+	return stats.REJECTED_UNKNOWN
+}
+
+// shareDifficulty returns the difficulty a hash must meet to be submitted as a share for a job of the
+// given difficulty. Like intensity, minShareDiff is set only at init so is read without locking.
+func shareDifficulty(jobDiff int64) int64 {
+	if minShareDiff > jobDiff {
+		return minShareDiff
+	}
+	return jobDiff
+}
+
+func goMine(job client.MultiClientJob, jobConn uint64, thread int) {
 	defer wg.Done()
+	atomic.AddInt32(&workers, 1)
+	defer atomic.AddInt32(&workers, -1)
 	input, err := hex.DecodeString(job.Blob)
 	diffTarget := blockchain.TargetToDifficulty(job.Target)
 	if err != nil {
@@ -666,75 +1989,249 @@ func goMine(job client.MultiClientJob, thread int) {
 		return
 	}
 
+	hashDiff := shareDifficulty(diffTarget)
+
 	hash := make([]byte, 32)
 	nonce := make([]byte, 4)
+	var own uint32 // stopper through which only this worker is interrupted, see registerWorker
+	duty := newDutyCycle(intensity)
+	defer duty.release()
+	tally := newHashTally()
+	defer func() { tally.flush(nowFunc()) }()
+	w := registerWorker(duty.stopper(&own))
+	defer w.unregister()
+	if atomic.LoadUint32(&stopper) != 0 {
+		return
+	}
 
 	for {
-		res := rx.HashUntil(input, uint64(diffTarget), thread, hash, nonce, &stopper)
+		res := hashUntilFunc(input, uint64(hashDiff), thread, hash, nonce, duty.stopper(&own))
 		if res <= 0 {
-			stats.TallyHashes(-res)
+			tally.add(-res)
+			w.tallied(tally)
+			if duty.pause(&own) {
+				continue
+			}
 			break
 		}
-		stats.TallyHashes(res)
-		crylog.Info("Share found by thread:", thread, "Target:", blockchain.HashDifficulty(hash))
+		tally.add(res)
+		hashDiff := blockchain.HashDifficulty(hash)
+		if !quietShares {
+			crylog.Info("Share found by thread:", thread, "Target:", hashDiff)
+		}
+		stats.HashFound(hashDiff)
+		if isBlock(hashDiff, job.NetworkDifficulty) {
+			blockFound(job.JobID, hashDiff, job.NetworkDifficulty)
+		}
 		fnonce := hex.EncodeToString(nonce)
+		if !markSubmitted(job.JobID, fnonce) {
+			stats.ShareDuplicate()
+			crylog.Warn("Skipping duplicate share for job:", job.JobID, "nonce:", fnonce)
+			logShareEvent(job.JobID, diffTarget, eventlog.SHARE_DUPLICATE)
+			continue
+		}
 		// submit in a separate thread so we can resume hashing immediately.
-		go func(fnonce, jobid string) {
-			// If the client isn't alive, then sleep for a bit and hope it wakes up
-			// before the share goes stale.
-			for i := 0; i < 100; i++ {
-				if cl.IsAlive() {
-					break
-				}
-				time.Sleep(time.Second)
-			}
-			chats := chat.GetChatsToSend(int64(diffTarget))
-			//crylog.Info("sending chatmsgs:", chats)
-			nt := chat.NextToken()
-			// Note there's a rare potential bug here if nt == 0, since a 0 token for this RPC
-			// indicates "don't fetch chats" for backwards compatibility with older clients. Should
-			// this case even occur though, it will be resolved by the chat polling loop anyway.
-			resp, err := cl.SubmitWork(fnonce, jobid, chats, nt)
-			if err != nil {
-				crylog.Warn("Submit work client failure:", jobid, err)
-				cl.Close()
-				return
-			}
-			if resp.Error != nil {
-				stats.ShareRejected()
-				crylog.Warn("Submit work server error:", jobid, resp.Error)
-				return
-			}
-			for i := range chats {
-				chat.ChatSent(chats[i].ID)
-			}
-			stats.ShareAccepted(diffTarget)
-			if resp.Result == nil {
-				crylog.Warn("nil result")
-				cl.Close()
-				return
-			}
-			swr := &client.SubmitWorkResult{}
-			err = json.Unmarshal(*resp.Result, swr)
-			if err != nil {
-				crylog.Warn("Failed to unmarshal SubmitWorkResult:", jobid, err)
-				cl.Close()
-				return
+		go submitShare(fnonce, job.JobID, job.ConnNonce, jobConn, diffTarget)
+	}
+}
+
+// submitShare submits a share found for the job with the given ID and ConnNonce, which was received
+// over the job connection jobConn (see nextJobConn).
+func submitShare(fnonce string, jobid string, jobConnNonce uint32, jobConn uint64, diffTarget int64) {
+	connNonce := client.EncodeConnNonce(jobConnNonce)
+	var sink ShareSink
+	var chats []client.ChatToSend
+	var nt int64
+	var resp *client.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		sink = getShareSink()
+		// If the client isn't alive, then sleep for a bit and hope it wakes up
+		// before the share goes stale.
+		for i := 0; i < 100; i++ {
+			if sink.IsAlive() {
+				break
 			}
-			if swr.PoolMargin > 0.0 {
-				tmp := &swr.StatsResult
-				stats.RefreshPoolStats2(tmp)
-			} else {
-				// This shouldn't ever happen if the server is behaving appropriately.
-				crylog.Warn("Didn't get pool stats in response:", resp.Result)
-				updatePoolStats(true)
+			if !shareStillRelevant(jobid) {
+				break
 			}
-			if swr.ChatsResult != nil {
-				//crylog.Info("Got chats:", swr.ChatsResult)
-				chat.ChatsReceived(swr.ChatsResult, nt)
+			time.Sleep(time.Second)
+		}
+		if !shareStillRelevant(jobid) {
+			stats.ShareAbandoned()
+			if attempt > 1 {
+				stats.ShareRequeueAbandoned()
 			}
-		}(fnonce, job.JobID)
+			crylog.Info("Abandoning share no longer relevant to current mining state:", jobid)
+			logShareEvent(jobid, diffTarget, eventlog.SHARE_ABANDONED)
+			return
+		}
+		chats = chat.GetChatsToSend(int64(diffTarget))
+		//crylog.Info("sending chatmsgs:", chats)
+		nt = chat.NextToken()
+		// Note there's a rare potential bug here if nt == 0, since a 0 token for this RPC
+		// indicates "don't fetch chats" for backwards compatibility with older clients. Should
+		// this case even occur though, it will be resolved by the chat polling loop anyway.
+		submitStart := time.Now()
+		if mc, username, rigid := multiclientSubmitter(sink); mc != nil && len(chats) == 0 {
+			resp, err = mc.SubmitMulticlientWork(username, rigid, fnonce, connNonce, jobid, diffTarget)
+		} else {
+			resp, err = sink.SubmitWork(fnonce, jobid, chats, nt, connNonce)
+		}
+		if err == nil {
+			stats.ShareSubmitted(diffTarget, time.Since(submitStart))
+		}
+		if !errors.Is(err, client.ErrNotAlive) || attempt >= MAX_SHARE_SUBMIT_ATTEMPTS {
+			break
+		}
+		// The connection died after the IsAlive check, so nothing was sent. Since jobs are only
+		// valid for the session that issued them, the share can be submitted over the new
+		// connection only if it reissues the same job.
+		chat.ChatsNotSent(chats)
+		stats.ShareRequeued()
+		crylog.Info("Connection lost before share could be submitted, requeuing:", jobid)
+		var reissued bool
+		if jobConn, reissued = awaitReissue(jobid, jobConnNonce, jobConn); !reissued {
+			stats.ShareAbandoned()
+			stats.ShareRequeueAbandoned()
+			crylog.Info("Abandoning requeued share whose job wasn't reissued after reconnecting:", jobid)
+			logShareEvent(jobid, diffTarget, eventlog.SHARE_ABANDONED)
+			return
+		}
+	}
+	if err != nil {
+		if errors.Is(err, client.ErrNotAlive) {
+			stats.ShareRequeueAbandoned()
+		}
+		crylog.Warn("Submit work client failure:", jobid, err)
+		chat.ChatsNotSent(chats)
+		logShareEvent(jobid, diffTarget, eventlog.SHARE_FAILED)
+		sink.Close()
+		return
+	}
+	if resp.Error != nil {
+		stats.ShareRejected(rejectReason(resp.Error))
+		tripRejectCircuitBreaker()
+		crylog.Warn("Submit work server error:", jobid, resp.Error)
+		chat.ChatsNotSent(chats)
+		logShareEvent(jobid, diffTarget, eventlog.SHARE_REJECTED)
+		return
+	}
+	for i := range chats {
+		chat.ChatSent(chats[i].ID)
+	}
+	stats.ShareAccepted(diffTarget)
+	logShareEvent(jobid, diffTarget, eventlog.SHARE_ACCEPTED)
+	if shareAcceptedCallback != nil {
+		go shareAcceptedCallback(jobid, diffTarget)
+	}
+	configMutex.Lock()
+	resetRejectCircuitBreaker()
+	configMutex.Unlock()
+	if resp.Result == nil {
+		crylog.Warn("nil result")
+		sink.Close()
+		return
+	}
+	swr := &client.SubmitWorkResult{}
+	err = json.Unmarshal(*resp.Result, swr)
+	if err != nil {
+		crylog.Warn("Failed to unmarshal SubmitWorkResult:", jobid, err)
+		sink.Close()
+		return
+	}
+	if swr.PoolMargin > 0.0 {
+		tmp := &swr.StatsResult
+		stats.RefreshPoolStats2(tmp)
+	} else {
+		// This shouldn't ever happen if the server is behaving appropriately.
+		crylog.Warn("Didn't get pool stats in response:", resp.Result)
+		updatePoolStats(true)
+	}
+	if swr.ChatsResult != nil {
+		//crylog.Info("Got chats:", swr.ChatsResult)
+		chat.ChatsReceived(swr.ChatsResult, nt)
+	}
+}
+
+// configMutex should be locked before calling
+func resetRejectCircuitBreaker() {
+	consecutiveRejects = 0
+	rejectReconnects = 0
+	rejectPaused = false
+}
+
+// tripRejectCircuitBreaker should be called on every rejected share. Once the configured number of
+// consecutive rejects is reached it forces a reconnect, or pauses mining if reconnecting hasn't
+// helped.
+func tripRejectCircuitBreaker() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if maxRejectedBeforeReconnect <= 0 {
+		return
+	}
+	consecutiveRejects++
+	if consecutiveRejects < maxRejectedBeforeReconnect {
+		return
 	}
+	consecutiveRejects = 0
+	crylog.Warn(":::::::::::::::::::::::::::::::::::::::::::::::::::::::::")
+	if rejectReconnects < MAX_REJECT_RECONNECTS {
+		rejectReconnects++
+		crylog.Warn("WARNING:", maxRejectedBeforeReconnect, "consecutive shares were rejected.")
+		crylog.Warn("   Forcing reconnect to the pool in an attempt to recover.")
+		crylog.Warn(":::::::::::::::::::::::::::::::::::::::::::::::::::::::::")
+		jobSource.Close()
+		return
+	}
+	crylog.Error("ERROR: shares continue to be rejected after", rejectReconnects, "reconnects.")
+	crylog.Error("   Pausing mining. Override the mining state to resume.")
+	crylog.Warn(":::::::::::::::::::::::::::::::::::::::::::::::::::::::::")
+	rejectPaused = true
+	if plArgs != nil {
+		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+	}
+}
+
+// markSubmitted records that a share with the given nonce is being submitted for the job, returning
+// false if the same nonce was already submitted for it. Only nonces for the most recent job are
+// remembered.
+func markSubmitted(jobid, nonce string) bool {
+	submittedMutex.Lock()
+	defer submittedMutex.Unlock()
+	if jobid != submittedJobID {
+		submittedJobID = jobid
+		submittedNonces = map[string]struct{}{}
+	}
+	if _, ok := submittedNonces[nonce]; ok {
+		return false
+	}
+	submittedNonces[nonce] = struct{}{}
+	return true
+}
+
+func resetSubmitted() {
+	submittedMutex.Lock()
+	defer submittedMutex.Unlock()
+	submittedJobID = ""
+	submittedNonces = nil
+}
+
+// multiclientSubmitter returns sink as a MulticlientShareSink along with the username and rig id of
+// the current login if shares should be submitted in multiclient form, or nil otherwise.
+func multiclientSubmitter(sink ShareSink) (MulticlientShareSink, string, string) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	mc, ok := sink.(MulticlientShareSink)
+	if !multiclient || !ok || plArgs == nil {
+		return nil, "", ""
+	}
+	return mc, plArgs.Username, plArgs.RigID
+}
+
+func logShareEvent(jobid string, diffTarget int64, result string) {
+	s, _, _ := stats.GetSnapshot(true)
+	eventlog.LogShare(jobid, diffTarget, result, s.RecentHashrate)
 }
 
 func OverrideMiningActivityState(mine bool) {
@@ -743,6 +2240,12 @@ func OverrideMiningActivityState(mine bool) {
 	var newState int
 	if mine {
 		newState = OVERRIDE_MINE
+		if rejectPaused {
+			resetRejectCircuitBreaker()
+			if plArgs != nil {
+				go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+			}
+		}
 	} else {
 		newState = OVERRIDE_PAUSE
 	}
@@ -750,12 +2253,34 @@ func OverrideMiningActivityState(mine bool) {
 		return
 	}
 	crylog.Info("Overriding mining state")
-	miningOverride = newState
+	setMiningOverride(newState)
 	if plArgs != nil {
 		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
 	}
 }
 
+// SoftPauseMining pauses mining like OverrideMiningActivityState(false), except the recent hashrate
+// window is preserved should mining resume within SOFT_PAUSE_MAX_DURATION. Call
+// RemoveMiningActivityOverride to resume.
+func SoftPauseMining() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if miningOverride == OVERRIDE_SOFT_PAUSE {
+		return
+	}
+	crylog.Info("Soft pausing mining")
+	setMiningOverride(OVERRIDE_SOFT_PAUSE)
+	if plArgs != nil {
+		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+	}
+}
+
+func isSoftPaused() bool {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return miningOverride == OVERRIDE_SOFT_PAUSE
+}
+
 func RemoveMiningActivityOverride() {
 	configMutex.Lock()
 	defer configMutex.Unlock()
@@ -763,18 +2288,94 @@ func RemoveMiningActivityOverride() {
 		return
 	}
 	crylog.Info("Removing mining override")
-	miningOverride = 0
+	setMiningOverride(0)
 	if plArgs != nil {
 		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
 	}
 }
 
+// PauseUntil pauses mining until t, after which the override is removed automatically and mining
+// resumes subject to the other pause conditions. The miner is in the MINING_PAUSED_TIMED state in
+// the meantime. Any other override, including another PauseUntil, replaces this one. A time that
+// isn't in the future removes the override immediately.
+func PauseUntil(t time.Time) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	d := t.Sub(nowFunc())
+	if d <= 0 {
+		if miningOverride == OVERRIDE_PAUSE_TIMED {
+			crylog.Info("Removing timed pause")
+			setMiningOverride(0)
+		}
+	} else {
+		crylog.Info("Pausing mining until", t.Format("Mon Jan 2 15:04"))
+		setMiningOverride(OVERRIDE_PAUSE_TIMED)
+		pausedUntil = t
+		pauseTimer = time.AfterFunc(d, func() { endTimedPause(t) })
+	}
+	if plArgs != nil {
+		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+	}
+}
+
+// endTimedPause removes the timed pause ending at t if it's still in effect.
+func endTimedPause(t time.Time) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if miningOverride != OVERRIDE_PAUSE_TIMED || !pausedUntil.Equal(t) {
+		return
+	}
+	crylog.Info("Timed pause ended, removing mining override")
+	setMiningOverride(0)
+	if plArgs != nil {
+		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+	}
+}
+
+// setMiningOverride changes the mining override, canceling any timed pause. configMutex must be
+// held.
+func setMiningOverride(o int) {
+	miningOverride = o
+	if pauseTimer != nil {
+		pauseTimer.Stop()
+		pauseTimer = nil
+	}
+	pausedUntil = time.Time{}
+}
+
 func ReportIdleScreenState(isIdle bool) {
 	configMutex.Lock()
 	defer configMutex.Unlock()
-	if screenIdle == isIdle {
+	if isIdle && graceTimer != nil {
+		graceTimer.Stop()
+		graceTimer = nil
+		crylog.Info("Screen idle again within the pause grace period, mining continues")
+		return
+	}
+	if screenIdle == isIdle || graceTimer != nil {
+		return
+	}
+	if !isIdle && pauseGracePeriod > 0 && plArgs != nil {
+		crylog.Info("Screen active, pausing mining in", pauseGracePeriod, "unless it goes idle again")
+		var t *time.Timer
+		t = time.AfterFunc(pauseGracePeriod, func() {
+			configMutex.Lock()
+			defer configMutex.Unlock()
+			if graceTimer != t {
+				return // cancelled
+			}
+			graceTimer = nil
+			setScreenIdle(false)
+		})
+		graceTimer = t
 		return
 	}
+	setScreenIdle(isIdle)
+}
+
+// setScreenIdle records the screen idle state and notifies the mining loop. configMutex must be
+// held.
+func setScreenIdle(isIdle bool) {
 	crylog.Info("Screen idle state changed to:", isIdle)
 	screenIdle = isIdle
 	if plArgs != nil {
@@ -795,15 +2396,45 @@ func ReportPowerState(battery bool) {
 	}
 }
 
+// ReportBlockingProcess is used to tell the miner when one or more processes the user asked to
+// pause mining for are running (true), or when none of them are (false).
+func ReportBlockingProcess(active bool) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if blockingProcess == active {
+		return
+	}
+	crylog.Info("Blocking process state changed to:", active)
+	blockingProcess = active
+	if plArgs != nil {
+		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+	}
+}
+
+// ReportBatteryPercent records the battery charge level (0-100) of the machine, or -1 if it is
+// unknown. This is informational only and does not by itself affect mining activity.
+func ReportBatteryPercent(pct int) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if pct < 0 || pct > 100 {
+		pct = -1
+	}
+	batteryPercent = pct
+}
+
 // configMutex should be locked before calling
 func timeExcluded() bool {
-	currHr := time.Now().Hour()
+	currHr := nowFunc().Hour()
 	startHr := excludeHourStart
 	endHr := excludeHourEnd
 	if startHr < endHr {
 		return currHr >= startHr && currHr < endHr
 	}
-	return currHr < startHr && currHr >= endHr
+	if startHr == endHr {
+		return false // no excluded range
+	}
+	// the excluded range wraps around midnight
+	return currHr >= startHr || currHr < endHr
 }
 
 func getActivityMessage(activityState int) string {
@@ -818,6 +2449,14 @@ func getActivityMessage(activityState int) string {
 		return "PAUSED: user override."
 	case MINING_PAUSED_TIME_EXCLUDED:
 		return "PAUSED: within time of day exclusion."
+	case MINING_PAUSED_TOO_MANY_REJECTS:
+		return "PAUSED: too many rejected shares."
+	case MINING_PAUSED_PROCESS:
+		return "PAUSED: blocking process running."
+	case MINING_PAUSED_TEMPERATURE:
+		return "PAUSED: CPU too hot."
+	case MINING_PAUSED_TIMED:
+		return "PAUSED: until a set time."
 	case MINING_ACTIVE:
 		return "ACTIVE"
 	case MINING_ACTIVE_USER_OVERRIDE:
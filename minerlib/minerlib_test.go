@@ -0,0 +1,765 @@
+package minerlib
+
+import (
+	"github.com/cryptonote-social/csminer/blockchain"
+	"github.com/cryptonote-social/csminer/minerlib/chat"
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+	"github.com/cryptonote-social/csminer/stratum/client"
+	"github.com/cryptonote-social/csminer/stratum/stratumtest"
+
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimeExcluded(t *testing.T) {
+	defer func() {
+		nowFunc = time.Now
+		excludeHourStart, excludeHourEnd = 0, 0
+	}()
+	tests := []struct {
+		start, end, hour int
+		excluded         bool
+	}{
+		{0, 0, 12, false},
+		{11, 16, 10, false},
+		{11, 16, 11, true},
+		{11, 16, 15, true},
+		{11, 16, 16, false},
+		// ranges wrapping around midnight
+		{22, 6, 21, false},
+		{22, 6, 22, true},
+		{22, 6, 0, true},
+		{22, 6, 5, true},
+		{22, 6, 6, false},
+		{22, 6, 12, false},
+	}
+	for _, test := range tests {
+		excludeHourStart, excludeHourEnd = test.start, test.end
+		now := time.Date(2020, 6, 1, test.hour, 30, 0, 0, time.Local)
+		nowFunc = func() time.Time { return now }
+		if e := timeExcluded(); e != test.excluded {
+			t.Errorf("exclude %d-%d at hour %d: expected %v, got %v", test.start, test.end, test.hour, test.excluded, e)
+		}
+	}
+}
+
+func TestShareDifficulty(t *testing.T) {
+	defer func() { minShareDiff = 0 }()
+	if d := shareDifficulty(5000); d != 5000 {
+		t.Errorf("expected job difficulty without a floor, got %v", d)
+	}
+	minShareDiff = 10000
+	if d := shareDifficulty(5000); d != 10000 {
+		t.Errorf("expected floor to apply to lower job difficulty, got %v", d)
+	}
+	if d := shareDifficulty(20000); d != 20000 {
+		t.Errorf("expected higher job difficulty to be kept, got %v", d)
+	}
+}
+
+func TestLatestJob(t *testing.T) {
+	jobs := make(chan *client.MultiClientJob, 3)
+	j1, j2, j3 := &client.MultiClientJob{}, &client.MultiClientJob{}, &client.MultiClientJob{}
+	if j := latestJob(j1, jobs); j != j1 {
+		t.Error("expected job to be returned when none are pending")
+	}
+	jobs <- j2
+	jobs <- j3
+	if j := latestJob(j1, jobs); j != j3 {
+		t.Error("expected most recent pending job")
+	}
+	jobs <- j2
+	close(jobs)
+	if j := latestJob(j1, jobs); j != nil {
+		t.Error("expected nil once the job source is lost")
+	}
+}
+
+func TestPrependJob(t *testing.T) {
+	jc := make(chan *client.MultiClientJob, 1)
+	exit := make(chan struct{})
+	defer close(exit)
+	first := &client.MultiClientJob{}
+	first.JobID = "1"
+	second := &client.MultiClientJob{}
+	second.JobID = "2"
+	jc <- second
+	close(jc)
+
+	out := prependJob(first, jc, exit)
+	for _, want := range []string{"1", "2"} {
+		if job := <-out; job == nil || job.JobID != want {
+			t.Fatalf("expected job %v, got %+v", want, job)
+		}
+	}
+	if job := <-out; job != nil {
+		t.Errorf("expected channel to close after the forwarded jobs, got %+v", job)
+	}
+}
+
+func TestCheckJob(t *testing.T) {
+	job := &client.MultiClientJob{}
+	job.Blob = strings.Repeat("00", MIN_BLOB_LENGTH)
+	job.Target = "b88d0600"
+	job.SeedHash = strings.Repeat("11", 32)
+	if err := checkJob(job); err != nil {
+		t.Fatalf("expected valid job, got %v", err)
+	}
+	bad := *job
+	bad.Blob = "zz"
+	if checkJob(&bad) == nil {
+		t.Error("expected error for non-hex blob")
+	}
+	bad = *job
+	bad.Blob = "00"
+	if checkJob(&bad) == nil {
+		t.Error("expected error for short blob")
+	}
+	bad = *job
+	bad.Target = "00000000"
+	if checkJob(&bad) == nil {
+		t.Error("expected error for zero target")
+	}
+	bad = *job
+	bad.SeedHash = "1111"
+	if checkJob(&bad) == nil {
+		t.Error("expected error for short seed hash")
+	}
+}
+
+func TestIsBlock(t *testing.T) {
+	if isBlock(1000, 0) {
+		t.Error("expected no block when network difficulty is unknown")
+	}
+	if isBlock(999, 1000) {
+		t.Error("expected no block below network difficulty")
+	}
+	if !isBlock(1000, 1000) || !isBlock(5000, 1000) {
+		t.Error("expected block when network difficulty is met")
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	tests := []struct {
+		message string
+		want    stats.RejectReason
+	}{
+		{"Low difficulty share", stats.REJECTED_LOW_DIFFICULTY},
+		{"Block expired", stats.REJECTED_STALE},
+		{"Stale share", stats.REJECTED_STALE},
+		{"Duplicate share", stats.REJECTED_DUPLICATE},
+		{"", stats.REJECTED_UNKNOWN},
+		{"Invalid nonce", stats.REJECTED_UNKNOWN},
+	}
+	for _, tt := range tests {
+		if got := rejectReason(&client.RPCError{Code: -1, Message: tt.message}); got != tt.want {
+			t.Errorf("rejectReason(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	if r := UpdateConfig("start_diff=1000"); r.Code != 2 {
+		t.Errorf("expected config update without a login to fail, got %+v", r)
+	}
+	defer func() { plArgs = nil }()
+	plArgs = &PoolLoginArgs{Username: "user", Config: "start_diff=1000"}
+	if r := UpdateConfig("bogus"); r.Code != 2 {
+		t.Errorf("expected invalid config to be refused, got %+v", r)
+	}
+	if plArgs == nil || plArgs.Config != "start_diff=1000" {
+		t.Errorf("expected invalid config to leave the current login in place, got %+v", plArgs)
+	}
+}
+
+func TestCheckWorkerRefreshInterval(t *testing.T) {
+	if d, err := checkWorkerRefreshInterval(0, 2*time.Minute); err != nil || d != DEFAULT_WORKER_REFRESH_INTERVAL {
+		t.Errorf("expected default interval, got %v, %v", d, err)
+	}
+	if d, err := checkWorkerRefreshInterval(5*time.Minute, 0); err != nil || d != 5*time.Minute {
+		t.Errorf("expected long interval to be allowed without a stall timeout, got %v, %v", d, err)
+	}
+	if _, err := checkWorkerRefreshInterval(2*time.Minute, 3*time.Minute); err == nil {
+		t.Error("expected error for interval over half the stall timeout")
+	}
+	if _, err := checkWorkerRefreshInterval(-time.Second, 0); err == nil {
+		t.Error("expected error for negative interval")
+	}
+}
+
+// testPokeChannel installs a poke channel with enough buffering that the pokes sent by the code
+// under test never block, returning a func that restores the previous one.
+func testPokeChannel() func() {
+	old := setPokeChannel(make(chan int, 100))
+	return func() { setPokeChannel(old) }
+}
+
+func TestPauseGracePeriod(t *testing.T) {
+	defer testPokeChannel()()
+	defer func() {
+		pauseGracePeriod = 0
+		plArgs = nil
+		screenIdle = false
+	}()
+	pauseGracePeriod = 50 * time.Millisecond
+	plArgs = &PoolLoginArgs{Username: "user"}
+	screenIdle = true
+	isIdle := func() bool {
+		configMutex.Lock()
+		defer configMutex.Unlock()
+		return screenIdle
+	}
+
+	ReportIdleScreenState(false)
+	if !isIdle() {
+		t.Error("expected mining to continue during the grace period")
+	}
+	ReportIdleScreenState(true)
+	time.Sleep(2 * pauseGracePeriod)
+	if !isIdle() {
+		t.Error("expected pause to be cancelled when the screen went idle again")
+	}
+
+	ReportIdleScreenState(false)
+	time.Sleep(2 * pauseGracePeriod)
+	if isIdle() {
+		t.Error("expected pause once the grace period elapsed")
+	}
+}
+
+func TestPoolShareSink(t *testing.T) {
+	if sink, ok := PoolShareSink().(*client.Client); !ok || sink != &cl {
+		t.Errorf("expected shares to be submitted over the pool connection, got %T", PoolShareSink())
+	}
+	defer func() { separateSubmitConn = false }()
+	separateSubmitConn = true
+	if _, ok := PoolShareSink().(submitConnSink); !ok {
+		t.Errorf("expected shares to be submitted over the submit connection, got %T", PoolShareSink())
+	}
+}
+
+func TestSubmitConnSink(t *testing.T) {
+	pool, err := stratumtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	testPoolAddr = pool.Addr()
+	configMutex.Lock()
+	plArgs = &PoolLoginArgs{Username: "tester", RigID: "rig"}
+	configMutex.Unlock()
+	defer func() {
+		testPoolAddr = ""
+		configMutex.Lock()
+		plArgs = nil
+		configMutex.Unlock()
+		cl.Close()
+		submitCl.Close()
+	}()
+	if err, _, _, _ := cl.Connect(pool.Addr(), false, "", "tester", "", "rig"); err != nil {
+		t.Fatal(err)
+	}
+
+	// shares are sent over the submit connection, on behalf of the session that issued the job
+	sink := submitConnSink{}
+	if _, err := sink.SubmitWork("00000001", "1", nil, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sink.SubmitMulticlientWork("user", "rig2", "00000002", nil, "1", 5); err != nil {
+		t.Fatal(err)
+	}
+	l := pool.Logins()
+	if len(l) != 2 {
+		t.Fatalf("expected primary and submit logins, got %+v", l)
+	}
+	for _, s := range pool.Submits() {
+		if s.ID != l[0].Session || s.ConnSession != l[1].Session {
+			t.Errorf("expected submit for session %v over session %v's connection, got %+v", l[0].Session, l[1].Session, s)
+		}
+	}
+	if n := len(pool.Submits()); n != 2 {
+		t.Errorf("expected 2 submits, got %v", n)
+	}
+}
+
+func TestWarmStandby(t *testing.T) {
+	defer testPokeChannel()()
+	pool, err := stratumtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	testPoolAddr = pool.Addr()
+	configMutex.Lock()
+	plArgs = &PoolLoginArgs{Username: "tester", RigID: "rig"}
+	configMutex.Unlock()
+	warmStandby = true
+	defer func() {
+		testPoolAddr = ""
+		warmStandby = false
+		configMutex.Lock()
+		plArgs = nil
+		configMutex.Unlock()
+		cl.Close()
+	}()
+	err, _, _, jc := cl.Connect(pool.Addr(), false, "", "tester", "", "rig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool, 1)
+	go miningLoop(jc, nil, done)
+
+	// the standby connection logs in alongside the primary one, and takes over when it drops
+	waitFor(t, "standby login", func() bool { return len(pool.Logins()) == 2 })
+	l := pool.Logins()
+	pool.DropSession(l[0].Session)
+	waitFor(t, "standby promotion", func() bool { return cl.SessionID() == l[1].Session && cl.IsAlive() })
+	if l := pool.Logins(); len(l) > 2 && l[2].Session == cl.SessionID() {
+		t.Errorf("expected the promoted standby connection to be used rather than a new login, got %+v", l)
+	}
+
+	// a new standby connection replaces the promoted one, and is closed once the loop exits
+	waitFor(t, "new standby login", func() bool { return len(pool.Logins()) == 3 })
+	waitFor(t, "new standby connection", func() bool {
+		configMutex.Lock()
+		defer configMutex.Unlock()
+		return standbyCl != nil
+	})
+	sc := standbyCl
+	getPokeChannel() <- EXIT_LOOP_POKE
+	<-done
+	if standbyCl != nil || sc.IsAlive() {
+		t.Error("expected standby connection to be closed when the mining loop exits")
+	}
+}
+
+func TestPingIdle(t *testing.T) {
+	pool, err := stratumtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.AddChat("alice", "hello")
+	c := &client.Client{}
+	if err, _, _, _ := c.Connect(pool.Addr(), false, "", "tester", "", "rig"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	pingIdle(c, time.Hour)
+	if idle := c.IdleTime(); idle < 50*time.Millisecond {
+		t.Errorf("expected no keepalive before the interval elapsed, idle for %v", idle)
+	}
+	token := chat.NextToken()
+	pingIdle(c, 10*time.Millisecond)
+	if idle := c.IdleTime(); idle >= 50*time.Millisecond {
+		t.Errorf("expected keepalive once the interval elapsed, idle for %v", idle)
+	}
+	if nt := chat.NextToken(); nt != token {
+		t.Errorf("expected keepalive to leave chats for the primary connection, token went from %v to %v", token, nt)
+	}
+}
+
+func TestPoolLoginFailureKeepsSession(t *testing.T) {
+	defer testPokeChannel()()
+	stats.Init() // as done by InitMiner
+	pool, err := stratumtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	testPoolAddr = pool.Addr()
+	defer func() { testPoolAddr = "" }()
+	if r := PoolLogin(&PoolLoginArgs{Username: "tester", RigID: "rig"}); r.Code != 1 {
+		t.Fatalf("PoolLogin failed: %+v", r)
+	}
+	defer StopMining()
+	session := cl.SessionID()
+
+	// neither a refused nor an unreachable login disturbs the working one
+	if r := PoolLogin(&PoolLoginArgs{Username: "bad.name"}); r.Code != 2 {
+		t.Errorf("expected login to be refused, got %+v", r)
+	}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPoolAddr = l.Addr().String()
+	l.Close()
+	if r := PoolLogin(&PoolLoginArgs{Username: "other"}); r.Code >= 0 {
+		t.Errorf("expected login to fail to connect, got %+v", r)
+	}
+	configMutex.Lock()
+	username := ""
+	if plArgs != nil {
+		username = plArgs.Username
+	}
+	configMutex.Unlock()
+	if username != "tester" || !cl.IsAlive() || cl.SessionID() != session || miningLoopDoneChan == nil {
+		t.Errorf("expected the existing login to remain active, got user %q alive %v", username, cl.IsAlive())
+	}
+}
+
+func TestThreadLimits(t *testing.T) {
+	defer func() { configuredThreads = 0 }()
+	configuredThreads = 1
+	if DecreaseThreads() || configuredThreads != 1 {
+		t.Errorf("expected decrease to be refused at 1 thread, got %v threads", configuredThreads)
+	}
+	configuredThreads = maxThreads
+	if IncreaseThreads() || configuredThreads != maxThreads {
+		t.Errorf("expected increase to be refused at %v threads, got %v", maxThreads, configuredThreads)
+	}
+}
+
+func TestInitMinerTwice(t *testing.T) {
+	defer func() { initialized = false }()
+	initialized = true
+	pc := make(chan int, 5)
+	defer setPokeChannel(setPokeChannel(pc))
+	if r := InitMiner(&InitMinerArgs{Threads: 1}); r.Code != 3 {
+		t.Errorf("expected repeat InitMiner call to be refused, got %+v", r)
+	}
+	if getPokeChannel() != pc {
+		t.Error("expected repeat InitMiner call to leave the miner untouched")
+	}
+}
+
+// aliveJobSource is a JobSource that is always alive but never delivers jobs.
+type aliveJobSource struct{}
+
+func (aliveJobSource) Connect() (<-chan *client.MultiClientJob, error) { return nil, nil }
+func (aliveJobSource) IsAlive() bool                                   { return true }
+func (aliveJobSource) Close()                                          {}
+
+func TestReportBlockingProcess(t *testing.T) {
+	defer testPokeChannel()()
+	defer func() {
+		plArgs = nil
+		screenIdle = false
+		blockingProcess = false
+		jobSource = poolJobSource{}
+	}()
+	plArgs = &PoolLoginArgs{Username: "user"}
+	screenIdle = true
+	jobSource = aliveJobSource{}
+	ReportBlockingProcess(true)
+	if s := getMiningActivityState(); s != MINING_PAUSED_PROCESS {
+		t.Errorf("expected MINING_PAUSED_PROCESS, got %v", s)
+	}
+	ReportBlockingProcess(false)
+	if s := getMiningActivityState(); s != MINING_ACTIVE {
+		t.Errorf("expected MINING_ACTIVE once the process exits, got %v", s)
+	}
+}
+
+func TestPauseUntil(t *testing.T) {
+	defer testPokeChannel()()
+	defer func() {
+		plArgs = nil
+		screenIdle = false
+		jobSource = poolJobSource{}
+		RemoveMiningActivityOverride()
+	}()
+	plArgs = &PoolLoginArgs{Username: "user"}
+	screenIdle = true
+	jobSource = aliveJobSource{}
+
+	until := time.Now().Add(100 * time.Millisecond)
+	PauseUntil(until)
+	if s := getMiningActivityState(); s != MINING_PAUSED_TIMED {
+		t.Errorf("expected MINING_PAUSED_TIMED, got %v", s)
+	}
+	if u := GetMiningState().PausedUntil; !u.Equal(until) {
+		t.Errorf("expected paused until %v, got %v", until, u)
+	}
+	for deadline := time.Now().Add(2 * time.Second); getMiningActivityState() != MINING_ACTIVE; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected timed pause to end")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if u := GetMiningState().PausedUntil; !u.IsZero() {
+		t.Errorf("expected no pause end time once resumed, got %v", u)
+	}
+
+	// another override cancels the timed pause
+	PauseUntil(time.Now().Add(time.Hour))
+	OverrideMiningActivityState(true)
+	if s := getMiningActivityState(); s != MINING_ACTIVE_USER_OVERRIDE {
+		t.Errorf("expected MINING_ACTIVE_USER_OVERRIDE, got %v", s)
+	}
+	if pauseTimer != nil {
+		t.Error("expected pause timer to be stopped")
+	}
+
+	// a time in the past removes the timed pause
+	PauseUntil(time.Now().Add(time.Hour))
+	PauseUntil(time.Now().Add(-time.Minute))
+	if s := getMiningActivityState(); s != MINING_ACTIVE {
+		t.Errorf("expected MINING_ACTIVE, got %v", s)
+	}
+}
+
+// standardShareSink is a ShareSink that doesn't support multiclient submits.
+type standardShareSink struct{}
+
+func (standardShareSink) SubmitWork(nonce string, jobid string, chats []client.ChatToSend, chatToken int64, connNonce []byte) (*client.Response, error) {
+	return nil, client.ErrNotAlive
+}
+func (standardShareSink) IsAlive() bool { return false }
+func (standardShareSink) Close()        {}
+
+func TestMulticlientSubmitter(t *testing.T) {
+	defer func() {
+		plArgs = nil
+		multiclient = false
+	}()
+	plArgs = &PoolLoginArgs{Username: "user", RigID: "rig"}
+	if mc, _, _ := multiclientSubmitter(&cl); mc != nil {
+		t.Error("expected standard submits unless multiclient is set")
+	}
+	multiclient = true
+	if mc, u, r := multiclientSubmitter(&cl); mc != &cl || u != "user" || r != "rig" {
+		t.Errorf("expected multiclient submits for user & rig, got %v, %q, %q", mc, u, r)
+	}
+	if mc, _, _ := multiclientSubmitter(standardShareSink{}); mc != nil {
+		t.Error("expected standard submits to a sink without multiclient support")
+	}
+}
+
+func TestMulticlientSubmit(t *testing.T) {
+	pool, err := stratumtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	configMutex.Lock()
+	plArgs = &PoolLoginArgs{Username: "tester", RigID: "rig"}
+	multiclient = true
+	configMutex.Unlock()
+	defer func() {
+		configMutex.Lock()
+		plArgs = nil
+		multiclient = false
+		configMutex.Unlock()
+		setCurrentJob(nil, nextJobConn())
+		cl.Close()
+	}()
+	if err, _, _, _ := cl.Connect(pool.Addr(), false, "", "tester", "", "rig"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the share is submitted over the pool connection naming the login's user & rig, and the
+	// difficulty of the job it was found for
+	job := stratumtest.NewJob("1")
+	job.Target = "00e00000"
+	diff := blockchain.TargetToDifficulty(job.Target)
+	jobConn := nextJobConn()
+	setCurrentJob(job, jobConn)
+	submitShare("00000001", job.JobID, job.ConnNonce, jobConn, diff)
+	s := pool.Submits()
+	if len(s) != 1 {
+		t.Fatalf("expected 1 submit, got %+v", s)
+	}
+	if s[0].JobID != "1" || s[0].Nonce != "00000001" || s[0].ForUser != "tester" || s[0].ForRig != "rig" || s[0].ForDifficulty != diff {
+		t.Errorf("expected multiclient submit of job 1 for tester, rig & difficulty %v, got %+v", diff, s[0])
+	}
+}
+
+func TestCurrentDifficulty(t *testing.T) {
+	defer func() { lastDifficulty = 0 }()
+	setLastDifficulty(25000)
+	if d := GetMiningState().CurrentDifficulty; d != 25000 {
+		t.Errorf("expected current difficulty of the last job, got %v", d)
+	}
+}
+
+func TestPoolStatsRefreshDue(t *testing.T) {
+	defer func() {
+		poolStatsRefreshInterval = 0
+		lastPoolStatsRefresh = time.Time{}
+	}()
+	now := time.Now()
+	poolStatsRefreshInterval = time.Minute
+	lastPoolStatsRefresh = now.Add(-10 * time.Second)
+	s := &stats.Snapshot{PoolUsername: "user", SecondsOld: 30}
+	if poolStatsRefreshDue("user", s, now) {
+		t.Error("expected refresh within the interval to be throttled")
+	}
+	if !poolStatsRefreshDue("other", s, now) {
+		t.Error("expected refresh for a different user regardless of the interval")
+	}
+	if !poolStatsRefreshDue("user", s, now.Add(time.Minute)) {
+		t.Error("expected refresh once the interval has elapsed")
+	}
+	if poolStatsRefreshDue("user", &stats.Snapshot{PoolUsername: "user", SecondsOld: 2}, now.Add(time.Minute)) {
+		t.Error("expected no refresh of fresh stats")
+	}
+	poolStatsRefreshInterval = -1
+	if poolStatsRefreshDue("user", s, now.Add(time.Hour)) {
+		t.Error("expected no share triggered refresh when disabled")
+	}
+}
+
+// flakyShareSink is a ShareSink whose connection is found dead by the first submit, and which
+// records the submits made after that.
+type flakyShareSink struct {
+	mutex   sync.Mutex
+	calls   int
+	submits []string // job ID and encoded ConnNonce of each submit after the first
+}
+
+func (s *flakyShareSink) SubmitWork(nonce string, jobid string, chats []client.ChatToSend, chatToken int64, connNonce []byte) (*client.Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls++
+	if s.calls == 1 {
+		return nil, client.ErrNotAlive
+	}
+	s.submits = append(s.submits, jobid+":"+hex.EncodeToString(connNonce))
+	r := json.RawMessage(`{"status":"OK","PoolMargin":0.01}`)
+	return &client.Response{ID: client.SUBMIT_WORK_JSON_ID, Result: &r}, nil
+}
+func (s *flakyShareSink) IsAlive() bool { return true }
+func (s *flakyShareSink) Close()        {}
+
+func (s *flakyShareSink) get() (int, []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls, append([]string(nil), s.submits...)
+}
+
+func TestRequeueShare(t *testing.T) {
+	defer func() {
+		shareSink = &cl
+		setCurrentJob(nil, nextJobConn())
+	}()
+	job := stratumtest.NewJob("1")
+	job.ConnNonce = 7
+	reissued := *job
+	replaced := *job
+	replaced.JobID = "2"
+	for _, tc := range []struct {
+		newJob *client.MultiClientJob
+		want   []string
+	}{
+		{&reissued, []string{"1:07000000"}}, // the new connection's job is the same, so resubmitted
+		{&replaced, nil},                    // the job was replaced, so the share is dropped
+	} {
+		sink := &flakyShareSink{}
+		configMutex.Lock()
+		shareSink = sink
+		configMutex.Unlock()
+		jobConn := nextJobConn()
+		setCurrentJob(job, jobConn)
+		before, _, _ := stats.GetSnapshot(true)
+		done := make(chan struct{})
+		go func() {
+			submitShare("00000001", job.JobID, job.ConnNonce, jobConn, 1)
+			close(done)
+		}()
+
+		// the share waits for a job over the new connection, rather than being resubmitted for the
+		// old job still being current
+		waitFor(t, "failed submit", func() bool { c, _ := sink.get(); return c == 1 })
+		time.Sleep(50 * time.Millisecond)
+		if c, _ := sink.get(); c != 1 {
+			t.Errorf("expected no resubmit before the new connection delivers a job, got %v submits", c)
+		}
+		setCurrentJob(tc.newJob, nextJobConn())
+		<-done
+		if _, s := sink.get(); !reflect.DeepEqual(s, tc.want) {
+			t.Errorf("expected resubmits %v after reconnecting with job %v, got %v", tc.want, tc.newJob.JobID, s)
+		}
+		after, _, _ := stats.GetSnapshot(true)
+		abandoned := after.SharesRequeueAbandoned - before.SharesRequeueAbandoned
+		if after.SharesRequeued-before.SharesRequeued != 1 || abandoned != int64(1-len(tc.want)) {
+			t.Errorf("unexpected requeue stats: %+v", after)
+		}
+	}
+}
+
+func TestStreamStatsInterval(t *testing.T) {
+	// non-positive intervals, which time.NewTicker would panic on, are raised to the minimum
+	for _, interval := range []time.Duration{0, -time.Second} {
+		ch, stop := StreamStats(interval)
+		start := time.Now()
+		select {
+		case s := <-ch:
+			if s == nil {
+				t.Errorf("expected snapshot for interval %v", interval)
+			}
+			if d := time.Since(start); d < MIN_STREAM_STATS_INTERVAL/2 {
+				t.Errorf("expected interval %v to be raised to the minimum, got snapshot after %v", interval, d)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("no snapshot for interval %v", interval)
+		}
+		stop()
+		for range ch {
+		}
+	}
+}
+
+func TestWarnThreadShortfall(t *testing.T) {
+	defer func() {
+		configuredThreads, thermalThreads = 0, 0
+		setCurrentJob(nil, nextJobConn())
+		threadShortfallWarned = false
+	}()
+	configuredThreads = 4
+	if warnThreadShortfall(2) {
+		t.Error("expected no shortfall while no job is being mined")
+	}
+	setCurrentJob(stratumtest.NewJob("1"), nextJobConn())
+	if !warnThreadShortfall(2) || !threadShortfallWarned {
+		t.Error("expected shortfall with 2 of 4 threads running")
+	}
+	if warnThreadShortfall(4) || threadShortfallWarned {
+		t.Error("expected no shortfall with all threads running")
+	}
+	thermalThreads = 2
+	if warnThreadShortfall(2) {
+		t.Error("expected threads idled by the temperature policy not to count as a shortfall")
+	}
+}
+
+func TestMiningLoopThreadShortfall(t *testing.T) {
+	defer testPokeChannel()()
+	defer func() {
+		threads, configuredThreads = 0, 0
+		workerRefreshInterval = DEFAULT_WORKER_REFRESH_INTERVAL
+		threadShortfallWarned = false
+		plArgs = nil
+		jobSource = poolJobSource{}
+		RemoveMiningActivityOverride()
+	}()
+	// The workers of the stub rxlib exit right away, so none are running despite 2 being
+	// initialized and configured.
+	threads, configuredThreads = 2, 2
+	workerRefreshInterval = 50 * time.Millisecond
+	configMutex.Lock()
+	plArgs = &PoolLoginArgs{Username: "tester"}
+	jobSource = aliveJobSource{}
+	configMutex.Unlock()
+	OverrideMiningActivityState(true)
+	jc := make(chan *client.MultiClientJob, 1)
+	jc <- stratumtest.NewJob("1")
+	done := make(chan bool, 1)
+	go miningLoop(jc, nil, done)
+	waitFor(t, "thread shortfall", func() bool {
+		configMutex.Lock()
+		defer configMutex.Unlock()
+		return threadShortfallWarned
+	})
+	getPokeChannel() <- EXIT_LOOP_POKE
+	<-done
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package minerlib
+
+import (
+	"github.com/cryptonote-social/csminer/stratum/client"
+
+	"github.com/cryptonote-social/csminer/crylog"
+
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// How long to wait after failing to establish the submit connection before trying again. Shares
+	// found in the meantime are submitted over the primary connection.
+	SUBMIT_CONN_RETRY_DELAY = 30 * time.Second
+)
+
+var (
+	submitConnMutex      sync.Mutex // serializes attempts to establish the submit connection
+	lastSubmitConnFailed time.Time
+)
+
+// JobSource supplies the jobs the miner works on. By default jobs come from the pool via the
+// stratum client, but an alternate source can be specified in PoolLoginArgs, for example to relay
+// jobs from another miner or for testing.
+type JobSource interface {
+	// Connect (re)establishes the source, returning the channel over which jobs will be delivered.
+	// A nil job is sent over the channel when the source is lost, after which the mining loop calls
+	// Connect again.
+	Connect() (<-chan *client.MultiClientJob, error)
+
+	// IsAlive returns true if the source is currently able to deliver jobs.
+	IsAlive() bool
+
+	// Close shuts down the source, causing a nil job to be delivered over its job channel.
+	Close()
+}
+
+// ShareSink receives the shares found by the miner. *client.Client satisfies this interface.
+type ShareSink interface {
+	// SubmitWork submits a share found for the given job, along with any chats to deliver. See
+	// client.Client.SubmitWork.
+	SubmitWork(nonce string, jobid string, chats []client.ChatToSend, chatToken int64, connNonce []byte) (*client.Response, error)
+
+	// IsAlive returns true if the sink is currently able to accept shares.
+	IsAlive() bool
+
+	// Close is called when the miner encounters an unexpected error submitting a share, and should
+	// cause the paired JobSource to reconnect if appropriate.
+	Close()
+}
+
+var _ ShareSink = (*client.Client)(nil)
+
+// MulticlientShareSink is implemented by ShareSinks that can submit shares on behalf of a given user
+// and rig, as used when InitMinerArgs.Multiclient is set. See client.Client.SubmitMulticlientWork.
+type MulticlientShareSink interface {
+	SubmitMulticlientWork(username string, rigid string, nonce string, connNonce []byte, jobid string, targetDifficulty int64) (*client.Response, error)
+}
+
+var _ MulticlientShareSink = (*client.Client)(nil)
+var _ MulticlientShareSink = submitConnSink{}
+
+// poolJobSource is the default JobSource, which receives jobs from the pool over the stratum
+// client using the credentials of the current login.
+type poolJobSource struct{}
+
+func (poolJobSource) Connect() (<-chan *client.MultiClientJob, error) {
+	jc := reconnectClient()
+	if jc == nil {
+		return nil, errors.New("failed to connect to pool")
+	}
+	return jc, nil
+}
+
+func (poolJobSource) IsAlive() bool {
+	return cl.IsAlive()
+}
+
+func (poolJobSource) Close() {
+	cl.Close()
+}
+
+// PoolShareSink returns the ShareSink submitting shares to the pool over the current login, which is
+// the one used unless PoolLoginArgs specifies another. A custom ShareSink can forward shares to it.
+func PoolShareSink() ShareSink {
+	if separateSubmitConn {
+		return submitConnSink{}
+	}
+	return &cl
+}
+
+// getJobSource returns the active job source.
+func getJobSource() JobSource {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return jobSource
+}
+
+// getShareSink returns the active share sink.
+func getShareSink() ShareSink {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return shareSink
+}
+
+// submitConnSink is the ShareSink used for pool logins when SeparateSubmitConnection is set. Shares
+// are submitted over submitCl, a second connection logged in with the same credentials, so that
+// submit writes don't contend with the job stream on cl. Since the jobs being mined were issued to
+// cl's login session, shares are submitted on behalf of that session rather than submitCl's own.
+// The submit connection is established on demand, and shares are submitted over cl whenever it
+// can't be.
+type submitConnSink struct{}
+
+func (submitConnSink) SubmitWork(nonce string, jobid string, chats []client.ChatToSend, chatToken int64, connNonce []byte) (*client.Response, error) {
+	if !connectSubmit() {
+		resp, err := cl.SubmitWork(nonce, jobid, chats, chatToken, connNonce)
+		if err != nil {
+			cl.Close()
+		}
+		return resp, err
+	}
+	return submitCl.SubmitWorkForSession(cl.SessionID(), nonce, jobid, chats, chatToken, connNonce)
+}
+
+func (submitConnSink) SubmitMulticlientWork(username string, rigid string, nonce string, connNonce []byte, jobid string, targetDifficulty int64) (*client.Response, error) {
+	if !connectSubmit() {
+		resp, err := cl.SubmitMulticlientWork(username, rigid, nonce, connNonce, jobid, targetDifficulty)
+		if err != nil {
+			cl.Close()
+		}
+		return resp, err
+	}
+	return submitCl.SubmitMulticlientWorkForSession(cl.SessionID(), username, rigid, nonce, connNonce, jobid, targetDifficulty)
+}
+
+// IsAlive reports the state of the primary connection, since the submit connection is
+// (re)established on demand.
+func (submitConnSink) IsAlive() bool {
+	return cl.IsAlive()
+}
+
+// Close closes only the submit connection, which will be reestablished for the next share.
+func (submitConnSink) Close() {
+	submitCl.Close()
+}
+
+// connectSubmit makes sure the submit connection is established using the current login, returning
+// false if it isn't and couldn't be. Jobs received over the submit connection are ignored, since
+// shares are only found for jobs received over cl.
+func connectSubmit() bool {
+	submitConnMutex.Lock()
+	defer submitConnMutex.Unlock()
+	if submitCl.IsAlive() {
+		return true
+	}
+	if nowFunc().Sub(lastSubmitConnFailed) < SUBMIT_CONN_RETRY_DELAY {
+		return false
+	}
+	configMutex.Lock()
+	if plArgs == nil {
+		configMutex.Unlock()
+		return false
+	}
+	args := *plArgs
+	args.Config = reconnectConfig(args.Config)
+	configMutex.Unlock()
+
+	dest := getServerHostPort(args.UseTLS, args.Dev)
+	err, _, _, jc := submitCl.Connect(dest, args.UseTLS, args.Agent, getLoginName(&args), args.Config, args.RigID)
+	if err != nil {
+		crylog.Warn("Submit connection failed, submitting over the primary connection:", err)
+		lastSubmitConnFailed = nowFunc()
+		return false
+	}
+	go func() {
+		// drain jobs so the connection keeps delivering submit responses
+		for range jc {
+		}
+	}()
+	crylog.Info("Submit connection established")
+	return true
+}
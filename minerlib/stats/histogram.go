@@ -0,0 +1,57 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// Upper bounds of the submitted share difficulty histogram buckets, spanning the range of
+	// difficulties vardiff assigns from a single slow thread up to a large rig.
+	SHARE_DIFFICULTY_BUCKETS = []float64{1e3, 3e3, 1e4, 3e4, 1e5, 3e5, 1e6, 3e6, 1e7}
+
+	// Upper bounds in seconds of the share submit round trip latency histogram buckets.
+	SUBMIT_LATENCY_BUCKETS = []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// Histogram counts observations falling into buckets with the given upper bounds, in the manner of
+// a Prometheus histogram.
+type Histogram struct {
+	// Bounds are the ascending upper bounds (inclusive) of each bucket. Counts has one more entry
+	// than Bounds, the last counting observations above the largest bound. Counts are per bucket,
+	// not cumulative.
+	Bounds []float64
+	Counts []int64
+
+	Sum   float64 // sum of all observations
+	Count int64   // # of observations
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(v float64) {
+	h.Counts[sort.SearchFloat64s(h.Bounds, v)]++
+	h.Sum += v
+	h.Count++
+}
+
+func (h *Histogram) clone() *Histogram {
+	c := *h
+	c.Counts = append([]int64(nil), h.Counts...)
+	return &c
+}
+
+// ShareSubmitted should be called whenever the pool responds to a submitted share, whether
+// accepted or rejected, with the difficulty it was submitted at and how long the pool took to
+// respond.
+func ShareSubmitted(difficulty int64, latency time.Duration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	shareDifficulties.observe(float64(difficulty))
+	submitLatencies.observe(latency.Seconds())
+}
@@ -3,22 +3,53 @@ package stats
 import (
 	"github.com/cryptonote-social/csminer/stratum/client"
 
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// default minimum duration of mining in the recent window before a recent hashrate is reported
+	DEFAULT_MIN_RECENT_WINDOW = 5 * time.Second
+
+	// default weight given to each newly fetched pool hashrate in its exponential moving average
+	DEFAULT_POOL_HASHRATE_SMOOTHING = 0.3
+
+	// trailing window over which the accepted share rate is computed
+	SHARE_RATE_WINDOW = 10 * time.Minute
+
+	// time to next reward reported when it can't be meaningfully estimated, e.g. because the pool
+	// hashrate or network difficulty is unknown
+	TIME_TO_REWARD_UNKNOWN = "unknown"
+
+	// pool hashrates (hashes/sec) below this are too small to estimate time to next reward from
+	MIN_POOL_HASHRATE = 1.0
+
+	// estimates of time to next reward beyond this many days are reported as unknown
+	MAX_TIME_TO_REWARD_DAYS = 36500.0
+)
+
 var (
+	// nowFunc returns the current time. Tests can override it to control time-dependent logic.
+	nowFunc = time.Now
+
 	mutex sync.RWMutex
 
 	// client side stats
 	startTime time.Time // when the miner started up
 
 	recentStatsResetTime time.Time // last time the user instructed recent stats to be reset
+	recentPausedTime     time.Time // time of last call to PauseRecent, or zero if not paused
 
 	accurateTime         time.Time // time of last call to RecentStatsNowAccurate
 	recentHashesAccurate int64     // snapshotted by RecentStatsNowAccurate
@@ -26,31 +57,199 @@ var (
 
 	sharesAccepted                 int64
 	sharesRejected                 int64
+	rejectedByReason               [numRejectReasons]int64
+	sharesAbandoned                int64
+	sharesRequeued                 int64
+	sharesRequeueAbandoned         int64
+	sharesDuplicate                int64
+	datasetCheckFailures           int64
+	blocksFound                    int64
+	bestHash                       int64 // difficulty of the best hash found this session
 	poolSideHashes                 int64
 	clientSideHashes, recentHashes int64
 
+	acceptedTimes []time.Time // times of shares accepted within SHARE_RATE_WINDOW
+
+	shareDifficulties = newHistogram(SHARE_DIFFICULTY_BUCKETS)
+	submitLatencies   = newHistogram(SUBMIT_LATENCY_BUCKETS) // in seconds
+
 	// pool stats
 	lastPoolUsername        string
 	lastPoolUpdateTime      time.Time
 	ppropProgress           float64
 	hashrate1, hashrate24   int64
 	lifetimeHashes          int64
+	lifetimeBestHash        int64
 	paid, owed, accumulated float64
 	timeToReward            string
+	donate                  float64 // fraction of earnings the user donates to the pool
+	poolHashrate            float64 // exponential moving average of the pool's PPROP hashrate, 0 if none yet
+	nextBlockReward         float64 // reward in XMR of the next block found by the pool
+	networkDifficulty       int64   // network difficulty reported with pool stats
 
-	httpClient *http.Client
+	// network info from the most recent job
+	jobNetworkDifficulty, jobReward int64
+
+	lastConnect client.ConnectTimings // timings of the most recent successful pool connect
+	lastTLS     client.TLSInfo        // security of the most recent successful pool connect
+
+	poolHashrateSmoothing = DEFAULT_POOL_HASHRATE_SMOOTHING
+
+	// recent hashrate reporting config
+	minRecentWindow     = DEFAULT_MIN_RECENT_WINDOW
+	provisionalHashrate bool
+
+	httpClient    *http.Client
+	httpTransport http.RoundTripper // nil for the default environment-aware transport
+
+	// settings from which httpTransport is built
+	proxy     *url.URL // nil if no proxy was specified
+	localAddr net.IP   // nil for the system's choice
 )
 
 func Init() {
 	mutex.Lock()
 	defer mutex.Unlock()
-	now := time.Now()
+	now := nowFunc()
 	startTime = now
 	recentStatsResetTime = now
 	accurateTime = now
 
 	httpClient = &http.Client{
-		Timeout: 15 * time.Second,
+		Timeout:   15 * time.Second,
+		Transport: httpTransport,
+	}
+}
+
+// SetRecentHashrateConfig sets the minimum duration of mining in the recent window required before
+// a recent hashrate is reported (DEFAULT_MIN_RECENT_WINDOW if minWindow is 0). If provisional is
+// true, then a recent hashrate will also be reported before the minimum window has been reached,
+// with Snapshot.RecentHashrateProvisional set to indicate its low confidence.
+func SetRecentHashrateConfig(minWindow time.Duration, provisional bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if minWindow <= 0 {
+		minWindow = DEFAULT_MIN_RECENT_WINDOW
+	}
+	minRecentWindow = minWindow
+	provisionalHashrate = provisional
+}
+
+// JobReceived records the network difficulty and block reward (in atomic units) specified by the
+// latest job, either of which may be 0 if unspecified.
+func JobReceived(netDifficulty, reward int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	jobNetworkDifficulty = netDifficulty
+	jobReward = reward
+}
+
+// Connected records the breakdown of time taken by a successful pool (re)connect, and the TLS
+// security of the resulting connection.
+func Connected(timings client.ConnectTimings, tlsInfo client.TLSInfo) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	lastConnect = timings
+	lastTLS = tlsInfo
+}
+
+// SetPoolHashrateSmoothing sets the weight (0.0-1.0] given to each newly fetched pool hashrate in
+// the exponential moving average used to compute the time to next reward. Smaller values smooth
+// more; 1.0 disables smoothing. A value of 0 restores DEFAULT_POOL_HASHRATE_SMOOTHING.
+func SetPoolHashrateSmoothing(factor float64) error {
+	if factor < 0.0 || factor > 1.0 {
+		return errors.New("pool hashrate smoothing factor must be between 0 and 1")
+	}
+	if factor == 0.0 {
+		factor = DEFAULT_POOL_HASHRATE_SMOOTHING
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	poolHashrateSmoothing = factor
+	return nil
+}
+
+// smoothPoolHashrate folds a newly fetched pool hashrate into the moving average and returns the
+// result. mutex must be held.
+func smoothPoolHashrate(hr float64) float64 {
+	if hr <= 0.0 {
+		return poolHashrate
+	}
+	if poolHashrate <= 0.0 {
+		poolHashrate = hr
+	} else {
+		poolHashrate = poolHashrateSmoothing*hr + (1.0-poolHashrateSmoothing)*poolHashrate
+	}
+	return poolHashrate
+}
+
+// timeToRewardString returns a human readable estimate of the time until the pool finds its next
+// block given the network difficulty, pool margin, the PPROP progress, and pool hashrate. Returns
+// TIME_TO_REWARD_UNKNOWN instead of an absurd or non-numeric estimate if the inputs don't allow
+// one, for example a zero difficulty or near-zero hashrate.
+func timeToRewardString(diff, margin, progress, hr float64) string {
+	if !(hr >= MIN_POOL_HASHRATE) || !(diff > 0.0) || math.IsInf(hr, 0) || math.IsInf(diff, 0) {
+		return TIME_TO_REWARD_UNKNOWN
+	}
+	ttr := (diff*(1.0+margin) - (progress * diff)) / hr / 3600.0 / 24.0
+	if math.IsNaN(ttr) || math.IsInf(ttr, 0) || ttr > MAX_TIME_TO_REWARD_DAYS {
+		return TIME_TO_REWARD_UNKNOWN
+	}
+	if ttr <= 0.0 {
+		return "overdue"
+	}
+	if ttr < 1.0 {
+		ttr *= 24.0
+		if ttr < 1.0 {
+			ttr *= 60.0
+			return strconv.FormatFloat(ttr, 'f', 2, 64) + " min"
+		}
+		return strconv.FormatFloat(ttr, 'f', 2, 64) + " hrs"
+	}
+	return strconv.FormatFloat(ttr, 'f', 2, 64) + " days"
+}
+
+// SetProxy routes pool stats requests through proxyURL, as returned by client.ParseProxy. A nil
+// proxyURL restores the default transport, which honors the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables.
+func SetProxy(proxyURL *url.URL) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	proxy = proxyURL
+	updateTransport()
+}
+
+// SetLocalAddr sets the source IP address pool stats requests originate from, which determines the
+// network interface used on multi-homed machines. A nil ip restores the system's choice.
+func SetLocalAddr(ip net.IP) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	localAddr = ip
+	updateTransport()
+}
+
+// updateTransport rebuilds the transport used for pool stats requests from the current settings.
+// mutex must be held.
+func updateTransport() {
+	var t http.RoundTripper
+	if proxy != nil || localAddr != nil {
+		dt := http.DefaultTransport.(*http.Transport).Clone()
+		if proxy != nil {
+			dt.Proxy = http.ProxyURL(proxy)
+		}
+		if localAddr != nil {
+			d := &net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				LocalAddr: &net.TCPAddr{IP: localAddr},
+			}
+			dt.DialContext = d.DialContext
+		}
+		t = dt
+	}
+	httpTransport = t
+	if httpClient != nil {
+		httpClient.Transport = t
 	}
 }
 
@@ -64,7 +263,7 @@ func secondsOld() int {
 	if lastPoolUpdateTime.IsZero() {
 		return -1
 	}
-	return int(time.Now().Sub(lastPoolUpdateTime).Seconds())
+	return int(nowFunc().Sub(lastPoolUpdateTime).Seconds())
 }
 
 // Call whenever we're at at a point where recent hashrate calculation would be accurate,
@@ -75,7 +274,7 @@ func RecentStatsNowAccurate() {
 
 	recentHashesAccurate = recentHashes
 	totalHashesAccurate = clientSideHashes
-	accurateTime = time.Now()
+	accurateTime = nowFunc()
 }
 
 func TallyHashes(hashes int64) {
@@ -90,12 +289,90 @@ func ShareAccepted(diffTarget int64) {
 	defer mutex.Unlock()
 	sharesAccepted++
 	poolSideHashes += diffTarget
+	now := nowFunc()
+	acceptedTimes = append(pruneAcceptedTimes(now), now)
+}
+
+// pruneAcceptedTimes drops the accepted share times that have fallen out of the share rate window,
+// returning the remainder. mutex must be held.
+func pruneAcceptedTimes(now time.Time) []time.Time {
+	cutoff := now.Add(-SHARE_RATE_WINDOW)
+	i := 0
+	for i < len(acceptedTimes) && !acceptedTimes[i].After(cutoff) {
+		i++
+	}
+	return acceptedTimes[i:]
 }
 
-func ShareRejected() {
+// shareRate returns the shares accepted per minute over the share rate window, or since startTime if
+// that was more recent. mutex must be held.
+func shareRate(now time.Time) float64 {
+	acceptedTimes = pruneAcceptedTimes(now)
+	window := now.Sub(startTime)
+	if window > SHARE_RATE_WINDOW {
+		window = SHARE_RATE_WINDOW
+	}
+	if window < time.Minute {
+		// too short to give a meaningful rate
+		return 0.0
+	}
+	return float64(len(acceptedTimes)) / window.Minutes()
+}
+
+// RejectReason categorizes why the pool rejected a share.
+type RejectReason int
+
+const (
+	REJECTED_UNKNOWN        RejectReason = iota
+	REJECTED_LOW_DIFFICULTY              // usually a client/pool target mismatch or vardiff race
+	REJECTED_STALE                       // the job was no longer current
+	REJECTED_DUPLICATE                   // the pool already received this share
+
+	numRejectReasons
+)
+
+// ShareRejected should be called whenever the pool rejects a submitted share, with reason
+// indicating why.
+func ShareRejected(reason RejectReason) {
 	mutex.Lock()
 	defer mutex.Unlock()
 	sharesRejected++
+	if reason < 0 || reason >= numRejectReasons {
+		reason = REJECTED_UNKNOWN
+	}
+	rejectedByReason[reason]++
+}
+
+// ShareAbandoned should be called whenever a share is found but not submitted because it was no
+// longer relevant, e.g. mining was paused or the job was replaced.
+func ShareAbandoned() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sharesAbandoned++
+}
+
+// ShareRequeued should be called whenever a share is queued for resubmission because the pool
+// connection was lost before it could be submitted.
+func ShareRequeued() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sharesRequeued++
+}
+
+// DatasetCheckFailed should be called whenever a periodic integrity check finds the RandomX dataset
+// corrupted.
+func DatasetCheckFailed() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	datasetCheckFailures++
+}
+
+// ShareRequeueAbandoned should be called whenever a requeued share ends up never being submitted,
+// e.g. because its job was replaced before the pool connection was reestablished.
+func ShareRequeueAbandoned() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sharesRequeueAbandoned++
 }
 
 // Call every time an event happens that may induce a big change in hashrate, e.g. reseeding,
@@ -106,24 +383,165 @@ func ResetRecent() {
 	defer mutex.Unlock()
 	recentHashes = 0
 	recentHashesAccurate = 0
-	now := time.Now()
+	now := nowFunc()
 	accurateTime = now
 	recentStatsResetTime = now
+	recentPausedTime = time.Time{}
+}
+
+// PauseRecent suspends the recent hashrate window such that a subsequent call to ResumeRecent will
+// continue it as if no time had passed. Make sure all workers are stopped before calling.
+func PauseRecent() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if recentPausedTime.IsZero() {
+		recentPausedTime = nowFunc()
+	}
+}
+
+// RecentPaused returns true if PauseRecent has been called since the recent stats were last
+// resumed or reset.
+func RecentPaused() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return !recentPausedTime.IsZero()
+}
+
+// ResumeRecent continues the recent hashrate window suspended by PauseRecent, excluding the paused
+// time from the window. If the pause lasted longer than maxPause, then the recent stats are reset
+// instead.
+func ResumeRecent(maxPause time.Duration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	now := nowFunc()
+	if recentPausedTime.IsZero() || now.Sub(recentPausedTime) > maxPause {
+		recentHashes = 0
+		recentHashesAccurate = 0
+		recentStatsResetTime = now
+	} else {
+		recentStatsResetTime = recentStatsResetTime.Add(now.Sub(recentPausedTime))
+	}
+	accurateTime = now
+	recentPausedTime = time.Time{}
+}
+
+// ResetAll resets all client side session stats, including share counts, hash counts, and the
+// hashrate-since-inception window. Pool stats are left untouched since they come from the server.
+// As with ResetRecent, make sure all workers are stopped before calling.
+func ResetAll() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sharesAccepted = 0
+	sharesRejected = 0
+	rejectedByReason = [numRejectReasons]int64{}
+	sharesAbandoned = 0
+	sharesRequeued = 0
+	sharesRequeueAbandoned = 0
+	sharesDuplicate = 0
+	datasetCheckFailures = 0
+	blocksFound = 0
+	bestHash = 0
+	acceptedTimes = nil
+	shareDifficulties = newHistogram(SHARE_DIFFICULTY_BUCKETS)
+	submitLatencies = newHistogram(SUBMIT_LATENCY_BUCKETS)
+	poolSideHashes = 0
+	clientSideHashes = 0
+	recentHashes = 0
+	recentHashesAccurate = 0
+	totalHashesAccurate = 0
+	now := nowFunc()
+	startTime = now
+	accurateTime = now
+	recentStatsResetTime = now
+	recentPausedTime = time.Time{}
+}
+
+// ShareDuplicate should be called whenever a share is found but not submitted because an identical
+// share was already submitted.
+func ShareDuplicate() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sharesDuplicate++
+}
+
+// BlockFound should be called whenever a share is found that also meets the network difficulty.
+func BlockFound() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	blocksFound++
+}
+
+// HashFound should be called with the difficulty of every hash found meeting the share target, to
+// keep track of the best hash of the session.
+func HashFound(difficulty int64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if difficulty > bestHash {
+		bestHash = difficulty
+	}
 }
 
 type Snapshot struct {
 	SharesAccepted, SharesRejected   int64
+	SharesAbandoned                  int64 // shares found but deliberately not submitted
+	SharesRequeued                   int64 // shares resubmitted after the pool connection was lost
+	SharesRequeueAbandoned           int64 // requeued shares that were never submitted
+	SharesDuplicate                  int64 // shares found but not submitted since they were already submitted
+	DatasetCheckFailures             int64 // periodic RandomX dataset integrity checks that failed
+	BlocksFound                      int64 // shares found that also met the network difficulty
+	SessionBestHash                  int64 // difficulty of the best hash found this session
 	ClientSideHashes, PoolSideHashes int64
+
+	// Shares accepted per minute over the last SHARE_RATE_WINDOW, or 0 if stats have been collected
+	// for less than a minute.
+	SharesPerMinute float64
+
+	// Distributions of the difficulty of shares the pool responded to, and of how long it took to
+	// respond in seconds.
+	ShareDifficulty, SubmitLatency *Histogram
+
+	// Breakdown of SharesRejected by the reason given by the pool.
+	RejectedLowDifficulty, RejectedStale, RejectedDuplicate, RejectedUnknown int64
+
 	// A negative value for RecentHashrate is used to indicate "still calculating" (e.g. not enough
 	// of a time window to be accurate)
 	Hashrate, RecentHashrate float64
+	// Hashrate credited by the pool since inception, computed from the difficulty of accepted
+	// shares. Unlike Hashrate it excludes work lost to rejected or stale shares, though it only
+	// converges on the true rate once many shares have been accepted.
+	EffectiveHashrate float64
+	// True if RecentHashrate was computed over less than the configured minimum window and so may
+	// be inaccurate.
+	RecentHashrateProvisional bool
+	// Seconds of mining in the recent window as of the last accurate hash count, and the minimum
+	// required before RecentHashrate is reported without being provisional. These let a UI show
+	// progress such as "calculating (4s/5s)". RecentWindowSeconds is 0 when not mining.
+	RecentWindowSeconds, MinRecentWindowSeconds float64
+	// Seconds since the hash counts were last brought up to date, i.e. the age of the sample the
+	// hashrates were computed from.
+	SecondsSinceAccurate float64
 
 	// Pool stats
 	PoolUsername            string
 	LifetimeHashes          int64
+	LifetimeBestHash        int64 // difficulty of the best hash this user ever found, 0 if unknown
 	Paid, Owed, Accumulated float64
 	TimeToReward            string
-	SecondsOld              int // how many seconds out of date the pool stats are, or -1 if none available yet
+	Donate                  float64 // fraction of earnings donated to the pool (e.g. 0.01 for 1%)
+	PoolHashrate            float64 // smoothed pool PPROP hashrate used for TimeToReward, 0 if unknown
+	NextBlockReward         float64 // XMR reward of the next block found by the pool, 0 if unknown
+	NetworkDifficulty       int64   // network difficulty reported with pool stats, 0 if unknown
+	SecondsOld              int     // how many seconds out of date the pool stats are, or -1 if none available yet
+
+	// Network difficulty and block reward (in atomic units) of the most recently received job, or 0
+	// if the pool didn't specify them. Along with the hashrate these allow a UI to project earnings.
+	JobNetworkDifficulty, JobReward int64
+
+	// Breakdown of the time taken by the most recent successful pool (re)connect, all zero if none.
+	LastConnect client.ConnectTimings
+	// TLS version and cipher suite of the most recent successful pool (re)connect, empty if it
+	// didn't use TLS.
+	TLS client.TLSInfo
 }
 
 func GetSnapshot(isMining bool) (s *Snapshot, secondsSinceReset float64, secondsRecentWindow float64) {
@@ -132,6 +550,20 @@ func GetSnapshot(isMining bool) (s *Snapshot, secondsSinceReset float64, seconds
 	r := &Snapshot{}
 	r.SharesAccepted = sharesAccepted
 	r.SharesRejected = sharesRejected
+	r.RejectedLowDifficulty = rejectedByReason[REJECTED_LOW_DIFFICULTY]
+	r.RejectedStale = rejectedByReason[REJECTED_STALE]
+	r.RejectedDuplicate = rejectedByReason[REJECTED_DUPLICATE]
+	r.RejectedUnknown = rejectedByReason[REJECTED_UNKNOWN]
+	r.SharesAbandoned = sharesAbandoned
+	r.SharesRequeued = sharesRequeued
+	r.SharesRequeueAbandoned = sharesRequeueAbandoned
+	r.SharesDuplicate = sharesDuplicate
+	r.DatasetCheckFailures = datasetCheckFailures
+	r.BlocksFound = blocksFound
+	r.SessionBestHash = bestHash
+	r.SharesPerMinute = shareRate(nowFunc())
+	r.ShareDifficulty = shareDifficulties.clone()
+	r.SubmitLatency = submitLatencies.clone()
 	r.ClientSideHashes = clientSideHashes
 	r.PoolSideHashes = poolSideHashes
 
@@ -141,65 +573,71 @@ func GetSnapshot(isMining bool) (s *Snapshot, secondsSinceReset float64, seconds
 		// as of the last update time
 		elapsedOverall = accurateTime.Sub(startTime).Seconds()
 	} else {
-		elapsedOverall = time.Now().Sub(startTime).Seconds()
+		elapsedOverall = nowFunc().Sub(startTime).Seconds()
 	}
 	if elapsedOverall > 0.0 {
 		r.Hashrate = float64(totalHashesAccurate) / elapsedOverall
 	}
+	// pool side hashes are always up to date, unlike client side hashes while mining
+	if elapsed := nowFunc().Sub(startTime).Seconds(); elapsed > 0.0 {
+		r.EffectiveHashrate = float64(poolSideHashes) / elapsed
+	}
 
 	var elapsedRecent float64
 	if isMining {
 		// Recent stats are only accurate up to the last snapshot time
 		elapsedRecent = accurateTime.Sub(recentStatsResetTime).Seconds()
-		if elapsedRecent > 5.0 && recentHashesAccurate > 0 {
-			// For accurate results, we require at least 5 seconds of mining during the recent
-			// period in order to return a recent hashrate.
+		if elapsedRecent > minRecentWindow.Seconds() && recentHashesAccurate > 0 {
+			// For accurate results, we require at least minRecentWindow of mining during the
+			// recent period in order to return a recent hashrate.
 			r.RecentHashrate = float64(recentHashesAccurate) / elapsedRecent
+		} else if provisionalHashrate && elapsedRecent > 0.0 && recentHashesAccurate > 0 {
+			r.RecentHashrate = float64(recentHashesAccurate) / elapsedRecent
+			r.RecentHashrateProvisional = true
 		} else {
 			r.RecentHashrate = -1.0 // indicates not enough data
 		}
+		if elapsedRecent > 0.0 {
+			r.RecentWindowSeconds = elapsedRecent
+		}
 	}
+	r.MinRecentWindowSeconds = minRecentWindow.Seconds()
+	r.SecondsSinceAccurate = nowFunc().Sub(accurateTime).Seconds()
 
 	if lastPoolUsername != "" {
 		r.PoolUsername = lastPoolUsername
 		r.LifetimeHashes = lifetimeHashes
+		r.LifetimeBestHash = lifetimeBestHash
 		r.Paid = paid
 		r.Owed = owed
 		r.Accumulated = accumulated
 		r.TimeToReward = timeToReward
+		r.Donate = donate
+		r.PoolHashrate = poolHashrate
+		r.NextBlockReward = nextBlockReward
+		r.NetworkDifficulty = networkDifficulty
 	}
+	r.JobNetworkDifficulty = jobNetworkDifficulty
+	r.LastConnect = lastConnect
+	r.TLS = lastTLS
+	r.JobReward = jobReward
 	r.SecondsOld = secondsOld()
-	return r, time.Now().Sub(recentStatsResetTime).Seconds(), elapsedRecent
+	return r, nowFunc().Sub(recentStatsResetTime).Seconds(), elapsedRecent
 }
 
 func RefreshPoolStats2(swr *client.StatsResult) {
 	diff := float64(swr.NetworkDifficulty)
-	hr := float64(swr.PPROPHashrate)
-	var ttreward string
-	if hr > 0.0 {
-		ttr := (diff*(1.0+swr.PoolMargin) - (swr.PPROPProgress * diff)) / hr / 3600.0 / 24.0
-		if ttr > 0.0 {
-			if ttr < 1.0 {
-				ttr *= 24.0
-				if ttr < 1.0 {
-					ttr *= 60.0
-					ttreward = strconv.FormatFloat(ttr, 'f', 2, 64) + " min"
-				} else {
-					ttreward = strconv.FormatFloat(ttr, 'f', 2, 64) + " hrs"
-				}
-			} else {
-				ttreward = strconv.FormatFloat(ttr, 'f', 2, 64) + " days"
-			}
-		} else if ttr < 0.0 {
-			ttreward = "overdue"
-		}
-	}
 
 	mutex.Lock()
-	lastPoolUpdateTime = time.Now()
+	hr := smoothPoolHashrate(float64(swr.PPROPHashrate))
+	ttreward := timeToRewardString(diff, swr.PoolMargin, swr.PPROPProgress, hr)
+	lastPoolUpdateTime = nowFunc()
 	lifetimeHashes = swr.LifetimeHashes
 	paid = swr.Paid
 	owed = swr.Owed
+	donate = swr.Donate
+	nextBlockReward = swr.NextBlockReward
+	networkDifficulty = swr.NetworkDifficulty
 	if swr.NextBlockReward > 0.0 && swr.Progress > 0.0 {
 		progress := swr.Progress / (1.0 + swr.PoolMargin)
 		accumulated = swr.NextBlockReward * progress
@@ -208,16 +646,36 @@ func RefreshPoolStats2(swr *client.StatsResult) {
 	mutex.Unlock()
 }
 
-func RefreshPoolStats(username string) error {
-	uri := "https://cryptonote.social/json/WorkerStats"
-	sbody := "{\"Coin\": \"xmr\", \"Worker\": \"" + username + "\"}\n"
-	body := strings.NewReader(sbody)
-	resp, err := httpClient.Post(uri, "", body)
+// postStats posts body to the stats endpoint at uri and returns the response body. A gzip encoded
+// response is requested to save bandwidth, and decoded here rather than relying on the transport,
+// which doesn't when a custom transport is in use.
+func postStats(uri, body string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(body))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
-	b, err := ioutil.ReadAll(resp.Body)
+	var r io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ioutil.ReadAll(r)
+}
+
+func RefreshPoolStats(username string) error {
+	uri := "https://cryptonote.social/json/WorkerStats"
+	sbody := "{\"Coin\": \"xmr\", \"Worker\": \"" + username + "\"}\n"
+	b, err := postStats(uri, sbody)
 	if err != nil {
 		return err
 	}
@@ -241,13 +699,7 @@ func RefreshPoolStats(username string) error {
 	// Now get pool stats
 	uri = "https://cryptonote.social/json/PoolStats"
 	sbody = "{\"Coin\": \"xmr\"}\n"
-	body = strings.NewReader(sbody)
-	resp2, err := httpClient.Post(uri, "", body)
-	if err != nil {
-		return err
-	}
-	defer resp2.Body.Close()
-	b, err = ioutil.ReadAll(resp2.Body)
+	b, err = postStats(uri, sbody)
 	if err != nil {
 		return err
 	}
@@ -269,35 +721,21 @@ func RefreshPoolStats(username string) error {
 	if diff == 0.0 {
 		diff = float64(ps.NetworkDifficulty)
 	}
-	hr := float64(ps.PPROPHashrate)
-	var ttreward string
-	if hr > 0.0 {
-		ttr := (diff*(1.0+ps.Margin) - (ps.PPROPProgress * diff)) / hr / 3600.0 / 24.0
-		if ttr > 0.0 {
-			if ttr < 1.0 {
-				ttr *= 24.0
-				if ttr < 1.0 {
-					ttr *= 60.0
-					ttreward = strconv.FormatFloat(ttr, 'f', 2, 64) + " min"
-				} else {
-					ttreward = strconv.FormatFloat(ttr, 'f', 2, 64) + " hrs"
-				}
-			} else {
-				ttreward = strconv.FormatFloat(ttr, 'f', 2, 64) + " days"
-			}
-		} else if ttr < 0.0 {
-			ttreward = "overdue"
-		}
-	}
 
 	mutex.Lock()
+	hr := smoothPoolHashrate(float64(ps.PPROPHashrate))
+	ttreward := timeToRewardString(diff, ps.Margin, ps.PPROPProgress, hr)
 	lastPoolUsername = username
-	lastPoolUpdateTime = time.Now()
+	lastPoolUpdateTime = nowFunc()
 	hashrate1 = s.Hashrate1
 	hashrate24 = s.Hashrate24
 	lifetimeHashes = s.LifetimeHashes
+	lifetimeBestHash = s.LifetimeBestHash
 	paid = s.AmountPaid
 	owed = s.AmountOwed
+	donate = s.Donate
+	nextBlockReward = ps.NextBlockReward
+	networkDifficulty = ps.NetworkDifficulty
 	if ps.NextBlockReward > 0.0 && s.CycleProgress > 0.0 {
 		progress := s.CycleProgress / (1.0 + ps.Margin)
 		accumulated = ps.NextBlockReward * progress
@@ -0,0 +1,305 @@
+package stats
+
+import (
+	"github.com/cryptonote-social/csminer/stratum/client"
+
+	"compress/gzip"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResetAll(t *testing.T) {
+	Init()
+	TallyHashes(1000)
+	ShareAccepted(500)
+	ShareRejected(REJECTED_LOW_DIFFICULTY)
+	ShareAbandoned()
+	RecentStatsNowAccurate()
+
+	ResetAll()
+	s, _, _ := GetSnapshot(false)
+	if s.SharesAccepted != 0 || s.SharesRejected != 0 || s.SharesAbandoned != 0 {
+		t.Errorf("expected share counts to be reset, got %v:%v:%v", s.SharesAccepted, s.SharesRejected, s.SharesAbandoned)
+	}
+	if s.RejectedLowDifficulty != 0 {
+		t.Errorf("expected rejection breakdown to be reset, got %v", s.RejectedLowDifficulty)
+	}
+	if s.ClientSideHashes != 0 || s.PoolSideHashes != 0 {
+		t.Errorf("expected hash counts to be reset, got %v:%v", s.ClientSideHashes, s.PoolSideHashes)
+	}
+	if s.Hashrate != 0.0 {
+		t.Errorf("expected hashrate to be reset, got %v", s.Hashrate)
+	}
+}
+
+func TestHashFound(t *testing.T) {
+	Init()
+	HashFound(5000)
+	HashFound(100000)
+	HashFound(20000)
+	s, _, _ := GetSnapshot(false)
+	if s.SessionBestHash != 100000 {
+		t.Errorf("expected session best hash of 100000, got %v", s.SessionBestHash)
+	}
+	ResetAll()
+	s, _, _ = GetSnapshot(false)
+	if s.SessionBestHash != 0 {
+		t.Errorf("expected session best hash to be reset, got %v", s.SessionBestHash)
+	}
+}
+
+func TestSetLocalAddr(t *testing.T) {
+	Init()
+	SetLocalAddr(net.ParseIP("127.0.0.1"))
+	if httpClient.Transport == nil {
+		t.Error("expected custom transport with local address")
+	}
+	proxyURL, err := client.ParseProxy("socks5://127.0.0.1:9050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetProxy(proxyURL)
+	SetLocalAddr(nil)
+	if tr, ok := httpClient.Transport.(*http.Transport); !ok || tr.Proxy == nil {
+		t.Error("expected proxy to be kept after resetting local address")
+	}
+	SetProxy(nil)
+	if httpClient.Transport != nil {
+		t.Error("expected default transport to be restored")
+	}
+}
+
+func TestPostStatsGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(`{"Code":1}`))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"Code":2}`))
+		gz.Close()
+	}))
+	defer srv.Close()
+	Init()
+	b, err := postStats(srv.URL, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"Code":2}` {
+		t.Errorf("expected decoded gzip response, got %q", b)
+	}
+}
+
+func TestPauseResumeRecent(t *testing.T) {
+	Init()
+	TallyHashes(1000)
+	RecentStatsNowAccurate()
+	PauseRecent()
+	if !RecentPaused() {
+		t.Errorf("expected recent stats to be paused")
+	}
+	ResumeRecent(time.Minute)
+	if RecentPaused() {
+		t.Errorf("expected recent stats to be resumed")
+	}
+	if recentHashesAccurate != 1000 {
+		t.Errorf("expected recent hashes to be preserved, got %v", recentHashesAccurate)
+	}
+
+	PauseRecent()
+	ResumeRecent(0) // pause will have exceeded max duration
+	if recentHashesAccurate != 0 {
+		t.Errorf("expected recent hashes to be reset, got %v", recentHashesAccurate)
+	}
+}
+
+// setFakeClock makes nowFunc return a fake time that only advances via the returned function, and
+// returns a function restoring the real clock.
+func setFakeClock() (advance func(time.Duration), restore func()) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+	return func(d time.Duration) { now = now.Add(d) }, func() { nowFunc = time.Now }
+}
+
+func TestSharesPerMinute(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	Init()
+	ResetAll()
+	ShareAccepted(100)
+	if s, _, _ := GetSnapshot(true); s.SharesPerMinute != 0.0 {
+		t.Errorf("expected no share rate in the first minute, got %v", s.SharesPerMinute)
+	}
+	advance(time.Minute)
+	ShareAccepted(100)
+	advance(time.Minute)
+	if s, _, _ := GetSnapshot(true); s.SharesPerMinute != 1.0 {
+		t.Errorf("expected 1 share/min, got %v", s.SharesPerMinute)
+	}
+	// the first share falls out of the window
+	advance(SHARE_RATE_WINDOW - 2*time.Minute - time.Second)
+	ShareAccepted(100)
+	advance(time.Second)
+	if s, _, _ := GetSnapshot(true); s.SharesPerMinute != 0.2 {
+		t.Errorf("expected 0.2 shares/min, got %v", s.SharesPerMinute)
+	}
+}
+
+func TestProvisionalHashrate(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	Init()
+	SetRecentHashrateConfig(time.Hour, false)
+	defer SetRecentHashrateConfig(0, false)
+	TallyHashes(1000)
+	advance(10 * time.Millisecond)
+	RecentStatsNowAccurate()
+	s, _, _ := GetSnapshot(true)
+	if s.RecentHashrate >= 0.0 || s.RecentHashrateProvisional {
+		t.Errorf("expected no recent hashrate, got %v (provisional: %v)", s.RecentHashrate, s.RecentHashrateProvisional)
+	}
+	SetRecentHashrateConfig(time.Hour, true)
+	s, _, _ = GetSnapshot(true)
+	if s.RecentHashrate <= 0.0 || !s.RecentHashrateProvisional {
+		t.Errorf("expected provisional recent hashrate, got %v (provisional: %v)", s.RecentHashrate, s.RecentHashrateProvisional)
+	}
+}
+
+func TestPoolHashrateSmoothing(t *testing.T) {
+	if err := SetPoolHashrateSmoothing(1.5); err == nil {
+		t.Error("expected error for smoothing factor > 1")
+	}
+	if err := SetPoolHashrateSmoothing(0.5); err != nil {
+		t.Fatal(err)
+	}
+	defer SetPoolHashrateSmoothing(0)
+	poolHashrate = 0.0
+	RefreshPoolStats2(&client.StatsResult{PPROPHashrate: 1000, NetworkDifficulty: 1000000})
+	if poolHashrate != 1000.0 {
+		t.Errorf("expected first pool hashrate to be taken as is, got %v", poolHashrate)
+	}
+	ttr1 := timeToReward
+	RefreshPoolStats2(&client.StatsResult{PPROPHashrate: 3000, NetworkDifficulty: 1000000})
+	if poolHashrate != 2000.0 {
+		t.Errorf("expected smoothed pool hashrate of 2000, got %v", poolHashrate)
+	}
+	if timeToReward != timeToRewardString(1000000, 0, 0, 2000) || timeToReward == ttr1 {
+		t.Errorf("expected time to reward computed from smoothed hashrate, got %v", timeToReward)
+	}
+	// a missing hashrate shouldn't disturb the average
+	RefreshPoolStats2(&client.StatsResult{NetworkDifficulty: 1000000})
+	if poolHashrate != 2000.0 {
+		t.Errorf("expected pool hashrate to be unchanged, got %v", poolHashrate)
+	}
+}
+
+func TestRecentHashrateWindow(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	Init()
+	ResetAll()
+	SetRecentHashrateConfig(10*time.Second, false)
+	defer SetRecentHashrateConfig(0, false)
+
+	TallyHashes(5000)
+	advance(5 * time.Second)
+	RecentStatsNowAccurate()
+	s, _, _ := GetSnapshot(true)
+	if s.RecentHashrate >= 0.0 {
+		t.Errorf("expected no recent hashrate before minimum window, got %v", s.RecentHashrate)
+	}
+	if s.RecentWindowSeconds != 5.0 || s.MinRecentWindowSeconds != 10.0 {
+		t.Errorf("expected recent window progress of 5s/10s, got %vs/%vs", s.RecentWindowSeconds, s.MinRecentWindowSeconds)
+	}
+	advance(2 * time.Second)
+	if s, _, _ = GetSnapshot(true); s.SecondsSinceAccurate != 2.0 {
+		t.Errorf("expected last accurate sample to be 2s old, got %v", s.SecondsSinceAccurate)
+	}
+
+	TallyHashes(15000)
+	advance(13 * time.Second)
+	RecentStatsNowAccurate()
+	s, _, recentWindow := GetSnapshot(true)
+	if s.RecentHashrate != 1000.0 || recentWindow != 20.0 {
+		t.Errorf("expected recent hashrate of 1000 over 20s, got %v over %vs", s.RecentHashrate, recentWindow)
+	}
+	if s.Hashrate != 1000.0 {
+		t.Errorf("expected overall hashrate of 1000, got %v", s.Hashrate)
+	}
+}
+
+func TestTimeToRewardString(t *testing.T) {
+	tests := []struct {
+		diff, margin, progress, hr float64
+		want                       string
+	}{
+		{1000000, 0, 0, 1000, "16.67 min"},
+		{360000000, 0, 0, 1000, "4.17 days"},
+		{86400000, 0, 0.5, 1000, "12.00 hrs"},
+		{1000000, 0, 0, 0, TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, 0, 1e-300, TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, 0, -1000, TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, 0, math.NaN(), TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, 0, math.Inf(1), TIME_TO_REWARD_UNKNOWN},
+		{0, 0, 0, 1000, TIME_TO_REWARD_UNKNOWN},
+		{math.NaN(), 0, 0, 1000, TIME_TO_REWARD_UNKNOWN},
+		{1e300, 0, 0, 1000, TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, 1e300, 1000, "overdue"},
+		{1e300, 0, 1e300, 1000, TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, math.Inf(1), 1000, TIME_TO_REWARD_UNKNOWN},
+		{1000000, 0, 1, 1000, "overdue"},
+	}
+	for _, tt := range tests {
+		if got := timeToRewardString(tt.diff, tt.margin, tt.progress, tt.hr); got != tt.want {
+			t.Errorf("timeToRewardString(%v, %v, %v, %v) = %q, want %q", tt.diff, tt.margin, tt.progress, tt.hr, got, tt.want)
+		}
+	}
+}
+
+func TestEffectiveHashrate(t *testing.T) {
+	advance, restore := setFakeClock()
+	defer restore()
+	Init()
+	ResetAll()
+	if s, _, _ := GetSnapshot(true); s.EffectiveHashrate != 0.0 {
+		t.Errorf("expected no effective hashrate before any shares, got %v", s.EffectiveHashrate)
+	}
+	advance(100 * time.Second)
+	ShareAccepted(50000)
+	ShareRejected(REJECTED_STALE)
+	TallyHashes(80000)
+	RecentStatsNowAccurate()
+	s, _, _ := GetSnapshot(true)
+	if s.EffectiveHashrate != 500.0 {
+		t.Errorf("expected effective hashrate of 500, got %v", s.EffectiveHashrate)
+	}
+	if s.Hashrate != 800.0 {
+		t.Errorf("expected client side hashrate of 800, got %v", s.Hashrate)
+	}
+}
+
+func TestShareSubmitted(t *testing.T) {
+	ResetAll()
+	defer ResetAll()
+	ShareSubmitted(1000, 30*time.Millisecond)
+	ShareSubmitted(5000, 2*time.Second)
+	ShareSubmitted(1e8, time.Minute)
+	s, _, _ := GetSnapshot(false)
+	d := s.ShareDifficulty
+	if d.Count != 3 || d.Sum != 1000+5000+1e8 || d.Counts[0] != 1 || d.Counts[2] != 1 || d.Counts[len(d.Bounds)] != 1 {
+		t.Errorf("unexpected share difficulty histogram: %+v", d)
+	}
+	l := s.SubmitLatency
+	if l.Count != 3 || l.Counts[1] != 1 || l.Counts[6] != 1 || l.Counts[len(l.Bounds)] != 1 {
+		t.Errorf("unexpected submit latency histogram: %+v", l)
+	}
+	ShareSubmitted(1000, time.Second)
+	if d.Count != 3 {
+		t.Error("expected snapshot histogram to be a copy")
+	}
+}
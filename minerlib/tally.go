@@ -0,0 +1,130 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package minerlib
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+)
+
+const (
+	// How often a worker thread adds the hashes it has computed to the stats. Tallying after every
+	// rx.HashUntil batch would contend for the stats mutex with many threads.
+	HASH_TALLY_FLUSH_INTERVAL = 100 * time.Millisecond
+)
+
+var (
+	workersMutex   sync.Mutex
+	runningWorkers = map[*worker]bool{} // registered worker threads, see registerWorker
+
+	// # of workers yet to flush their tally since the last requestTally, after which the last to do
+	// so marks the recent stats accurate
+	tallyPending int32
+)
+
+// worker is the handle through which the mining loop interrupts a running worker thread, either to
+// stop it or to have it flush its hash tally.
+type worker struct {
+	stop  *uint32 // stopper polled by the worker's rx.HashUntil calls
+	tally uint32  // atomic int set when the worker should flush its tally
+}
+
+// registerWorker registers a worker thread whose rx.HashUntil calls poll stop, so that
+// stopWorkers and requestTally can interrupt it. The worker must check the global stopper after
+// registering, and call unregister before exiting.
+func registerWorker(stop *uint32) *worker {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	w := &worker{stop: stop}
+	runningWorkers[w] = true
+	return w
+}
+
+func (w *worker) unregister() {
+	workersMutex.Lock()
+	delete(runningWorkers, w)
+	workersMutex.Unlock()
+	if atomic.SwapUint32(&w.tally, 0) != 0 {
+		tallyFlushed()
+	}
+}
+
+// tallied should be called whenever the worker's rx.HashUntil returns. If a tally was requested,
+// it flushes t and returns true, in which case the worker should resume hashing unless it was
+// also told to stop.
+func (w *worker) tallied(t *hashTally) bool {
+	if atomic.SwapUint32(&w.tally, 0) == 0 {
+		return false
+	}
+	t.flush(nowFunc())
+	tallyFlushed()
+	return true
+}
+
+// interruptWorkers makes the rx.HashUntil call of every registered worker return. Set the global
+// stopper first if the workers should exit.
+func interruptWorkers() {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	for w := range runningWorkers {
+		atomic.StoreUint32(w.stop, 1)
+	}
+}
+
+// requestTally has every running worker flush its hash tally without stopping, after which the
+// recent stats are marked accurate just as when the workers are stopped. Returns false if there are
+// no running workers.
+func requestTally() bool {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+	if len(runningWorkers) == 0 {
+		return false
+	}
+	atomic.StoreInt32(&tallyPending, int32(len(runningWorkers)))
+	for w := range runningWorkers {
+		atomic.StoreUint32(&w.tally, 1)
+		atomic.StoreUint32(w.stop, 1)
+	}
+	return true
+}
+
+// tallyFlushed records that a worker flushed its tally in response to requestTally.
+func tallyFlushed() {
+	if atomic.AddInt32(&tallyPending, -1) == 0 {
+		stats.RecentStatsNowAccurate()
+	}
+}
+
+// hashTally accumulates the hash count of a single worker thread for periodic flushing to stats.
+// It is owned by its worker and so needs no locking.
+type hashTally struct {
+	hashes    int64
+	lastFlush time.Time
+}
+
+func newHashTally() *hashTally {
+	return &hashTally{lastFlush: nowFunc()}
+}
+
+// add counts hashes, flushing them to stats if HASH_TALLY_FLUSH_INTERVAL has elapsed since the last
+// flush.
+func (t *hashTally) add(hashes int64) {
+	t.hashes += hashes
+	if now := nowFunc(); now.Sub(t.lastFlush) >= HASH_TALLY_FLUSH_INTERVAL {
+		t.flush(now)
+	}
+}
+
+// flush adds any pending hashes to stats. Workers must flush before exiting so that the counts are
+// complete once all workers have stopped.
+func (t *hashTally) flush(now time.Time) {
+	if t.hashes != 0 {
+		stats.TallyHashes(t.hashes)
+		t.hashes = 0
+	}
+	t.lastFlush = now
+}
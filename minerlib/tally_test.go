@@ -0,0 +1,90 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package minerlib
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cryptonote-social/csminer/minerlib/stats"
+)
+
+func TestHashTally(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+	stats.ResetAll()
+	defer stats.ResetAll()
+	tallied := func() int64 {
+		s, _, _ := stats.GetSnapshot(false)
+		return s.ClientSideHashes
+	}
+
+	tally := newHashTally()
+	tally.add(10)
+	tally.add(20)
+	if h := tallied(); h != 0 {
+		t.Errorf("expected hashes to be buffered, got %v tallied", h)
+	}
+	now = now.Add(HASH_TALLY_FLUSH_INTERVAL)
+	tally.add(5)
+	if h := tallied(); h != 35 {
+		t.Errorf("expected 35 hashes after flush interval, got %v", h)
+	}
+	tally.add(7)
+	tally.flush(now)
+	if h := tallied(); h != 42 {
+		t.Errorf("expected 42 hashes after final flush, got %v", h)
+	}
+}
+
+func TestRequestTally(t *testing.T) {
+	stats.ResetAll()
+	defer stats.ResetAll()
+	// workers are dispatched with the global stopper cleared
+	defer atomic.StoreUint32(&stopper, atomic.SwapUint32(&stopper, 0))
+	if requestTally() {
+		t.Error("expected no tally without running workers")
+	}
+	var stop1, stop2 uint32
+	w1, w2 := registerWorker(&stop1), registerWorker(&stop2)
+	defer w2.unregister()
+	t1, t2 := newHashTally(), newHashTally()
+	t1.add(10)
+	t2.add(20)
+	if w1.tallied(t1) {
+		t.Error("expected no flush before a tally is requested")
+	}
+
+	if !requestTally() {
+		t.Fatal("expected tally to be requested of running workers")
+	}
+	if atomic.LoadUint32(&stop1) != 1 || atomic.LoadUint32(&stop2) != 1 {
+		t.Error("expected workers to be interrupted")
+	}
+	// the workers resume hashing after flushing, at full intensity since they were interrupted
+	var duty *dutyCycle
+	if !w1.tallied(t1) || !duty.pause(&stop1) {
+		t.Error("expected first worker to flush and resume")
+	}
+	if n := atomic.LoadInt32(&tallyPending); n != 1 {
+		t.Errorf("expected 1 worker yet to flush, got %v", n)
+	}
+	// a worker exiting before it flushes is no longer waited for
+	w1.unregister()
+	requestTally()
+	if !w2.tallied(t2) || !duty.pause(&stop2) {
+		t.Error("expected second worker to flush and resume")
+	}
+	if n := atomic.LoadInt32(&tallyPending); n != 0 {
+		t.Errorf("expected all workers to have flushed, got %v pending", n)
+	}
+	if s, _, _ := stats.GetSnapshot(false); s.ClientSideHashes != 30 {
+		t.Errorf("expected 30 hashes tallied, got %v", s.ClientSideHashes)
+	}
+	// without being interrupted, a worker at full intensity exits whenever rx.HashUntil returns
+	if duty.pause(&stop2) {
+		t.Error("expected worker to exit after returning without an interrupt")
+	}
+}
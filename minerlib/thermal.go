@@ -0,0 +1,133 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package minerlib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cryptonote-social/csminer/crylog"
+)
+
+const (
+	// degrees (C) below the maximum temperature at which mining resumes after a thermal pause, if
+	// no resume temperature is specified
+	DEFAULT_THERMAL_HYSTERESIS = 10.0
+
+	// default time given the temperature to settle after each change in thread count while ramping
+	// back up from a thermal pause
+	DEFAULT_THERMAL_STABILIZE_PERIOD = time.Minute
+)
+
+var (
+	// temperature policy config, see InitMinerArgs.MaxTemperature. maxTemperature is 0 if disabled.
+	maxTemperature, resumeTemperature float64
+	thermalStabilizePeriod            time.Duration
+
+	// temperature policy state, protected by configMutex
+	thermalPaused  bool      // true if mining is paused with MINING_PAUSED_TEMPERATURE
+	thermalThreads int       // if positive, at most this many worker threads are started
+	thermalRamping bool      // true while threads are being added back one at a time
+	thermalNext    time.Time // no further change in thermalThreads is made before this time
+)
+
+// checkThermalConfig validates the temperature policy settings, returning the resume temperature
+// and stabilize period with defaults applied.
+func checkThermalConfig(max, resume float64, stabilize time.Duration) (float64, time.Duration, error) {
+	if max == 0.0 {
+		return 0.0, 0, nil
+	}
+	if max < 0.0 || resume < 0.0 || stabilize < 0 {
+		return 0.0, 0, errors.New("temperature settings must not be negative")
+	}
+	if resume == 0.0 {
+		resume = max - DEFAULT_THERMAL_HYSTERESIS
+	}
+	if resume >= max {
+		return 0.0, 0, errors.New("resume temperature must be below the maximum temperature")
+	}
+	if stabilize == 0 {
+		stabilize = DEFAULT_THERMAL_STABILIZE_PERIOD
+	}
+	return resume, stabilize, nil
+}
+
+// ReportTemperature is used to tell the miner the current CPU temperature in degrees C, and should
+// be called periodically, e.g. every 10 seconds, if InitMinerArgs.MaxTemperature was specified.
+// Mining pauses whenever the temperature reaches the maximum. Once it has cooled to the resume
+// temperature, mining restarts with a single thread, and threads are then added back one per
+// stabilize period for as long as the temperature stays below the maximum. Should it reach the
+// maximum while ramping up, the last thread added is dropped and the miner settles at that thread
+// count, rather than oscillating between overheating and pausing.
+func ReportTemperature(celsius float64) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if maxTemperature == 0.0 {
+		return
+	}
+	if applyTemperature(celsius, nowFunc()) && plArgs != nil {
+		go pokeJobDispatcher(STATE_CHANGE_POKE) // call in own goroutine in case it blocks
+	}
+}
+
+// applyTemperature updates the temperature policy state given the current temperature, returning
+// true if the number of threads to mine with changed. configMutex must be held.
+func applyTemperature(celsius float64, now time.Time) bool {
+	if thermalPaused {
+		if celsius > resumeTemperature {
+			return false
+		}
+		crylog.Info("CPU temperature down to", celsius, "C, resuming mining")
+		thermalPaused = false
+		if threads > 1 {
+			crylog.Info("Ramping threads back up one at a time")
+			thermalThreads = 1
+			thermalRamping = true
+			thermalNext = now.Add(thermalStabilizePeriod)
+		}
+		return true
+	}
+	if celsius >= maxTemperature {
+		if thermalThreads > 0 && !thermalRamping && now.Before(thermalNext) {
+			return false // give the last reduction time to take effect
+		}
+		if thermalThreads > 1 {
+			// the most recently added thread is more than the machine can sustain
+			thermalThreads--
+			thermalRamping = false
+			thermalNext = now.Add(thermalStabilizePeriod)
+			crylog.Warn("CPU temperature reached", celsius, "C, settling at", thermalThreads, "threads")
+			return true
+		}
+		crylog.Warn("CPU temperature reached", celsius, "C, pausing mining")
+		thermalPaused = true
+		thermalThreads = 0
+		thermalRamping = false
+		return true
+	}
+	if !thermalRamping || now.Before(thermalNext) {
+		return false
+	}
+	thermalThreads++
+	thermalNext = now.Add(thermalStabilizePeriod)
+	if thermalThreads >= threads {
+		crylog.Info("CPU temperature stable at", celsius, "C, all threads restored")
+		thermalThreads = 0
+		thermalRamping = false
+	} else {
+		crylog.Info("CPU temperature stable at", celsius, "C, increasing to", thermalThreads, "threads")
+	}
+	return true
+}
+
+// workerThreads returns the number of worker threads to mine with, which may be fewer than the
+// number initialized due to the temperature policy.
+func workerThreads() int {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	if thermalThreads > 0 && thermalThreads < threads {
+		return thermalThreads
+	}
+	return threads
+}
@@ -0,0 +1,60 @@
+package minerlib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckThermalConfig(t *testing.T) {
+	if r, s, err := checkThermalConfig(85, 0, 0); err != nil || r != 75 || s != DEFAULT_THERMAL_STABILIZE_PERIOD {
+		t.Errorf("expected defaults, got %v, %v, %v", r, s, err)
+	}
+	if _, _, err := checkThermalConfig(85, 90, 0); err == nil {
+		t.Error("expected error for resume temperature above max")
+	}
+	if _, _, err := checkThermalConfig(-5, 0, 0); err == nil {
+		t.Error("expected error for negative max temperature")
+	}
+}
+
+func TestApplyTemperature(t *testing.T) {
+	defer func() {
+		threads = 0
+		maxTemperature, resumeTemperature = 0, 0
+		thermalPaused, thermalThreads, thermalRamping = false, 0, false
+	}()
+	threads = 4
+	maxTemperature, resumeTemperature, thermalStabilizePeriod = 85, 75, time.Minute
+	now := time.Now()
+	step := func(celsius float64, d time.Duration, wantChange bool, wantThreads int, wantPaused bool) {
+		t.Helper()
+		now = now.Add(d)
+		if changed := applyTemperature(celsius, now); changed != wantChange {
+			t.Errorf("at %vC: expected change %v, got %v", celsius, wantChange, changed)
+		}
+		if n := workerThreads(); n != wantThreads || thermalPaused != wantPaused {
+			t.Errorf("at %vC: expected %v threads & paused %v, got %v & %v", celsius, wantThreads, wantPaused, n, thermalPaused)
+		}
+	}
+
+	step(80, 0, false, 4, false)
+	step(86, 0, true, 4, true) // overheated at full threads
+	step(80, 10*time.Second, false, 4, true)
+	step(74, 10*time.Second, true, 1, false)  // cooled, ramp starts with 1 thread
+	step(78, 10*time.Second, false, 1, false) // within stabilize period
+	step(78, time.Minute, true, 2, false)     // stable, so add a thread
+	step(80, time.Minute, true, 3, false)     // and another
+	step(85, 10*time.Second, true, 2, false)  // too hot at 3, settle at 2
+	step(84, 2*time.Minute, false, 2, false)  // settled, no further ramping
+	step(86, 10*time.Second, true, 1, false)  // still too hot at 2 after settling
+	step(86, 10*time.Second, false, 1, false) // wait for the reduction to take effect
+	step(86, time.Minute, true, 4, true)      // too hot even at 1, so pause
+	step(70, 10*time.Second, true, 1, false)  // cooled, ramp restarts
+	step(70, time.Minute, true, 2, false)
+	step(70, time.Minute, true, 3, false)
+	step(70, time.Minute, true, 4, false) // all threads restored
+	step(70, time.Minute, false, 4, false)
+	if thermalRamping || thermalThreads != 0 {
+		t.Errorf("expected ramp to be complete, got ramping %v with %v threads", thermalRamping, thermalThreads)
+	}
+}
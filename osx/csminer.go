@@ -9,6 +9,8 @@ import (
 	"context"
 	"github.com/cryptonote-social/csminer"
 	"github.com/cryptonote-social/csminer/crylog"
+	"golang.org/x/sys/unix"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -17,6 +19,22 @@ import (
 type OSXMachineStater struct {
 }
 
+// LowerPriority sets the nice value of the process to the lowest priority.
+func (s OSXMachineStater) LowerPriority() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, 19)
+}
+
+// Notify shows a desktop notification via AppleScript.
+func (s OSXMachineStater) Notify(title, message string) error {
+	script := "display notification " + appleScriptString(message) + " with title " + appleScriptString(title)
+	return exec.Command("osascript", "-e", script).Run()
+}
+
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return "\"" + strings.ReplaceAll(s, "\"", "\\\"") + "\""
+}
+
 // The OSX implementation of the screen & batter state notification channel is based on polling the
 // state every 10 seconds. It would be better to figure out how to get notified of state changes
 // when they happen.
@@ -62,6 +80,23 @@ func (s OSXMachineStater) GetMachineStateChannel(saver bool) (chan csminer.Machi
 	return ret, nil
 }
 
+// RunningProcesses returns the executable paths of the running processes, as listed by "ps".
+func (s OSXMachineStater) RunningProcesses() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	b, err := exec.CommandContext(ctx, "ps", "-axo", "comm=").Output()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
 // getScreenActiveState gets the OSX lockscreen status. Current implementation
 // invokes a python script; this should be improved.
 func getScreenActiveState() (bool, error) {
@@ -109,5 +144,5 @@ func getBatteryPowerState() (bool, error) {
 }
 
 func main() {
-	csminer.MultiMain(OSXMachineStater{}, "csminer "+csminer.VERSION_STRING+" (osx)")
+	os.Exit(csminer.MultiMain(OSXMachineStater{}, "csminer "+csminer.VERSION_STRING+" (osx)"))
 }
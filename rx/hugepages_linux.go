@@ -0,0 +1,49 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package rx
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HugePagesFree returns the number of bytes of memory currently available as free huge pages, as
+// reported by /proc/meminfo.
+func HugePagesFree() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var free, size int64 = -1, -1
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "HugePages_Free:":
+			free, err = strconv.ParseInt(fields[1], 10, 64)
+		case "Hugepagesize:":
+			size, err = strconv.ParseInt(fields[1], 10, 64)
+			size *= 1024 // reported in kB
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err = s.Err(); err != nil {
+		return 0, err
+	}
+	if free < 0 || size < 0 {
+		return 0, ErrHugePagesUnknown
+	}
+	return free * size, nil
+}
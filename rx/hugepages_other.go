@@ -0,0 +1,13 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package rx
+
+// HugePagesFree returns ErrHugePagesUnknown since free huge pages can't be determined on this
+// platform.
+func HugePagesFree() (int64, error) {
+	return 0, ErrHugePagesUnknown
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+//go:build darwin
+// +build darwin
+
+package rx
+
+/*
+ // librandomx is weak linked on OSX, so randomx_alloc_cache is declared weak too, letting a
+ // missing library be reported rather than crashing on first use.
+ extern void* randomx_alloc_cache(int flags) __attribute__((weak));
+ static int randomx_loaded() {
+   return randomx_alloc_cache != 0;
+ }
+*/
+import "C"
+
+// CheckLibrary returns ErrLibraryMissing if the weak linked RandomX library wasn't found next to the
+// binary at startup, in which case no other method in this package may be called.
+func CheckLibrary() error {
+	if C.randomx_loaded() == 0 {
+		return ErrLibraryMissing
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+//go:build !darwin
+// +build !darwin
+
+package rx
+
+// CheckLibrary always returns nil, since librandomx is linked statically on this platform and so
+// can't be missing at runtime.
+func CheckLibrary() error {
+	return nil
+}
@@ -5,19 +5,86 @@
 package rx
 
 // #cgo CFLAGS: -std=c11 -D_GNU_SOURCE -m64 -O3 -I${SRCDIR}/../../RandomX/rxlib/
-// #cgo LDFLAGS: -L${SRCDIR}/../../RandomX/rxlib/ -Wl,-rpath,$ORIGIN ${SRCDIR}/../../RandomX/rxlib/rxlib.cpp.o -lrandomx -lstdc++ -lm
+// #cgo LDFLAGS: -L${SRCDIR}/../../RandomX/rxlib/ ${SRCDIR}/../../RandomX/rxlib/rxlib.cpp.o
+// #cgo linux windows LDFLAGS: -Wl,-Bstatic -lrandomx -Wl,-Bdynamic -lstdc++ -lm
+// #cgo darwin LDFLAGS: -Wl,-rpath,@executable_path -weak-lrandomx -lstdc++ -lm
 /*
  #include <stdlib.h>
  #include "rxlib.h"
+
+ // rx_get_flags, rx_hash_once and rx_lib_version are declared weak so we still link against versions of rxlib
+ // that predate them. No released rxlib exports them yet, so until it's updated the functions that
+ // depend on them report "unknown" or ErrHashOnceUnsupported.
+ extern int rx_get_flags() __attribute__((weak));
+ static int get_flags() {
+   return rx_get_flags ? rx_get_flags() : -1;
+ }
+ extern bool rx_hash_once(const char* blob, uint32_t len, int thread, char* hash) __attribute__((weak));
+ static int hash_once(const char* blob, uint32_t len, int thread, char* hash) {
+   if (!rx_hash_once) return -1;
+   return rx_hash_once(blob, len, thread, hash) ? 1 : 0;
+ }
+ static bool has_hash_once() {
+   return rx_hash_once != 0;
+ }
+
+ extern const char* rx_lib_version() __attribute__((weak));
+ static const char* lib_version() {
+   return rx_lib_version ? rx_lib_version() : 0;
+ }
 */
 import "C"
 
 import (
 	"github.com/cryptonote-social/csminer/crylog"
-	//	"encoding/hex"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 	"unsafe"
 )
 
+// Algo names, as sent by the pool in the job's algo field, that rxlib can correctly hash. Only the
+// Monero RandomX variant is supported.
+var supportedAlgos = map[string]bool{
+	"rx/0":    true,
+	"rx":      true,
+	"randomx": true,
+}
+
+// SupportsAlgo returns true if the given algo name identifies a hashing algorithm rxlib supports.
+func SupportsAlgo(algo string) bool {
+	return supportedAlgos[strings.ToLower(algo)]
+}
+
+// MIN_BLOCK_MAJOR_VERSION is the earliest block major version (the Monero v12 hard fork) whose
+// blocks are hashed with RandomX.
+const MIN_BLOCK_MAJOR_VERSION = 12
+
+// CheckBlockVersion returns an error if blocks with the given major & minor version cannot be hashed
+// by rxlib. A major version of 0 indicates the version was unspecified and is always accepted.
+func CheckBlockVersion(major, minor int) error {
+	if major == 0 || major >= MIN_BLOCK_MAJOR_VERSION {
+		return nil
+	}
+	return fmt.Errorf("block version %d.%d predates RandomX and is not supported", major, minor)
+}
+
+// LibVersion returns the version string reported by the linked rxlib, or "unknown" if rxlib is too
+// old to report it, which for now is always the case since rxlib doesn't yet export
+// rx_lib_version.
+func LibVersion() string {
+	v := C.lib_version()
+	if v == nil {
+		return "unknown"
+	}
+	return C.GoString(v)
+}
+
+// ErrLibraryMissing is returned by CheckLibrary if the RandomX library could not be loaded.
+var ErrLibraryMissing = errors.New("RandomX library not found next to the binary; see build instructions at https://github.com/cryptonote-social/csminer#build")
+
 // Call this every time the seed hash provided by the daemon changes before performing any hashing.
 // Only call when all existing threads are stopped. Returns false if an unrecoverable error
 // occurred.
@@ -55,6 +122,119 @@ func HashUntil(blob []byte, difficulty uint64, thread int, hash []byte, nonce []
 	return int64(res)
 }
 
+// RandomX flag bits, matching randomx_flags from randomx.h
+const (
+	FLAG_LARGE_PAGES  = 1
+	FLAG_HARD_AES     = 2
+	FLAG_FULL_MEM     = 4
+	FLAG_JIT          = 8
+	FLAG_SECURE       = 16
+	FLAG_ARGON2_SSSE3 = 32
+	FLAG_ARGON2_AVX2  = 64
+)
+
+var flagNames = []struct {
+	flag int
+	name string
+}{
+	{FLAG_LARGE_PAGES, "large_pages"},
+	{FLAG_HARD_AES, "hard_aes"},
+	{FLAG_FULL_MEM, "full_mem"},
+	{FLAG_JIT, "jit"},
+	{FLAG_SECURE, "secure"},
+	{FLAG_ARGON2_SSSE3, "argon2_ssse3"},
+	{FLAG_ARGON2_AVX2, "argon2_avx2"},
+}
+
+// ActiveFlags returns a space separated list of the RandomX flags chosen by rxlib at init, e.g.
+// "large_pages hard_aes full_mem jit". Returns "unknown" if called before InitRX or if rxlib is too
+// old to report its flags, as every rxlib is until one exporting rx_get_flags is released. Any of hard_aes, full_mem or jit missing indicates a slow fallback
+// is in effect.
+func ActiveFlags() string {
+	f := int(C.get_flags())
+	if f < 0 {
+		return "unknown"
+	}
+	r := []string{}
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			r = append(r, fn.name)
+		}
+	}
+	if len(r) == 0 {
+		return "none"
+	}
+	return strings.Join(r, " ")
+}
+
+// Official RandomX test vector (see RandomX/src/tests/tests.cpp)
+const (
+	SELF_TEST_KEY      = "test key 000"
+	SELF_TEST_INPUT    = "This is a test"
+	SELF_TEST_EXPECTED = "639183aae1bf4c9a35884cb46b09cad9175f04efd7684e7262a0ac1c2f0b4e3f"
+)
+
+// Approximate amount of huge page memory rxlib needs to allocate the RandomX dataset and cache with
+// large pages: 2080MiB for the dataset plus 256MiB for the cache.
+const LARGE_PAGES_REQUIRED = (2080 + 256) * 1024 * 1024
+
+// ErrHugePagesUnknown is returned by HugePagesFree if available huge pages can't be determined.
+var ErrHugePagesUnknown = errors.New("unable to determine available huge pages")
+
+// ErrSelfTestUnsupported is returned by SelfTest if rxlib is too old to hash arbitrary input.
+var ErrSelfTestUnsupported = errors.New("rxlib does not support self test")
+
+// ErrHashOnceUnsupported is returned by HashOnce if rxlib is too old to hash arbitrary input.
+var ErrHashOnceUnsupported = errors.New("rxlib does not support hashing arbitrary input")
+
+// HashOnceSupported returns false if rxlib is too old to hash arbitrary input, in which case HashOnce
+// and SelfTest always fail.
+func HashOnceSupported() bool {
+	return bool(C.has_hash_once())
+}
+
+// HashOnce returns the RandomX hash of input computed by the VM of the given thread using the
+// current seed. Only call when that thread isn't mining.
+func HashOnce(input []byte, thread int) ([]byte, error) {
+	if len(input) == 0 {
+		return nil, errors.New("empty input")
+	}
+	hash := make([]byte, 32)
+	res := C.hash_once(
+		(*C.char)(unsafe.Pointer(&input[0])),
+		(C.uint32_t)(len(input)),
+		(C.int)(thread),
+		(*C.char)(unsafe.Pointer(&hash[0])))
+	if res < 0 {
+		return nil, ErrHashOnceUnsupported
+	}
+	if res == 0 {
+		return nil, errors.New("failed to compute hash")
+	}
+	return hash, nil
+}
+
+// SelfTest verifies rxlib produces the correct hash for the official RandomX test vector,
+// returning an error on mismatch. It reseeds with the test key, so SeedRX must be called again
+// before mining. Only call after InitRX and when all existing threads are stopped.
+func SelfTest() error {
+	if !SeedRX([]byte(SELF_TEST_KEY), 1) {
+		return errors.New("failed to seed with self test key")
+	}
+	hash, err := HashOnce([]byte(SELF_TEST_INPUT), 0 /* thread */)
+	if err == ErrHashOnceUnsupported {
+		return ErrSelfTestUnsupported
+	}
+	if err != nil {
+		return errors.New("failed to compute self test hash")
+	}
+	expected, _ := hex.DecodeString(SELF_TEST_EXPECTED)
+	if !bytes.Equal(hash, expected) {
+		return fmt.Errorf("self test hash mismatch: expected %s, got %s", SELF_TEST_EXPECTED, hex.EncodeToString(hash))
+	}
+	return nil
+}
+
 // only call when all existing threads are stopped
 func AddThread() int {
 	res := C.rx_add_thread()
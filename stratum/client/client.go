@@ -6,15 +6,17 @@
 package client
 
 import (
-	"bufio"
-	"crypto/tls"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/cryptonote-social/csminer/crylog"
 	"io"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,12 +28,64 @@ const (
 	MAX_REQUEST_SIZE   = 50000 // Max # of bytes we will read per request
 	MAX_RUNES_PER_CHAT = 1000  // any chats with more than this number of unicode chars will be ignored by server
 
+	// # of received jobs buffered on the job channel, allowing jobs pushed while the caller is busy
+	// (e.g. reseeding) to be read from the connection and then coalesced by the caller.
+	JOB_QUEUE_SIZE = 16
+
 	NO_WALLET_SPECIFIED_WARNING_CODE = 2
 
+	// code reported for errors from the pool that didn't specify a (non-zero) code
+	UNKNOWN_ERROR_CODE = -1
+
+	// session id sent with submitted work when the pool didn't return one at login
+	DEFAULT_SESSION_ID = "696969"
+
+	// If the pool server closes the connection within this long of a successful login without
+	// sending anything further, it's reported as a distinct condition since it usually means the
+	// server rejected the session rather than a network problem.
+	CLOSED_AFTER_LOGIN_WINDOW = 10 * time.Second
+
+	// How long the connection may go without receiving anything from the pool before it's
+	// considered dead and closed. Connections that are merely idle are kept from reaching it by the
+	// caller's keepalive requests, see IdleTime.
+	DEFAULT_READ_TIMEOUT = 2 * time.Minute
+	MIN_READ_TIMEOUT     = 10 * time.Second
+
 	// user string used for chats sent by any unauthenticated user regardless of their login
 	UNAUTHENTICATED_USER_STRING = "<unauthenticated user>"
 )
 
+// ErrNotAlive is returned by requests made while the client isn't connected. Nothing was sent to
+// the pool, so the request can safely be retried once the client reconnects.
+var ErrNotAlive = errors.New("client not alive")
+
+var (
+	readTimeoutMutex sync.Mutex
+	readTimeout      = DEFAULT_READ_TIMEOUT
+)
+
+// SetReadTimeout sets how long connections established from now on may go without receiving
+// anything from the pool before they're closed. 0 restores DEFAULT_READ_TIMEOUT.
+func SetReadTimeout(d time.Duration) error {
+	if d == 0 {
+		d = DEFAULT_READ_TIMEOUT
+	}
+	if d < MIN_READ_TIMEOUT {
+		return fmt.Errorf("pool read timeout must be at least %v", MIN_READ_TIMEOUT)
+	}
+	readTimeoutMutex.Lock()
+	defer readTimeoutMutex.Unlock()
+	readTimeout = d
+	return nil
+}
+
+// ReadTimeout returns the timeout set with SetReadTimeout.
+func ReadTimeout() time.Duration {
+	readTimeoutMutex.Lock()
+	defer readTimeoutMutex.Unlock()
+	return readTimeout
+}
+
 type Job struct {
 	Blob   string `json:"blob"`
 	JobID  string `json:"job_id"`
@@ -50,14 +104,21 @@ type RXJob struct {
 	SeedHash string `json:"seed_hash"`
 }
 
-type ForknoteJob struct {
-	Job
+// BlockVersion holds the block version fields sent by forknote-based pools. Both fields will be 0
+// if the pool did not specify them.
+type BlockVersion struct {
 	MajorVersion int `json:"blockMajorVersion"`
 	MinorVersion int `json:"blockMinerVersion"`
 }
 
+type ForknoteJob struct {
+	Job
+	BlockVersion
+}
+
 type MultiClientJob struct {
 	RXJob
+	BlockVersion
 	NetworkDifficulty int64  `json:"net_diff"`
 	Reward            int64  `json:"reward"`
 	ConnNonce         uint32 `json:"nonce"`
@@ -118,35 +179,161 @@ type Response struct {
 	Job    *MultiClientJob  `json:"params"` // used to send jobs over the connection
 	Result *json.RawMessage `json:"result"` // used to return SubmitWork or GetChats results
 
-	Error interface{} `json:"error"`
+	Error *RPCError `json:"error"`
 
 	ChatToken int64 `json:"chat_token"` // custom field
 }
 
+// RPCError is an error returned by the pool. Pools usually send an object with code and message
+// fields, but some send a bare string or number, or a stratum style [code, message, ...] array, so
+// all of these are normalized when unmarshalling. Code is never 0 so that callers can use it to
+// distinguish errors sent by the pool from connection failures.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = RPCError{}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		e.setCode(t["code"])
+		e.Message, _ = t["message"].(string)
+	case []interface{}:
+		if len(t) > 0 {
+			e.setCode(t[0])
+		}
+		if len(t) > 1 {
+			e.Message, _ = t[1].(string)
+		}
+	case string:
+		e.Message = t
+	case float64:
+		e.Code = int(t)
+	default:
+		e.Message = string(data)
+	}
+	if e.Code == 0 {
+		e.Code = UNKNOWN_ERROR_CODE
+	}
+	return nil
+}
+
+func (e *RPCError) setCode(v interface{}) {
+	if c, ok := v.(float64); ok {
+		e.Code = int(c)
+	}
+}
+
+func (e *RPCError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("pool error code %d", e.Code)
+	}
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// LoginHints holds optional structured settings the pool may include with its login response or
+// login warning. Zero values indicate the pool didn't specify the setting.
+type LoginHints struct {
+	StartDiff  int64 `json:"start_diff"`  // starting difficulty recommended for this miner
+	ServerTime int64 `json:"server_time"` // unix time at the pool server when the response was sent
+}
+
+// merge fills in any settings unspecified in h from other.
+func (h *LoginHints) merge(other *LoginHints) {
+	if other == nil {
+		return
+	}
+	if h.StartDiff == 0 {
+		h.StartDiff = other.StartDiff
+	}
+	if h.ServerTime == 0 {
+		h.ServerTime = other.ServerTime
+	}
+}
+
 type loginResponse struct {
 	ID      uint64 `json:"id"`
 	Jsonrpc string `json:"jsonrpc"`
 	Result  *struct {
-		ID  string          `json:"id"`
-		Job *MultiClientJob `job:"job"`
+		ID    string          `json:"id"`
+		Job   *MultiClientJob `job:"job"`
+		Hints *LoginHints     `json:"hints"`
 	} `json:"result"`
-	Error *struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
-	} `json:"error"`
+	Error *RPCError `json:"error"`
 	// our own custom field for reporting login warnings without forcing disconnect from error:
 	Warning *struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Hints   *LoginHints `json:"hints"`
 	} `json:"warning"`
 
 	ChatToken int64 `json:"chat_token"` // custom field
 }
 
+// poolCapabilities summarizes which of the optional or variant login response fields we recognize
+// were sent by the pool, to help diagnose compatibility issues with pools other than our own.
+func poolCapabilities(r *loginResponse) string {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	orUnspecified := func(s string) string {
+		if s == "" {
+			return "unspecified"
+		}
+		return s
+	}
+	hints := []string{}
+	var merged LoginHints
+	merged.merge(r.Result.Hints)
+	if r.Warning != nil {
+		merged.merge(r.Warning.Hints)
+	}
+	if merged.StartDiff != 0 {
+		hints = append(hints, "start_diff")
+	}
+	if merged.ServerTime != 0 {
+		hints = append(hints, "server_time")
+	}
+	if len(hints) == 0 {
+		hints = append(hints, "none")
+	}
+	caps := []string{
+		"jsonrpc=" + orUnspecified(r.Jsonrpc),
+		"session_id=" + yesNo(r.Result.ID != ""),
+		"chat_token=" + yesNo(r.ChatToken != 0),
+		"warning=" + yesNo(r.Warning != nil),
+		"hints=" + strings.Join(hints, ","),
+	}
+	if j := r.Result.Job; j != nil {
+		caps = append(caps,
+			"algo="+orUnspecified(j.Algo),
+			"seed_hash="+yesNo(j.SeedHash != ""),
+			"height="+yesNo(j.Height != 0),
+			"net_diff="+yesNo(j.NetworkDifficulty != 0),
+			"block_version="+yesNo(j.MajorVersion != 0 || j.MinorVersion != 0),
+			"self_select="+yesNo(j.PoolWallet != "" || j.ExtraNonce != ""),
+		)
+	}
+	return strings.Join(caps, " ")
+}
+
 type Client struct {
 	address         string
 	conn            net.Conn
 	responseChannel chan *Response
+	sessionID       string // id returned by the pool at login, to be sent with submitted work
+	loginHints      LoginHints
+	connectTimings  ConnectTimings // timings of the most recent successful connect
+	tlsInfo         TLSInfo        // security of the current connection
+	lastRead        *int64         // unix nanos when the connection last received a message, accessed atomically
 
 	mutex sync.Mutex
 
@@ -178,11 +365,14 @@ func (cl *Client) Connect(
 	defer cl.mutex.Unlock()
 	cl.address = address
 
+	timings := ConnectTimings{}
+	ctx, cancel := context.WithTimeout(context.Background(), DIAL_TIMEOUT)
 	if !useTLS {
-		cl.conn, err = net.DialTimeout("tcp", address, time.Second*30)
+		cl.conn, err = dialTCP(ctx, address, &timings)
 	} else {
-		cl.conn, err = tls.Dial("tcp", address, nil /*Config*/)
+		cl.conn, err = dialTLS(ctx, address, &timings)
 	}
+	cancel()
 	if err != nil {
 		crylog.Error("Dial failed:", err, cl)
 		return err, 0, "", nil
@@ -213,7 +403,8 @@ func (cl *Client) Connect(
 		crylog.Error("json marshalling failed:", err, "for client")
 		return err, 0, "", nil
 	}
-	cl.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	loginStart := time.Now()
+	cl.conn.SetWriteDeadline(loginStart.Add(30 * time.Second))
 	data = append(data, '\n')
 	if _, err = cl.conn.Write(data); err != nil {
 		crylog.Error("writing request failed:", err, "for client")
@@ -223,12 +414,13 @@ func (cl *Client) Connect(
 	// Now read the login response
 	response := &loginResponse{}
 	cl.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-	rdr := bufio.NewReaderSize(cl.conn, MAX_REQUEST_SIZE)
+	rdr := newJSONReader(cl.conn)
 	err = readJSON(response, rdr)
 	if err != nil {
 		crylog.Error("readJSON failed for client:", err)
 		return err, 0, "", nil
 	}
+	timings.Login = time.Since(loginStart)
 	if response.Result == nil {
 		if response.Error != nil {
 			crylog.Error("Didn't get job result from login response:", response.Error)
@@ -240,13 +432,30 @@ func (cl *Client) Connect(
 
 	cl.responseChannel = make(chan *Response)
 	cl.alive = true
-	jc := make(chan *MultiClientJob)
+	cl.sessionID = response.Result.ID
+	if cl.sessionID == "" {
+		cl.sessionID = DEFAULT_SESSION_ID
+	}
+	cl.connectTimings = timings
+	cl.tlsInfo = getTLSInfo(cl.conn)
+	cl.lastRead = new(int64)
+	atomic.StoreInt64(cl.lastRead, time.Now().UnixNano())
+	if useTLS {
+		crylog.Info("Pool connection secured with", cl.tlsInfo.Version, "using", cl.tlsInfo.CipherSuite)
+	}
+	cl.loginHints = LoginHints{}
+	cl.loginHints.merge(response.Result.Hints)
+	if response.Warning != nil {
+		cl.loginHints.merge(response.Warning.Hints)
+	}
+	jc := make(chan *MultiClientJob, JOB_QUEUE_SIZE)
 	if response.Result.Job == nil {
 		crylog.Error("malformed login response result:", response.Result)
 		return errors.New("malformed login response case 2"), 0, "", nil
 	}
+	crylog.Info("Pool capabilities:", poolCapabilities(response))
 	response.Result.Job.ChatToken = response.ChatToken
-	go dispatchJobs(cl.conn, jc, response.Result.Job, cl.responseChannel)
+	go dispatchJobs(cl.conn, rdr, jc, response.Result.Job, cl.responseChannel, time.Now(), ReadTimeout(), cl.lastRead)
 	if response.Warning != nil {
 		return nil, response.Warning.Code, response.Warning.Message, jc
 	}
@@ -255,6 +464,13 @@ func (cl *Client) Connect(
 
 // if error is returned then client will be closed and put in not-alive state
 func (cl *Client) SubmitMulticlientWork(username string, rigid string, nonce string, connNonce []byte, jobid string, targetDifficulty int64) (*Response, error) {
+	return cl.SubmitMulticlientWorkForSession(cl.SessionID(), username, rigid, nonce, connNonce, jobid, targetDifficulty)
+}
+
+// SubmitMulticlientWorkForSession is like SubmitMulticlientWork, but submits the share on behalf
+// of the login session sessionID, which must be the session the job was received over, even if
+// that is another connection's.
+func (cl *Client) SubmitMulticlientWorkForSession(sessionID string, username string, rigid string, nonce string, connNonce []byte, jobid string, targetDifficulty int64) (*Response, error) {
 	submitRequest := &struct {
 		ID     uint64      `json:"id"`
 		Method string      `json:"method"`
@@ -272,7 +488,7 @@ func (cl *Client) SubmitMulticlientWork(username string, rigid string, nonce str
 			ForRig        string `json:"for_rig"`
 			ForDifficulty int64  `json:"for_difficulty"`
 			ConnNonce     []byte `json:"conn_nonce"`
-		}{"696969", jobid, nonce, "", username, rigid, targetDifficulty, connNonce},
+		}{sessionID, jobid, nonce, "", username, rigid, targetDifficulty, connNonce},
 	}
 
 	return cl.submitRequest(submitRequest, SUBMIT_WORK_JSON_ID)
@@ -283,7 +499,7 @@ func (cl *Client) submitRequest(submitRequest interface{}, expectedResponseID ui
 	cl.mutex.Lock()
 	if !cl.alive {
 		cl.mutex.Unlock()
-		return nil, errors.New("client not alive")
+		return nil, ErrNotAlive
 	}
 	data, err := json.Marshal(submitRequest)
 	if err != nil {
@@ -291,6 +507,10 @@ func (cl *Client) submitRequest(submitRequest interface{}, expectedResponseID ui
 		cl.mutex.Unlock()
 		return nil, err
 	}
+	if len(data) >= MAX_REQUEST_SIZE {
+		cl.mutex.Unlock()
+		return nil, fmt.Errorf("request of %d bytes exceeds server limit", len(data))
+	}
 	cl.conn.SetWriteDeadline(time.Now().Add(60 * time.Second))
 	data = append(data, '\n')
 	if _, err = cl.conn.Write(data); err != nil {
@@ -337,10 +557,76 @@ type ChatToSend struct {
 	Message string
 }
 
-// If chatToken is non-zero then submit_work will return new chats, if there are any.  If error is
-// returned by this method, then client will be closed and put in not-alive state.
-func (cl *Client) SubmitWork(nonce string, jobid string, chats []ChatToSend, chatToken int64) (*Response, error) {
-	submitRequest := &struct {
+// If chatToken is non-zero then submit_work will return new chats, if there are any. connNonce
+// should be the encoded ConnNonce of the job being submitted, or nil if the job didn't specify
+// one. If error is returned by this method, then client will be closed and put in not-alive state.
+func (cl *Client) SubmitWork(nonce string, jobid string, chats []ChatToSend, chatToken int64, connNonce []byte) (*Response, error) {
+	return cl.SubmitWorkForSession(cl.SessionID(), nonce, jobid, chats, chatToken, connNonce)
+}
+
+// SubmitWorkForSession is like SubmitWork, but submits the share on behalf of the login session
+// sessionID, which must be the session the job was received over, even if that is another
+// connection's.
+func (cl *Client) SubmitWorkForSession(sessionID string, nonce string, jobid string, chats []ChatToSend, chatToken int64, connNonce []byte) (*Response, error) {
+	return cl.submitRequest(submitWorkRequest(sessionID, nonce, jobid, chats, chatToken, connNonce), SUBMIT_WORK_JSON_ID)
+}
+
+// LoginHints returns any structured settings the pool sent with the response to the most recent
+// successful login.
+func (cl *Client) LoginHints() LoginHints {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.loginHints
+}
+
+// IdleTime returns how long it's been since the current connection last received anything from the
+// pool, or 0 if the client isn't alive. Callers should make a request such as GetChats whenever it
+// approaches ReadTimeout to keep an idle but healthy connection from timing out.
+func (cl *Client) IdleTime() time.Duration {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	if !cl.alive {
+		return 0
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(cl.lastRead)))
+}
+
+// ConnectTimings returns the breakdown of how long the most recent successful connect took.
+func (cl *Client) ConnectTimings() ConnectTimings {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.connectTimings
+}
+
+// TLSInfo returns the negotiated TLS version and cipher suite of the most recent successful
+// connect, which are empty if it didn't use TLS.
+func (cl *Client) TLSInfo() TLSInfo {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.tlsInfo
+}
+
+// SessionID returns the id the pool assigned to the most recent login, which identifies the login
+// session in submits.
+func (cl *Client) SessionID() string {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.sessionID
+}
+
+// EncodeConnNonce converts a job's ConnNonce into the form expected by SubmitWork, returning nil if
+// the job didn't specify one.
+func EncodeConnNonce(connNonce uint32) []byte {
+	if connNonce == 0 {
+		return nil
+	}
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, connNonce)
+	return b
+}
+
+func submitWorkRequest(sessionID string, nonce string, jobid string, chats []ChatToSend, chatToken int64, connNonce []byte) interface{} {
+	return &struct {
 		ID     uint64      `json:"id"`
 		Method string      `json:"method"`
 		Params interface{} `json:"params"`
@@ -355,9 +641,32 @@ func (cl *Client) SubmitWork(nonce string, jobid string, chats []ChatToSend, cha
 
 			Chats     []ChatToSend `json:"chats"`
 			ChatToken int64        `json:"chat_token"` // if non-zero, then return any new chats too
-		}{"696969", jobid, nonce, "", chats, chatToken},
+			ConnNonce []byte       `json:"conn_nonce,omitempty"`
+		}{sessionID, jobid, nonce, "", chats, chatToken, connNonce},
 	}
-	return cl.submitRequest(submitRequest, SUBMIT_WORK_JSON_ID)
+}
+
+// TakeOver moves the live connection of other into cl, closing any connection cl had. Any job
+// channel previously returned by other's Connect will continue delivering jobs for the moved
+// connection. other is left in the not-alive state.
+func (cl *Client) TakeOver(other *Client) {
+	cl.Close()
+	other.mutex.Lock()
+	defer other.mutex.Unlock()
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	cl.address = other.address
+	cl.conn = other.conn
+	cl.responseChannel = other.responseChannel
+	cl.sessionID = other.sessionID
+	cl.connectTimings = other.connectTimings
+	cl.tlsInfo = other.tlsInfo
+	cl.lastRead = other.lastRead
+	cl.loginHints = other.loginHints
+	cl.alive = other.alive
+	other.conn = nil
+	other.responseChannel = nil
+	other.alive = false
 }
 
 func (cl *Client) Close() {
@@ -370,23 +679,35 @@ func (cl *Client) Close() {
 	cl.conn.Close()
 }
 
-// dispatchJobs will forward incoming jobs to the JobChannel until error is received or the
-// connection is closed. Client will be in not-alive state on return.
-func dispatchJobs(conn net.Conn, jobChan chan<- *MultiClientJob, firstJob *MultiClientJob, responseChan chan<- *Response) {
+// dispatchJobs will forward incoming jobs to the JobChannel until error is received, nothing is
+// received for readTimeout, or the connection is closed. The time of each message received is
+// stored in lastRead. Client will be in not-alive state on return.
+func dispatchJobs(conn net.Conn, reader *jsonReader, jobChan chan<- *MultiClientJob, firstJob *MultiClientJob, responseChan chan<- *Response, loginTime time.Time, readTimeout time.Duration, lastRead *int64) {
 	defer func() {
 		close(jobChan)
 		close(responseChan)
 	}()
 	jobChan <- firstJob
-	reader := bufio.NewReaderSize(conn, MAX_REQUEST_SIZE)
+	received := 0 // messages received since the login response
 	for {
 		response := &Response{}
-		conn.SetReadDeadline(time.Now().Add(3600 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
 		err := readJSON(response, reader)
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				crylog.Error("Nothing received from pool server in", readTimeout, "closing client")
+				break
+			}
+			if received == 0 && time.Since(loginTime) < CLOSED_AFTER_LOGIN_WINDOW {
+				crylog.Error("Pool server closed the connection immediately after login:", err)
+				crylog.Error("   The server may have rejected this session. Check your login & config options.")
+				break
+			}
 			crylog.Error("readJSON failed, closing client:", err)
 			break
 		}
+		received++
+		atomic.StoreInt64(lastRead, time.Now().UnixNano())
 		if response.Method != "job" {
 			if response.ID == SUBMIT_WORK_JSON_ID || response.ID == GET_CHATS_JSON_ID {
 				responseChan <- response
@@ -404,22 +725,40 @@ func dispatchJobs(conn net.Conn, jobChan chan<- *MultiClientJob, firstJob *Multi
 	}
 }
 
-func readJSON(response interface{}, reader *bufio.Reader) error {
-	data, isPrefix, err := reader.ReadLine()
-	if isPrefix {
+// jsonReader decodes the stream of JSON messages sent by the pool. Messages are usually newline
+// delimited, but may also arrive concatenated, several per read, or split across reads.
+type jsonReader struct {
+	limiter *io.LimitedReader
+	decoder *json.Decoder
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	limiter := &io.LimitedReader{R: r}
+	return &jsonReader{limiter: limiter, decoder: json.NewDecoder(limiter)}
+}
+
+// readJSON decodes the next message into response. Since the limit on bytes read from the
+// connection is reset before each message, a message exceeding MAX_REQUEST_SIZE results in an
+// error instead of unbounded buffering.
+func readJSON(response interface{}, reader *jsonReader) error {
+	reader.limiter.N = MAX_REQUEST_SIZE
+	err := reader.decoder.Decode(response)
+	if err == nil {
+		return nil
+	}
+	if reader.limiter.N <= 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
 		crylog.Warn("oversize request")
 		return errors.New("oversize request")
 	} else if err == io.EOF {
 		crylog.Info("eof")
 		return err
-	} else if err != nil {
-		crylog.Warn("error reading:", err)
-		return err
 	}
-	err = json.Unmarshal(data, response)
-	if err != nil {
-		crylog.Warn("failed to unmarshal json stratum login response:", err)
-		return err
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		crylog.Warn("failed to unmarshal json stratum response:", err)
+	} else {
+		crylog.Warn("error reading:", err)
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,519 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSubmitWorkConnNonce(t *testing.T) {
+	type submitParams struct {
+		Params struct {
+			ConnNonce *[]byte `json:"conn_nonce"`
+		} `json:"params"`
+	}
+
+	connNonce := EncodeConnNonce(0x01020304)
+	data, err := json.Marshal(submitWorkRequest("session", "nonce", "jobid", nil, 0, connNonce))
+	if err != nil {
+		t.Fatalf("failed to marshal submit request: %v", err)
+	}
+	p := &submitParams{}
+	if err = json.Unmarshal(data, p); err != nil {
+		t.Fatalf("failed to unmarshal submit request: %v", err)
+	}
+	if p.Params.ConnNonce == nil || !bytes.Equal(*p.Params.ConnNonce, []byte{4, 3, 2, 1}) {
+		t.Errorf("expected conn_nonce to round trip, got %s", data)
+	}
+
+	// conn_nonce should be omitted entirely when the job didn't specify one
+	if EncodeConnNonce(0) != nil {
+		t.Errorf("expected nil encoding of 0 conn nonce")
+	}
+	data, err = json.Marshal(submitWorkRequest("session", "nonce", "jobid", nil, 0, EncodeConnNonce(0)))
+	if err != nil {
+		t.Fatalf("failed to marshal submit request: %v", err)
+	}
+	p = &submitParams{}
+	if err = json.Unmarshal(data, p); err != nil {
+		t.Fatalf("failed to unmarshal submit request: %v", err)
+	}
+	if p.Params.ConnNonce != nil {
+		t.Errorf("expected no conn_nonce, got %s", data)
+	}
+}
+
+func TestDialTCP(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// "localhost" may also resolve to ::1, on which nothing is listening, so the IPv4 fallback
+	// must win.
+	ct := &ConnectTimings{}
+	conn, err := dialTCP(context.Background(), net.JoinHostPort("localhost", port), ct)
+	if err != nil {
+		t.Fatalf("expected successful dial, got: %v", err)
+	}
+	conn.Close()
+	if ct.Connect <= 0 || ct.DNS < 0 {
+		t.Errorf("expected resolve & connect timings, got %+v", ct)
+	}
+
+	// addresses resolved over HTTPS are dialed in turn until one connects
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("type") == "1" {
+			io.WriteString(w, `{"Status":0,"Answer":[{"type":1,"data":"127.0.0.2"},{"type":1,"data":"127.0.0.1"}]}`)
+			return
+		}
+		io.WriteString(w, `{"Status":0}`)
+	}))
+	defer srv.Close()
+	conn, err = dialDoH(context.Background(), newDialer(func() {}), srv.URL, "pool.example", port)
+	if err != nil {
+		t.Fatalf("expected successful dial via DNS over HTTPS, got: %v", err)
+	}
+	conn.Close()
+
+	l.Close()
+	if _, err = dialTCP(context.Background(), net.JoinHostPort("127.0.0.1", port), &ConnectTimings{}); err == nil {
+		t.Error("expected dial to closed listener to fail")
+	}
+}
+
+func TestDialLocalAddr(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	defer SetLocalAddr(nil)
+
+	SetLocalAddr(net.ParseIP("127.0.0.1"))
+	conn, err := dialTCP(context.Background(), l.Addr().String(), &ConnectTimings{})
+	if err != nil {
+		t.Fatalf("expected successful dial from bind address, got: %v", err)
+	}
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("expected connection from bind address, got %v", ip)
+	}
+	conn.Close()
+
+	SetLocalAddr(net.ParseIP("::1"))
+	if _, err = dialTCP(context.Background(), l.Addr().String(), &ConnectTimings{}); err == nil {
+		t.Error("expected dial of IPv4 address from IPv6 bind address to fail")
+	}
+}
+
+func TestConnectSessionID(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		c.Read(buf) // login request
+		c.Write([]byte(`{"id":666,"jsonrpc":"2.0","result":{"id":"abc123","job":{"job_id":"1","blob":"00","target":"ffffffff"},"hints":{"server_time":1600000000}},` +
+			`"warning":{"code":3,"message":"diff too low","hints":{"start_diff":50000,"unknown":1}}}` + "\n"))
+		time.Sleep(time.Second)
+	}()
+
+	cl := &Client{}
+	err, _, _, _ = cl.Connect(l.Addr().String(), false, "agent", "user", "", "rig")
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer cl.Close()
+	if id := cl.SessionID(); id != "abc123" {
+		t.Errorf("expected session id from login response, got %q", id)
+	}
+	if h := cl.LoginHints(); h.StartDiff != 50000 || h.ServerTime != 1600000000 {
+		t.Errorf("expected login hints from response and warning, got %+v", h)
+	}
+	if ct := cl.ConnectTimings(); ct.Connect <= 0 || ct.Login <= 0 || ct.TLSHandshake != 0 {
+		t.Errorf("expected connect & login timings without a TLS handshake, got %+v", ct)
+	}
+}
+
+func TestRPCError(t *testing.T) {
+	tests := []struct {
+		data    string
+		code    int
+		message string
+	}{
+		{`{"code":-1,"message":"Low difficulty share"}`, -1, "Low difficulty share"},
+		{`{"message":"Invalid job id"}`, UNKNOWN_ERROR_CODE, "Invalid job id"},
+		{`"Unauthenticated"`, UNKNOWN_ERROR_CODE, "Unauthenticated"},
+		{`24`, 24, ""},
+		{`[21,"Job not found",null]`, 21, "Job not found"},
+		{`true`, UNKNOWN_ERROR_CODE, "true"},
+	}
+	for _, tt := range tests {
+		r := &Response{}
+		if err := json.Unmarshal([]byte(`{"id":999,"error":`+tt.data+`}`), r); err != nil {
+			t.Errorf("failed to unmarshal error %s: %v", tt.data, err)
+			continue
+		}
+		if r.Error == nil || r.Error.Code != tt.code || r.Error.Message != tt.message {
+			t.Errorf("error %s: expected code %d & message %q, got %+v", tt.data, tt.code, tt.message, r.Error)
+		}
+	}
+	r := &Response{}
+	if err := json.Unmarshal([]byte(`{"id":999,"error":null}`), r); err != nil || r.Error != nil {
+		t.Errorf("expected no error from null, got %v, %v", r.Error, err)
+	}
+}
+
+func TestConnectStringError(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, 1024)
+		c.Read(buf) // login request
+		c.Write([]byte(`{"id":666,"jsonrpc":"2.0","error":"invalid wallet address"}` + "\n"))
+		time.Sleep(time.Second)
+	}()
+
+	cl := &Client{}
+	err, code, message, _ := cl.Connect(l.Addr().String(), false, "agent", "user", "", "rig")
+	if err == nil || code == 0 || message != "invalid wallet address" {
+		t.Errorf("expected login refusal with pool message, got %v, %v, %q", err, code, message)
+	}
+}
+
+func TestGetTLSInfo(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := getTLSInfo(conn)
+	conn.Close()
+	if !strings.HasPrefix(info.Version, "TLS 1.") || info.CipherSuite == "" || strings.HasPrefix(info.CipherSuite, "0x") {
+		t.Errorf("expected negotiated TLS version & cipher suite, got %+v", info)
+	}
+
+	plain, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if info = getTLSInfo(plain); info != (TLSInfo{}) {
+		t.Errorf("expected no TLS info for plain connection, got %+v", info)
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	// batched, un-delimited & whitespace-delimited messages, delivered a byte per read so
+	// each message is split across reads
+	stream := `{"id":1,"method":"job"}{"id":2}` + "\n" + `  {"id":3}` + "\r\n"
+	rdr := newJSONReader(iotest.OneByteReader(strings.NewReader(stream)))
+	for _, id := range []uint64{1, 2, 3} {
+		r := &Response{}
+		if err := readJSON(r, rdr); err != nil {
+			t.Fatalf("failed to read message %d: %v", id, err)
+		}
+		if r.ID != id {
+			t.Errorf("expected message %d, got %+v", id, r)
+		}
+	}
+	if err := readJSON(&Response{}, rdr); err != io.EOF {
+		t.Errorf("expected EOF at end of stream, got %v", err)
+	}
+
+	big := `{"id":1,"method":"` + strings.Repeat("x", MAX_REQUEST_SIZE) + `"}`
+	rdr = newJSONReader(strings.NewReader(big))
+	if err := readJSON(&Response{}, rdr); err == nil || err.Error() != "oversize request" {
+		t.Errorf("expected oversize request error, got %v", err)
+	}
+}
+
+func TestPoolCapabilities(t *testing.T) {
+	r := &loginResponse{}
+	data := `{"id":666,"jsonrpc":"2.0","result":{"id":"abc","job":{"blob":"00","job_id":"1","target":"ffff","algo":"rx/0","seed_hash":"aa","height":5,"net_diff":1000}},"warning":{"code":1,"message":"m","hints":{"start_diff":5000}},"chat_token":42}`
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		t.Fatal(err)
+	}
+	want := "jsonrpc=2.0 session_id=yes chat_token=yes warning=yes hints=start_diff algo=rx/0 seed_hash=yes height=yes net_diff=yes block_version=no self_select=no"
+	if got := poolCapabilities(r); got != want {
+		t.Errorf("got capabilities %q, want %q", got, want)
+	}
+
+	r = &loginResponse{}
+	data = `{"id":666,"result":{"job":{"blob":"00","job_id":"1","target":"ffff","pool_wallet":"w","blockMajorVersion":16}}}`
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		t.Fatal(err)
+	}
+	want = "jsonrpc=unspecified session_id=no chat_token=no warning=no hints=none algo=unspecified seed_hash=no height=no net_diff=no block_version=yes self_select=yes"
+	if got := poolCapabilities(r); got != want {
+		t.Errorf("got capabilities %q, want %q", got, want)
+	}
+}
+
+func TestLookupDoH(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/dns-json" || r.URL.Query().Get("name") != "pool.example" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("type") {
+		case "1":
+			io.WriteString(w, `{"Status":0,"Answer":[{"type":5,"data":"cdn.example."},{"type":1,"data":"192.0.2.1"}]}`)
+		case "28":
+			io.WriteString(w, `{"Status":0,"Answer":[{"type":28,"data":"2001:db8::1"}]}`)
+		}
+	}))
+	defer srv.Close()
+	addrs, err := lookupDoH(context.Background(), srv.URL, "pool.example")
+	if err != nil {
+		t.Fatal("lookupDoH failed:", err)
+	}
+	if len(addrs) != 2 || addrs[0].String() != "2001:db8::1" || addrs[1].String() != "192.0.2.1" {
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+	if _, err := lookupDoH(context.Background(), srv.URL, "other.example"); err == nil {
+		t.Error("expected lookup of unknown host to fail")
+	}
+
+	if err := SetDoH("http://1.1.1.1/dns-query", false); err == nil {
+		t.Error("expected non-https endpoint to be rejected")
+	}
+	if err := SetDoH("", false); err != nil {
+		t.Error("expected empty endpoint to restore the default:", err)
+	}
+	if u, _ := getDoH(); u != DEFAULT_DOH_URL {
+		t.Errorf("expected default endpoint, got %v", u)
+	}
+}
+
+func TestDoHTransport(t *testing.T) {
+	defer SetLocalAddr(nil)
+	tr := getDoHTransport()
+	if getDoHTransport() != tr {
+		t.Error("expected queries to share a transport")
+	}
+	SetLocalAddr(net.ParseIP("127.0.0.1"))
+	bound := getDoHTransport()
+	if bound == tr || getDoHTransport() != bound {
+		t.Error("expected queries from a new local address to share a new transport")
+	}
+}
+
+func TestSubmitWorkNotAlive(t *testing.T) {
+	cl := &Client{}
+	if _, err := cl.SubmitWork("00000000", "job1", nil, 0, nil); !errors.Is(err, ErrNotAlive) {
+		t.Errorf("expected ErrNotAlive from client that isn't connected, got %v", err)
+	}
+}
+
+func TestDispatchJobsReadTimeout(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	jobChan := make(chan *MultiClientJob, JOB_QUEUE_SIZE)
+	responseChan := make(chan *Response)
+	lastRead := new(int64)
+	start := time.Now()
+	go dispatchJobs(conn, newJSONReader(conn), jobChan, &MultiClientJob{}, responseChan, start, 200*time.Millisecond, lastRead)
+	<-jobChan // first job
+
+	// a message received before the timeout keeps the connection alive
+	time.Sleep(100 * time.Millisecond)
+	server.Write([]byte(`{"jsonrpc":"2.0","method":"job","params":{"job_id":"2","blob":"00","target":"ffffffff"}}` + "\n"))
+	if j := <-jobChan; j == nil || j.JobID != "2" {
+		t.Fatalf("expected job 2, got %+v", j)
+	}
+	if *lastRead < start.UnixNano() {
+		t.Error("expected time of last read to be updated")
+	}
+
+	// silence for longer than the timeout ends dispatching
+	select {
+	case j, ok := <-jobChan:
+		if ok {
+			t.Fatalf("expected job channel to be closed, got %+v", j)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dispatchJobs didn't time out")
+	}
+}
+
+func TestSetReadTimeout(t *testing.T) {
+	defer SetReadTimeout(0)
+	if err := SetReadTimeout(MIN_READ_TIMEOUT - time.Second); err == nil {
+		t.Error("expected error for read timeout below minimum")
+	}
+	if err := SetReadTimeout(time.Minute); err != nil || ReadTimeout() != time.Minute {
+		t.Errorf("expected read timeout of 1m, got %v, %v", ReadTimeout(), err)
+	}
+	if err := SetReadTimeout(0); err != nil || ReadTimeout() != DEFAULT_READ_TIMEOUT {
+		t.Errorf("expected default read timeout, got %v, %v", ReadTimeout(), err)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	good := []string{"socks5://127.0.0.1:9050", "http://proxy.example.com:8080", "https://proxy.example.com"}
+	for _, p := range good {
+		if u, err := ParseProxy(p); err != nil || u == nil {
+			t.Errorf("expected ParseProxy(%q) to succeed, got %v, %v", p, u, err)
+		}
+	}
+	if u, err := ParseProxy(""); err != nil || u != nil {
+		t.Errorf("expected no proxy for empty url, got %v, %v", u, err)
+	}
+	bad := []string{"ftp://proxy.example.com", "socks5://", "127.0.0.1:9050", "://"}
+	for _, p := range bad {
+		if _, err := ParseProxy(p); err == nil {
+			t.Errorf("expected ParseProxy(%q) to fail", p)
+		}
+	}
+}
+
+func TestDialProxy(t *testing.T) {
+	defer SetProxy(nil)
+
+	// the pool, which greets each connection so the test can tell it reached the pool
+	pool, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	go func() {
+		for {
+			c, err := pool.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "pool\n")
+			c.Close()
+		}
+	}()
+
+	// a proxy which runs handshake on each connection to learn the address to connect to, then
+	// relays the connection to it
+	serveProxy := func(l net.Listener, handshake func(c net.Conn, r *bufio.Reader) string) {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				target, err := net.Dial("tcp", handshake(c, r))
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				io.Copy(c, target)
+			}()
+		}
+	}
+	socks, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	go serveProxy(socks, func(c net.Conn, r *bufio.Reader) string {
+		// greeting: version, # of methods, methods. The reply selects "no authentication".
+		hdr := make([]byte, 2)
+		io.ReadFull(r, hdr)
+		io.ReadFull(r, make([]byte, hdr[1]))
+		c.Write([]byte{5, 0})
+		// request: version, command, reserved, address type (3 = domain name), length, name, port
+		req := make([]byte, 5)
+		io.ReadFull(r, req)
+		name := make([]byte, req[4]+2)
+		io.ReadFull(r, name)
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		port := int(name[req[4]])<<8 | int(name[req[4]+1])
+		return net.JoinHostPort(string(name[:req[4]]), strconv.Itoa(port))
+	})
+	connect, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connect.Close()
+	go serveProxy(connect, func(c net.Conn, r *bufio.Reader) string {
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Method != "CONNECT" {
+			return ""
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return req.Host
+	})
+
+	_, port, _ := net.SplitHostPort(pool.Addr().String())
+	for _, p := range []string{"socks5://" + socks.Addr().String(), "http://" + connect.Addr().String()} {
+		u, err := ParseProxy(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		SetProxy(u)
+		ct := &ConnectTimings{}
+		// the hostname is resolved by the proxy, so must be passed to it unresolved
+		conn, err := dialTCP(context.Background(), net.JoinHostPort("localhost", port), ct)
+		if err != nil {
+			t.Fatalf("expected successful dial via %s, got: %v", p, err)
+		}
+		greeting, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if greeting != "pool\n" {
+			t.Errorf("expected to reach the pool via %s, got %q, %v", p, greeting, err)
+		}
+		if ct.Connect <= 0 {
+			t.Errorf("expected connect timing via %s, got %+v", p, ct)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cryptonote-social/csminer/crylog"
+)
+
+const (
+	DIAL_TIMEOUT = 30 * time.Second
+
+	// How long to give the preferred address family a head start before racing a connection
+	// attempt over the other family (RFC 8305 recommends 250ms).
+	FALLBACK_DELAY = 300 * time.Millisecond
+
+	// Period of TCP keepalive probes on pool connections, letting the OS notice a peer that has
+	// gone away even while no requests are outstanding.
+	TCP_KEEPALIVE_PERIOD = 30 * time.Second
+)
+
+// ConnectTimings breaks down how long the steps of establishing a pool connection took. TLSHandshake
+// is 0 for connections not using TLS.
+type ConnectTimings struct {
+	DNS          time.Duration // resolving the pool's address
+	Connect      time.Duration // establishing the TCP connection
+	TLSHandshake time.Duration
+	Login        time.Duration // from sending the login request until its response was received
+}
+
+// TLSInfo describes the security of a TLS pool connection. Both fields are empty for connections
+// not using TLS.
+type TLSInfo struct {
+	Version     string // negotiated protocol version, e.g. "TLS 1.3"
+	CipherSuite string // negotiated cipher suite, e.g. "TLS_AES_128_GCM_SHA256"
+}
+
+// getTLSInfo returns the TLSInfo of conn, which is empty unless conn is a TLS connection.
+func getTLSInfo(conn net.Conn) TLSInfo {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return TLSInfo{}
+	}
+	cs := tc.ConnectionState()
+	return TLSInfo{Version: tlsVersionName(cs.Version), CipherSuite: tls.CipherSuiteName(cs.CipherSuite)}
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+var (
+	localAddrMutex sync.Mutex
+	localAddr      net.IP // source address of pool connections, or nil for the system's choice
+)
+
+// SetLocalAddr sets the source IP address that pool connections originate from, which determines
+// the network interface used on multi-homed machines. Only pool addresses of the same family
+// (IPv4 or IPv6) as ip will be connected to. A nil ip restores the system's choice.
+func SetLocalAddr(ip net.IP) {
+	localAddrMutex.Lock()
+	defer localAddrMutex.Unlock()
+	localAddr = ip
+}
+
+func getLocalAddr() net.IP {
+	localAddrMutex.Lock()
+	defer localAddrMutex.Unlock()
+	return localAddr
+}
+
+// newDialer returns the dialer for pool connections. Whenever a pool hostname resolves to both IPv6
+// and IPv4 addresses the dialer races the two families as specified by RFC 8305, so a broken path
+// for one doesn't stall the connect for the full timeout. onAttempt is called as each connection
+// attempt starts, i.e. after address resolution.
+func newDialer(onAttempt func()) *net.Dialer {
+	d := &net.Dialer{
+		FallbackDelay: FALLBACK_DELAY,
+		KeepAlive:     TCP_KEEPALIVE_PERIOD,
+		Control: func(network, address string, c syscall.RawConn) error {
+			onAttempt()
+			return nil
+		},
+	}
+	if local := getLocalAddr(); local != nil {
+		// the dialer only connects to addresses of the same family as the bind address
+		d.LocalAddr = &net.TCPAddr{IP: local}
+	}
+	return d
+}
+
+// dialTCP connects to the host:port address, resolving it with the system resolver or, should that
+// fail or be disabled, via DNS over HTTPS (see SetDoH), or through the proxy if one is set (see
+// SetProxy). The time taken by address resolution and connecting is recorded in t.
+func dialTCP(ctx context.Context, address string, t *ConnectTimings) (net.Conn, error) {
+	if u := getProxy(); u != nil {
+		return dialProxy(ctx, u, address, t)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var mutex sync.Mutex
+	var firstAttempt time.Time
+	d := newDialer(func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if firstAttempt.IsZero() {
+			firstAttempt = time.Now()
+		}
+	})
+
+	var conn net.Conn
+	endpoint, forced := getDoH()
+	if forced && net.ParseIP(host) == nil {
+		conn, err = dialDoH(ctx, d, endpoint, host, port)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", address)
+		var dnsErr *net.DNSError
+		if err != nil && errors.As(err, &dnsErr) {
+			crylog.Warn("DNS lookup failed, trying DNS over HTTPS:", err)
+			var dohErr error
+			if conn, dohErr = dialDoH(ctx, d, endpoint, host, port); dohErr == nil {
+				err = nil
+			} else {
+				crylog.Warn("Connecting via DNS over HTTPS failed:", dohErr)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	t.DNS = firstAttempt.Sub(start)
+	t.Connect = time.Since(firstAttempt)
+	return conn, nil
+}
+
+// dialTLS establishes a TLS connection over a connection obtained from dialTCP. The handshake is
+// run separately from the dial, rather than with tls.Dialer, so that the time it took can be
+// recorded in t along with the timings recorded by dialTCP.
+func dialTLS(ctx context.Context, address string, t *ConnectTimings) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialTCP(ctx, address, t)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	handshakeStart := time.Now()
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.TLSHandshake = time.Since(handshakeStart)
+	return tlsConn, nil
+}
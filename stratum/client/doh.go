@@ -0,0 +1,175 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	// Default DNS over HTTPS endpoint, which must support the JSON API (application/dns-json). It
+	// is specified by IP address so that using it doesn't itself depend on DNS.
+	DEFAULT_DOH_URL = "https://1.1.1.1/dns-query"
+
+	// DNS record types & response status we handle
+	DNS_TYPE_A       = 1
+	DNS_TYPE_AAAA    = 28
+	DNS_STATUS_OK    = 0
+	MAX_DOH_RESPONSE = 64 * 1024
+)
+
+var (
+	dohMutex  sync.Mutex
+	dohURL    = DEFAULT_DOH_URL
+	dohForced bool // if true, DoH is used instead of the system resolver rather than as a fallback
+
+	dohTransport     *http.Transport // see getDoHTransport
+	dohTransportAddr net.IP          // local address dohTransport's connections originate from
+)
+
+// SetDoH configures the DNS over HTTPS endpoint used to resolve the pool's hostname whenever the
+// system resolver fails, e.g. on networks that block or hijack plain DNS. If force is true, the
+// system resolver isn't used at all. An empty endpoint restores DEFAULT_DOH_URL.
+func SetDoH(endpoint string, force bool) error {
+	if endpoint == "" {
+		endpoint = DEFAULT_DOH_URL
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("DNS over HTTPS endpoint must be an https URL: " + endpoint)
+	}
+	dohMutex.Lock()
+	defer dohMutex.Unlock()
+	dohURL = endpoint
+	dohForced = force
+	return nil
+}
+
+func getDoH() (string, bool) {
+	dohMutex.Lock()
+	defer dohMutex.Unlock()
+	return dohURL, dohForced
+}
+
+// dialDoH connects to port of host via d, trying each of the addresses host resolves to via the DNS
+// over HTTPS endpoint in turn.
+func dialDoH(ctx context.Context, d *net.Dialer, endpoint, host, port string) (net.Conn, error) {
+	addrs, err := lookupDoH(ctx, endpoint, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		var conn net.Conn
+		if conn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(a.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// dohResponse is the subset of the DNS over HTTPS JSON API response we use.
+type dohResponse struct {
+	Status int
+	Answer []struct {
+		Type int    `json:"type"`
+		Data string `json:"data"`
+	}
+}
+
+// lookupDoH resolves the A & AAAA records of host via the DNS over HTTPS JSON API at endpoint.
+func lookupDoH(ctx context.Context, endpoint, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	var firstErr error
+	for _, qtype := range []int{DNS_TYPE_AAAA, DNS_TYPE_A} {
+		ips, err := queryDoH(ctx, endpoint, host, qtype)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		addrs = append(addrs, ips...)
+	}
+	if len(addrs) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, errors.New("no addresses found for host via DNS over HTTPS: " + host)
+	}
+	return addrs, nil
+}
+
+// getDoHTransport returns the transport for DNS over HTTPS queries, which is shared by all queries
+// made from the same local address (see SetLocalAddr) so that their connections are reused rather
+// than leaked.
+func getDoHTransport() *http.Transport {
+	local := getLocalAddr()
+	dohMutex.Lock()
+	defer dohMutex.Unlock()
+	if dohTransport != nil && dohTransportAddr.Equal(local) {
+		return dohTransport
+	}
+	if dohTransport != nil {
+		dohTransport.CloseIdleConnections()
+	}
+	dohTransport = http.DefaultTransport.(*http.Transport).Clone()
+	if local != nil {
+		d := &net.Dialer{LocalAddr: &net.TCPAddr{IP: local}}
+		dohTransport.DialContext = d.DialContext
+	}
+	dohTransportAddr = local
+	return dohTransport
+}
+
+func queryDoH(ctx context.Context, endpoint, host string, qtype int) ([]net.IPAddr, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("name", host)
+	q.Set("type", fmt.Sprint(qtype))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	resp, err := (&http.Client{Transport: getDoHTransport()}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("DNS over HTTPS request failed: " + resp.Status)
+	}
+	r := &dohResponse{}
+	if err := json.NewDecoder(&io.LimitedReader{R: resp.Body, N: MAX_DOH_RESPONSE}).Decode(r); err != nil {
+		return nil, err
+	}
+	if r.Status != DNS_STATUS_OK {
+		return nil, fmt.Errorf("DNS over HTTPS lookup of %s failed with status %d", host, r.Status)
+	}
+	var addrs []net.IPAddr
+	for _, a := range r.Answer {
+		if a.Type != qtype {
+			continue // e.g. a CNAME leading to the address records
+		}
+		if ip := net.ParseIP(a.Data); ip != nil {
+			addrs = append(addrs, net.IPAddr{IP: ip})
+		}
+	}
+	return addrs, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+var (
+	proxyMutex sync.Mutex
+	proxyURL   *url.URL // proxy pool connections are made through, or nil for direct connections
+)
+
+// ParseProxy parses and validates the URL of an http, https, or socks5 proxy, e.g.
+// socks5://127.0.0.1:9050. Returns nil for an empty rawURL.
+func ParseProxy(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("unsupported proxy scheme: " + u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy url is missing host")
+	}
+	return u, nil
+}
+
+// SetProxy configures the proxy, as returned by ParseProxy, through which pool connections are made.
+// The proxy resolves the pool's hostname itself, so neither the system resolver nor DNS over HTTPS
+// is used while a proxy is set. A nil u restores direct connections.
+func SetProxy(u *url.URL) {
+	proxyMutex.Lock()
+	defer proxyMutex.Unlock()
+	proxyURL = u
+}
+
+func getProxy() *url.URL {
+	proxyMutex.Lock()
+	defer proxyMutex.Unlock()
+	return proxyURL
+}
+
+// proxyHostPort returns the host:port of proxy u, filling in the scheme's default port if u has
+// none.
+func proxyHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	switch u.Scheme {
+	case "socks5":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	case "https":
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// dialProxy connects to the host:port address through proxy u. Since the proxy resolves address
+// itself, the whole time taken is recorded as t.Connect.
+func dialProxy(ctx context.Context, u *url.URL, address string, t *ConnectTimings) (net.Conn, error) {
+	start := time.Now()
+	d := newDialer(func() {})
+	var conn net.Conn
+	var err error
+	if u.Scheme == "socks5" {
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		var sd proxy.Dialer
+		if sd, err = proxy.SOCKS5("tcp", proxyHostPort(u), auth, d); err != nil {
+			return nil, err
+		}
+		conn, err = sd.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialHTTPProxy(ctx, d, u, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.DNS = 0
+	t.Connect = time.Since(start)
+	return conn, nil
+}
+
+// dialHTTPProxy connects to the host:port address through a tunnel established with a CONNECT
+// request to the http or https proxy u.
+func dialHTTPProxy(ctx context.Context, d *net.Dialer, u *url.URL, address string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, "tcp", proxyHostPort(u))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the pool sends nothing before the login request, so nothing past the response is buffered
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.New("proxy refused connection: " + resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
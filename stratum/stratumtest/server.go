@@ -0,0 +1,321 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+
+// Package stratumtest provides a mock pool server speaking the subset of the stratum protocol
+// used by csminer, for testing the client and miner against a real socket without the live pool.
+// It's intended for use by tests only and isn't part of any csminer binary.
+package stratumtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cryptonote-social/csminer/stratum/client"
+)
+
+const (
+	// A job that passes the miner's validity checks. Its target of "ffffffff" (difficulty 1) makes
+	// every hash a share.
+	DEFAULT_BLOB      = "0e0ea1d2c5f80585a0b0d4f1c95f60fa1c9fc5a9a7c1a9b3f9f4b8c2e6a3ab2c9b6e38f40000000039d4b2a5c1a1f9b1e0d0a37e4ea2ec4fb1a5f31de1e5b71c0e3a8b9cb9a2fc6d01"
+	DEFAULT_TARGET    = "ffffffff"
+	DEFAULT_SEED_HASH = "8e2b3c1e6dd67b2c4ba5a2e9a8f5e7c3d1b0a9f8e7d6c5b4a3928170f6e5d4c3"
+
+	// session ID given to the first login, with later logins receiving SESSION_ID-2, SESSION_ID-3,
+	// and so on
+	SESSION_ID = "stratumtest-session"
+
+	// how long a connection may sit idle before the server drops it
+	READ_TIMEOUT = time.Minute
+)
+
+// Login holds the parameters of a login request received by the server.
+type Login struct {
+	Login string `json:"login"`
+	Pass  string `json:"pass"`
+	RigID string `json:"rigid"`
+	Agent string `json:"agent"`
+
+	Session string `json:"-"` // session ID the server assigned to the login
+}
+
+// Submit holds the parameters of a submit request received by the server.
+type Submit struct {
+	ID        string              `json:"id"`
+	JobID     string              `json:"job_id"`
+	Nonce     string              `json:"nonce"`
+	Chats     []client.ChatToSend `json:"chats"`
+	ChatToken int64               `json:"chat_token"`
+
+	// set only by multiclient submits
+	ForUser       string `json:"for_user"`
+	ForRig        string `json:"for_rig"`
+	ForDifficulty int64  `json:"for_difficulty"`
+
+	// session ID assigned to the login of the connection the submit arrived over, which may differ
+	// from ID
+	ConnSession string `json:"-"`
+}
+
+// Server is a mock pool listening on a local port. Logins always succeed, receiving the current
+// job, and submits receive the canned result set with SetSubmitError, or are accepted by default.
+// Chats added with AddChat or sent along with accepted submits are served to get_chats requests
+// and to submits specifying a chat token, with the chat token being the number of chats already
+// delivered.
+type Server struct {
+	l net.Listener
+
+	mutex     sync.Mutex
+	conns     map[net.Conn]Login     // login request (and session) of each connection
+	job       *client.MultiClientJob // job sent with login responses
+	logins    []Login
+	submits   []Submit
+	submitErr *client.RPCError
+	chats     []client.ChatResult
+	stats     client.StatsResult
+}
+
+// NewServer starts a mock pool server on a localhost port. Call Close when done with it.
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		l:     l,
+		conns: map[net.Conn]Login{},
+		job:   NewJob("1"),
+		stats: client.StatsResult{PoolMargin: 0.01, PoolFee: 0.01},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// NewJob returns a valid job with the given id that can be customized before passing it to
+// PushJob.
+func NewJob(jobID string) *client.MultiClientJob {
+	j := &client.MultiClientJob{}
+	j.JobID = jobID
+	j.Blob = DEFAULT_BLOB
+	j.Target = DEFAULT_TARGET
+	j.Algo = "rx/0"
+	j.SeedHash = DEFAULT_SEED_HASH
+	j.Height = 2100000
+	return j
+}
+
+// Addr returns the host:port the server is listening on.
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and closes all its connections.
+func (s *Server) Close() {
+	s.l.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// DropSession closes the connection that logged in with the given session ID, if it's still open,
+// simulating the failure of a single connection.
+func (s *Server) DropSession(session string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c, l := range s.conns {
+		if l.Session == session {
+			c.Close()
+		}
+	}
+}
+
+// DropConnections closes all current connections while continuing to accept new ones, simulating
+// a network failure or pool restart.
+func (s *Server) DropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
+
+// PushJob sends job to every connected client, and to all clients that log in afterward.
+func (s *Server) PushJob(job *client.MultiClientJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.job = job
+	for c := range s.conns {
+		s.write(c, &struct {
+			Jsonrpc string                 `json:"jsonrpc"`
+			Method  string                 `json:"method"`
+			Params  *client.MultiClientJob `json:"params"`
+		}{"2.0", "job", job})
+	}
+}
+
+// SetSubmitError causes subsequent submits to be rejected with err, or accepted if err is nil.
+func (s *Server) SetSubmitError(err *client.RPCError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.submitErr = err
+}
+
+// AddChat queues a chat to be delivered to clients.
+func (s *Server) AddChat(username, message string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addChat(username, message)
+}
+
+// addChat queues a chat to be delivered to clients. mutex must be held.
+func (s *Server) addChat(username, message string) {
+	s.chats = append(s.chats, client.ChatResult{
+		Username:  username,
+		Message:   message,
+		ID:        int64(len(s.chats) + 1),
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// Logins returns the login requests received so far.
+func (s *Server) Logins() []Login {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Login(nil), s.logins...)
+}
+
+// Submits returns the submit requests received so far.
+func (s *Server) Submits() []Submit {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Submit(nil), s.submits...)
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns[c] = Login{}
+		s.mutex.Unlock()
+		go s.handle(c)
+	}
+}
+
+type request struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer func() {
+		c.Close()
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+	}()
+	scanner := bufio.NewScanner(c)
+	for {
+		c.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+		if !scanner.Scan() {
+			return
+		}
+		req := &request{}
+		if err := json.Unmarshal(scanner.Bytes(), req); err != nil {
+			return
+		}
+		s.mutex.Lock()
+		switch req.Method {
+		case "login":
+			l := Login{}
+			json.Unmarshal(req.Params, &l)
+			l.Session = SESSION_ID
+			if len(s.logins) > 0 {
+				l.Session += "-" + strconv.Itoa(len(s.logins)+1)
+			}
+			s.logins = append(s.logins, l)
+			s.conns[c] = l
+			s.respond(c, req.ID, &struct {
+				ID     string                 `json:"id"`
+				Job    *client.MultiClientJob `json:"job"`
+				Status string                 `json:"status"`
+			}{l.Session, s.job, "OK"}, nil)
+		case "submit":
+			sub := Submit{}
+			json.Unmarshal(req.Params, &sub)
+			sub.ConnSession = s.conns[c].Session
+			s.submits = append(s.submits, sub)
+			if s.submitErr != nil {
+				s.respond(c, req.ID, nil, s.submitErr)
+				break
+			}
+			for _, ch := range sub.Chats {
+				s.addChat(s.conns[c].Login, ch.Message)
+			}
+			r := &client.SubmitWorkResult{Status: "OK", StatsResult: s.stats}
+			if sub.ChatToken != 0 {
+				r.ChatsResult = s.chatsSince(sub.ChatToken)
+			}
+			s.respond(c, req.ID, r, nil)
+		case "get_chats":
+			p := &struct {
+				ChatToken   int64 `json:"chat_token"`
+				UpdateStats bool  `json:"update_stats"`
+			}{}
+			json.Unmarshal(req.Params, p)
+			r := s.chatsSince(p.ChatToken)
+			if r == nil {
+				r = &client.GetChatsResult{NextToken: p.ChatToken}
+			}
+			if p.UpdateStats {
+				st := s.stats
+				r.StatsResult = &st
+			}
+			s.respond(c, req.ID, r, nil)
+		default:
+			s.respond(c, req.ID, nil, &client.RPCError{Code: -1, Message: "unknown method: " + req.Method})
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// chatsSince returns the chats from index token on, or nil if there are none. mutex must be held.
+func (s *Server) chatsSince(token int64) *client.GetChatsResult {
+	if token < 0 || token >= int64(len(s.chats)) {
+		return nil
+	}
+	return &client.GetChatsResult{
+		Chats:     append([]client.ChatResult(nil), s.chats[token:]...),
+		NextToken: int64(len(s.chats)),
+	}
+}
+
+// respond writes a response to the request with the given id. mutex must be held.
+func (s *Server) respond(c net.Conn, id uint64, result interface{}, rpcErr *client.RPCError) {
+	s.write(c, &struct {
+		ID      uint64           `json:"id"`
+		Jsonrpc string           `json:"jsonrpc"`
+		Result  interface{}      `json:"result,omitempty"`
+		Error   *client.RPCError `json:"error,omitempty"`
+	}{id, "2.0", result, rpcErr})
+}
+
+// write sends msg as a newline delimited JSON message. Write errors are ignored since the reading
+// goroutine will notice the broken connection. mutex must be held.
+func (s *Server) write(c net.Conn, msg interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic("stratumtest: can't marshal message: " + err.Error())
+	}
+	c.SetWriteDeadline(time.Now().Add(READ_TIMEOUT))
+	c.Write(append(b, '\n'))
+}
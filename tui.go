@@ -0,0 +1,221 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package csminer
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cryptonote-social/csminer/minerlib"
+	"github.com/cryptonote-social/csminer/minerlib/chat"
+)
+
+const (
+	DASHBOARD_REFRESH_INTERVAL = time.Second
+
+	// dashboard layout
+	DASHBOARD_WIDTH            = 100 // lines longer than this many characters are truncated
+	DASHBOARD_HASHRATE_SAMPLES = 60  // # of recent hashrate samples graphed
+	DASHBOARD_STATE_LINES      = 10
+	DASHBOARD_CHAT_LINES       = 5
+	DASHBOARD_LOG_LINES        = 8
+)
+
+var (
+	// dash is the dashboard being displayed when running with -tui, or nil otherwise.
+	dash *dashboard
+
+	sparkRunes = []rune("▁▂▃▄▅▆▇█")
+)
+
+// dashboard renders a live-updating view of the mining state to a terminal using ANSI escape
+// codes, in place of the periodic stats printouts. It also serves as the log writer so that log
+// output appears in its own pane rather than scrolling the dashboard away. Keyboard commands are
+// entered on the prompt line below the dashboard.
+type dashboard struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	state     *minerlib.GetMiningStateResponse
+	hashrates []float64 // most recent DASHBOARD_HASHRATE_SAMPLES hashrate samples
+	chats     []string  // most recent DASHBOARD_CHAT_LINES chats
+	logLines  []string  // most recent DASHBOARD_LOG_LINES log lines
+}
+
+func newDashboard(out io.Writer) *dashboard {
+	return &dashboard{out: out}
+}
+
+// run updates the dashboard with fresh mining state every DASHBOARD_REFRESH_INTERVAL. Never
+// returns.
+func (d *dashboard) run() {
+	fmt.Fprint(d.out, "\033[2J")
+	d.resetPrompt()
+	ch, _ := minerlib.StreamStats(DASHBOARD_REFRESH_INTERVAL)
+	for s := range ch {
+		for c := chat.NextChatReceived(); c != nil; c = chat.NextChatReceived() {
+			if _, ok := chatsSent[c.ID]; !ok {
+				d.addChat(c.Username, c.Timestamp, c.Message)
+			}
+		}
+		d.update(s)
+	}
+}
+
+// Write captures a log line for display in the log pane.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, l := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.logLines = appendRecent(d.logLines, l, DASHBOARD_LOG_LINES)
+	}
+	return len(p), nil
+}
+
+func (d *dashboard) addChat(unm string, ts int64, msg string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	line := "[" + time.Unix(ts, 0).Format("15:04") + "] " + unm + ": " + strings.ReplaceAll(msg, "\n", " ")
+	d.chats = appendRecent(d.chats, line, DASHBOARD_CHAT_LINES)
+}
+
+// update records a new mining state snapshot and redraws the dashboard.
+func (d *dashboard) update(s *minerlib.GetMiningStateResponse) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.state = s
+	hr := s.RecentHashrate
+	if hr < 0.0 || s.MiningActivity < 0 {
+		hr = 0.0
+	}
+	d.hashrates = append(d.hashrates, hr)
+	if len(d.hashrates) > DASHBOARD_HASHRATE_SAMPLES {
+		d.hashrates = d.hashrates[1:]
+	}
+	d.render()
+}
+
+// refresh redraws the dashboard with the most recent mining state.
+func (d *dashboard) refresh() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.render()
+}
+
+// resetPrompt clears everything below the dashboard and leaves the cursor at the start of the
+// prompt line. Should be called after each line of keyboard input since its echo moves the cursor.
+func (d *dashboard) resetPrompt() {
+	fmt.Fprintf(d.out, "\033[%d;1H\033[J> ", d.height()+1)
+}
+
+// render draws the dashboard at the top of the screen without disturbing the cursor position on
+// the prompt line. d.mutex must be held.
+func (d *dashboard) render() {
+	var b strings.Builder
+	b.WriteString("\0337\033[H") // save cursor & move home
+	for _, l := range d.lines() {
+		if r := []rune(l); len(r) > DASHBOARD_WIDTH {
+			l = string(r[:DASHBOARD_WIDTH])
+		}
+		b.WriteString(l)
+		b.WriteString("\033[K\r\n") // clear remainder of the line
+	}
+	b.WriteString("\0338") // restore cursor
+	io.WriteString(d.out, b.String())
+}
+
+func (d *dashboard) height() int {
+	return DASHBOARD_STATE_LINES + DASHBOARD_CHAT_LINES + DASHBOARD_LOG_LINES + 3 // +3 for the separators
+}
+
+// lines returns the lines of the dashboard, which always number d.height(). d.mutex must be held.
+func (d *dashboard) lines() []string {
+	l := []string{fmt.Sprintf("==== %s v%s ====", APPLICATION_NAME, VERSION_STRING)}
+	s := d.state
+	if s == nil {
+		l = append(l, "Starting...")
+	} else {
+		l = append(l, "Status     : Mining "+getActivityMessage(s.MiningActivity))
+		threads := strconv.Itoa(s.Threads)
+		if s.ConfiguredThreads > s.Threads {
+			threads += " (of " + strconv.Itoa(s.ConfiguredThreads) + " configured)"
+		}
+		if s.Intensity < 100 {
+			threads += " at " + strconv.Itoa(s.Intensity) + "% intensity"
+		}
+		l = append(l, "Threads    : "+threads)
+		recent := "--calculating-- " + calculatingProgress(&s.Snapshot)
+		if s.RecentHashrate >= 0.0 {
+			recent = strconv.FormatFloat(s.RecentHashrate, 'f', 2, 64)
+			if s.RecentHashrateProvisional {
+				recent += " (provisional)"
+			}
+		}
+		l = append(l,
+			"Hashrate   : "+recent+"   since inception: "+strconv.FormatFloat(s.Hashrate, 'f', 2, 64)+
+				"   effective: "+strconv.FormatFloat(s.EffectiveHashrate, 'f', 2, 64),
+			"             "+sparkline(d.hashrates),
+			fmt.Sprintf("Shares     : %d accepted, %d rejected, %d abandoned", s.SharesAccepted, s.SharesRejected, s.SharesAbandoned))
+		if s.SecondsOld >= 0.0 {
+			l = append(l,
+				"Pool user  : "+s.PoolUsername,
+				"Paid       : "+strconv.FormatFloat(s.Paid, 'f', 12, 64)+" $XMR   owed: "+strconv.FormatFloat(s.Owed, 'f', 12, 64)+" $XMR",
+				"Next reward: "+s.TimeToReward+" (est.), accumulated "+strconv.FormatFloat(s.Accumulated, 'f', 12, 64)+" $XMR")
+		} else {
+			l = append(l, "Pool user  : --fetching pool stats--", "", "")
+		}
+		if s.IsDonating {
+			l = append(l, "Donating   : no -user specified, so mining on behalf of donate.getmonero.org")
+		} else {
+			l = append(l, "")
+		}
+	}
+	l = fitLines(l, DASHBOARD_STATE_LINES)
+	l = append(l, "---- Chats ----")
+	l = append(l, fitLines(d.chats, DASHBOARD_CHAT_LINES)...)
+	l = append(l, "---- Log ----")
+	l = append(l, fitLines(d.logLines, DASHBOARD_LOG_LINES)...)
+	l = append(l, "---- i/d: threads  z: pause  <enter>: override  c <msg>: chat  donate <pct>  q: quit ----")
+	return l
+}
+
+// fitLines returns the first n lines of l, padded with empty lines if l has fewer.
+func fitLines(l []string, n int) []string {
+	if len(l) > n {
+		return l[:n]
+	}
+	r := make([]string, n)
+	copy(r, l)
+	return r
+}
+
+// appendRecent appends line to lines, dropping the oldest lines so that at most max remain.
+func appendRecent(lines []string, line string, max int) []string {
+	lines = append(lines, line)
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines
+}
+
+// sparkline graphs values as a string of block characters scaled to the largest value.
+func sparkline(values []float64) string {
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	r := make([]rune, len(values))
+	for i, v := range values {
+		j := 0
+		if max > 0.0 && v > 0.0 {
+			j = int(v/max*float64(len(sparkRunes)-1) + 0.5)
+		}
+		r[i] = sparkRunes[j]
+	}
+	return string(r)
+}
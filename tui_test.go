@@ -0,0 +1,41 @@
+package csminer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cryptonote-social/csminer/minerlib"
+)
+
+func TestSparkline(t *testing.T) {
+	if s := sparkline([]float64{0, 50, 100}); s != "▁▅█" {
+		t.Errorf("unexpected sparkline: %v", s)
+	}
+	if s := sparkline([]float64{0, 0}); s != "▁▁" {
+		t.Errorf("unexpected sparkline for zero values: %v", s)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	var out bytes.Buffer
+	d := newDashboard(&out)
+	if n := len(d.lines()); n != d.height() {
+		t.Errorf("expected %v lines before any state, got %v", d.height(), n)
+	}
+	for i := 0; i < DASHBOARD_LOG_LINES+2; i++ {
+		d.Write([]byte("log line\n"))
+	}
+	d.Write([]byte("last line\n"))
+	if len(d.logLines) != DASHBOARD_LOG_LINES || d.logLines[DASHBOARD_LOG_LINES-1] != "last line" {
+		t.Errorf("expected only the most recent log lines to be kept, got %v", d.logLines)
+	}
+	d.addChat("someone", 0, "hello\nthere")
+	d.update(&minerlib.GetMiningStateResponse{Threads: 2, ConfiguredThreads: 2, Intensity: 100})
+	if n := len(d.lines()); n != d.height() {
+		t.Errorf("expected %v lines, got %v", d.height(), n)
+	}
+	if !strings.Contains(out.String(), "someone: hello there") {
+		t.Errorf("expected chat in rendered dashboard, got %q", out.String())
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 cryptonote.social. All rights reserved. Use of this source code is governed by
+// the license found in the LICENSE file.
+package csminer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cryptonote-social/csminer/crylog"
+	"github.com/cryptonote-social/csminer/minerlib"
+)
+
+const (
+	// events awaiting delivery to the webhook beyond this many are dropped
+	WEBHOOK_QUEUE_SIZE = 16
+	WEBHOOK_TIMEOUT    = 10 * time.Second
+
+	// values of the event field of webhook payloads
+	WEBHOOK_SHARE_ACCEPTED = "share_accepted"
+	WEBHOOK_BLOCK_FOUND    = "block_found"
+)
+
+// webhookEvent is the JSON payload posted to the webhook.
+type webhookEvent struct {
+	Event             string `json:"event"`
+	Label             string `json:"label,omitempty"` // see minerlib.InitMinerArgs.Label
+	JobID             string `json:"job_id"`
+	Difficulty        int64  `json:"difficulty"`
+	NetworkDifficulty int64  `json:"network_difficulty,omitempty"` // block_found only
+	Timestamp         int64  `json:"timestamp"`                    // unix time of the event
+
+	// session totals as of the event
+	SharesAccepted int64 `json:"shares_accepted"`
+	SharesRejected int64 `json:"shares_rejected"`
+	BlocksFound    int64 `json:"blocks_found"`
+}
+
+// webhook posts share events to a user-configured URL from a single goroutine, dropping events
+// rather than letting a slow webhook back up.
+type webhook struct {
+	url    string
+	client *http.Client
+	events chan *webhookEvent
+}
+
+func newWebhook(u string) (*webhook, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return nil, errors.New("webhook must be an http or https URL: " + u)
+	}
+	return &webhook{
+		url:    u,
+		client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		events: make(chan *webhookEvent, WEBHOOK_QUEUE_SIZE),
+	}, nil
+}
+
+// run delivers queued events until the process exits.
+func (w *webhook) run() {
+	for e := range w.events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			crylog.Error("Failed to marshal webhook event:", err)
+			continue
+		}
+		resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			crylog.Warn("Webhook failed:", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			crylog.Warn("Webhook returned status:", resp.Status)
+		}
+	}
+}
+
+// send queues e for delivery, dropping it if the queue is full.
+func (w *webhook) send(e *webhookEvent) {
+	s := minerlib.GetMiningState()
+	e.Timestamp = time.Now().Unix()
+	e.Label = s.Label
+	e.SharesAccepted = s.SharesAccepted
+	e.SharesRejected = s.SharesRejected
+	e.BlocksFound = s.BlocksFound
+	select {
+	case w.events <- e:
+	default:
+		crylog.Warn("Webhook is falling behind, dropping", e.Event, "event")
+	}
+}
+
+func (w *webhook) shareAccepted(jobID string, difficulty int64) {
+	w.send(&webhookEvent{Event: WEBHOOK_SHARE_ACCEPTED, JobID: jobID, Difficulty: difficulty})
+}
+
+func (w *webhook) blockFound(jobID string, hashDifficulty, networkDifficulty int64) {
+	w.send(&webhookEvent{
+		Event:             WEBHOOK_BLOCK_FOUND,
+		JobID:             jobID,
+		Difficulty:        hashDifficulty,
+		NetworkDifficulty: networkDifficulty,
+	})
+}
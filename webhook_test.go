@@ -0,0 +1,58 @@
+package csminer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	if _, err := newWebhook("ftp://example.com"); err == nil {
+		t.Error("expected error for non-http webhook")
+	}
+	if _, err := newWebhook("example.com/hook"); err == nil {
+		t.Error("expected error for webhook without scheme")
+	}
+
+	got := make(chan *webhookEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &webhookEvent{}
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+		}
+		got <- e
+	}))
+	defer srv.Close()
+	w, err := newWebhook(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go w.run()
+	defer close(w.events)
+
+	w.blockFound("job1", 5000, 4000)
+	select {
+	case e := <-got:
+		if e.Event != WEBHOOK_BLOCK_FOUND || e.JobID != "job1" || e.Difficulty != 5000 || e.NetworkDifficulty != 4000 || e.Timestamp == 0 {
+			t.Errorf("unexpected webhook payload: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestWebhookDropsWhenFull(t *testing.T) {
+	w, err := newWebhook("http://localhost/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing is delivering events, so sends beyond the queue size must not block
+	for i := 0; i < WEBHOOK_QUEUE_SIZE+1; i++ {
+		w.shareAccepted("job1", 1000)
+	}
+	if len(w.events) != WEBHOOK_QUEUE_SIZE {
+		t.Errorf("expected a full queue of %v events, got %v", WEBHOOK_QUEUE_SIZE, len(w.events))
+	}
+}
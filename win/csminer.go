@@ -5,6 +5,9 @@ package main
 // main() for the Windows version of csminer with support for Windows machine state monitoring.
 
 import (
+	"os"
+	"os/exec"
+	"strings"
 	"syscall"
 	"time"
 	"unsafe"
@@ -17,6 +20,56 @@ import (
 
 type WinMachineStater struct {
 	lockedOnStartup bool
+	batteryPercent  chan int
+}
+
+// LowerPriority sets the priority class of the process to idle.
+func (ss *WinMachineStater) LowerPriority() error {
+	return windows.SetPriorityClass(windows.CurrentProcess(), windows.IDLE_PRIORITY_CLASS)
+}
+
+// RunningProcesses returns the executable names of the running processes from a snapshot of the
+// process list.
+func (ss *WinMachineStater) RunningProcesses() ([]string, error) {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snap)
+	var e windows.ProcessEntry32
+	e.Size = uint32(unsafe.Sizeof(e))
+	names := []string{}
+	for err = windows.Process32First(snap, &e); err == nil; err = windows.Process32Next(snap, &e) {
+		names = append(names, windows.UTF16ToString(e.ExeFile[:]))
+	}
+	if err != windows.ERROR_NO_MORE_FILES {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Notify shows a toast notification using the Windows runtime notification API via PowerShell.
+// Notifications are attributed to PowerShell since csminer has no registered app user model ID.
+func (ss *WinMachineStater) Notify(title, message string) error {
+	script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$x = $t.GetElementsByTagName('text')
+$x.Item(0).AppendChild($t.CreateTextNode('` + psString(title) + `')) > $null
+$x.Item(1).AppendChild($t.CreateTextNode('` + psString(message) + `')) > $null
+$id = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($id).Show([Windows.UI.Notifications.ToastNotification]::new($t))`
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd.Run()
+}
+
+// psString escapes s for use within a single quoted PowerShell string.
+func psString(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+func (ss *WinMachineStater) GetBatteryPercentChannel() (chan int, error) {
+	return ss.batteryPercent, nil
 }
 
 // We assume the screen is active when the miner is started. This may
@@ -32,6 +85,7 @@ func (ss *WinMachineStater) GetMachineStateChannel(saver bool) (chan csminer.Mac
 		currentlyLocked := false
 		isIdle := false
 		batteryPower := false
+		batteryPercent := -1
 		for {
 			select {
 			case m := <-chanMessages:
@@ -57,10 +111,18 @@ func (ss *WinMachineStater) GetMachineStateChannel(saver bool) (chan csminer.Mac
 				}
 				close(m.ChanOk)
 			case <-time.After(10 * time.Second):
-				b, err := isBatteryPower()
+				b, pct, err := getBatteryPowerState()
 				if err != nil {
 					crylog.Error("failed to get battery power state:", err)
 				} else {
+					if pct != batteryPercent {
+						batteryPercent = pct
+						select {
+						case ss.batteryPercent <- pct:
+						default:
+							// don't stall state monitoring if nobody is consuming battery levels
+						}
+					}
 					if b != batteryPower {
 						if b {
 							crylog.Info("Detected battery power")
@@ -104,8 +166,8 @@ func (ss *WinMachineStater) GetMachineStateChannel(saver bool) (chan csminer.Mac
 }
 
 func main() {
-	ss := WinMachineStater{lockedOnStartup: false}
-	csminer.MultiMain(&ss, "csminer "+csminer.VERSION_STRING+" (win)")
+	ss := WinMachineStater{lockedOnStartup: false, batteryPercent: make(chan int, 1)}
+	os.Exit(csminer.MultiMain(&ss, "csminer "+csminer.VERSION_STRING+" (win)"))
 }
 
 var libuser32 *windows.LazyDLL
@@ -141,16 +203,19 @@ type systemPowerStatus struct {
 	batterFullLifeTime uint32
 }
 
-func isBatteryPower() (bool, error) {
+// getBatteryPowerState returns true if the machine is running on battery power, along with the
+// battery charge percentage, which will be -1 if unknown (e.g. there is no battery).
+func getBatteryPowerState() (bool, int, error) {
 	getSystemPowerStatus := libkernel32.NewProc("GetSystemPowerStatus")
 
 	var s systemPowerStatus
 	res, _, err := syscall.Syscall(getSystemPowerStatus.Addr(), 1, uintptr(unsafe.Pointer(&s)), 0, 0)
 	if res == 0 {
-		return false, err
+		return false, -1, err
 	}
-	if s.aclineStatus == 0 {
-		return true, nil
+	pct := int(s.batteryLifePercent)
+	if pct > 100 {
+		pct = -1 // 255 indicates unknown status
 	}
-	return false, nil
+	return s.aclineStatus == 0, pct, nil
 }